@@ -0,0 +1,334 @@
+package collections
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/blang/semver/v4"
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// the MANIFEST.json and FILES.json format ansible-galaxy writes
+const manifestFormat = 1
+
+/*
+GalaxyYAML is a collection's galaxy.yml. The fields are in alphabetical
+order because that's the order ansible-galaxy writes collection_info in.
+*/
+type GalaxyYAML struct {
+	Authors       stringList        `yaml:"authors" json:"authors"`
+	Dependencies  map[string]string `yaml:"dependencies" json:"dependencies"`
+	Description   *string           `yaml:"description" json:"description"`
+	Documentation *string           `yaml:"documentation" json:"documentation"`
+	Homepage      *string           `yaml:"homepage" json:"homepage"`
+	Issues        *string           `yaml:"issues" json:"issues"`
+	License       stringList        `yaml:"license" json:"license"`
+	LicenseFile   *string           `yaml:"license_file" json:"license_file"`
+	Name          string            `yaml:"name" json:"name"`
+	Namespace     string            `yaml:"namespace" json:"namespace"`
+	Readme        string            `yaml:"readme" json:"readme"`
+	Repository    *string           `yaml:"repository" json:"repository"`
+	Tags          stringList        `yaml:"tags" json:"tags"`
+	Version       string            `yaml:"version" json:"version"`
+
+	BuildIgnore []string         `yaml:"build_ignore" json:"-"`
+	Manifest    *ManifestControl `yaml:"manifest" json:"-"`
+	// whether galaxy.yml has a manifest key at all, "manifest: {}" turns on the defaults
+	hasManifest bool
+}
+
+// stringList accepts a single string where galaxy.yml expects a list, like ansible-galaxy does
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*l = stringList{single}
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// collection namespaces and names are python identifiers
+var collectionNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ReadGalaxyYAML loads and checks the galaxy.yml (or galaxy.yaml) in a collection source tree
+func ReadGalaxyYAML(srcDir string) (GalaxyYAML, error) {
+	var meta GalaxyYAML
+	var data []byte
+	var err error
+	var filename string
+	for _, filename = range []string{"galaxy.yml", "galaxy.yaml"} {
+		data, err = os.ReadFile(filepath.Join(srcDir, filename))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return meta, laxerrors.New(laxerrors.ErrNotFound, "%s has no galaxy.yml", srcDir)
+	}
+
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return meta, laxerrors.Wrap(laxerrors.ErrUsage, err, "failed to parse %s", filename)
+	}
+	var keys map[string]interface{}
+	if err := yaml.Unmarshal(data, &keys); err == nil {
+		_, meta.hasManifest = keys["manifest"]
+	}
+
+	missing := []string{}
+	for key, value := range map[string]bool{
+		"namespace": meta.Namespace == "",
+		"name":      meta.Name == "",
+		"version":   meta.Version == "",
+		"readme":    meta.Readme == "",
+		"authors":   len(meta.Authors) == 0,
+	} {
+		if value {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return meta, laxerrors.New(laxerrors.ErrUsage, "%s is missing the required keys %s", filename, strings.Join(missing, ", "))
+	}
+	for _, part := range []string{meta.Namespace, meta.Name} {
+		if !collectionNamePattern.MatchString(part) {
+			return meta, laxerrors.New(laxerrors.ErrUsage, "%s.%s is not a valid collection name", meta.Namespace, meta.Name)
+		}
+	}
+	if _, err := semver.Parse(meta.Version); err != nil {
+		return meta, laxerrors.Wrap(laxerrors.ErrUsage, err, "%s has an invalid version %q", filename, meta.Version)
+	}
+	if len(meta.BuildIgnore) > 0 && meta.hasManifest {
+		return meta, laxerrors.New(laxerrors.ErrUsage, "%s can't use both build_ignore and manifest", filename)
+	}
+	// ansible-galaxy writes missing lists and dicts as empty ones, not null
+	for _, list := range []*stringList{&meta.Authors, &meta.License, &meta.Tags} {
+		if *list == nil {
+			*list = stringList{}
+		}
+	}
+	if meta.Dependencies == nil {
+		meta.Dependencies = map[string]string{}
+	}
+	return meta, nil
+}
+
+// Build builds the collection in args[0] (or the current directory) into --output-path
+func Build(kwargs *types.CmdKwargs, args []string) error {
+	srcDir := "."
+	if len(args) > 1 {
+		return laxerrors.New(laxerrors.ErrUsage, "build takes at most one collection directory")
+	}
+	if len(args) == 1 {
+		srcDir = args[0]
+	}
+	outputDir := kwargs.DestDir
+	if outputDir == "" {
+		outputDir = "."
+	}
+
+	artifact, err := BuildCollection(srcDir, outputDir, kwargs.Force)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Created collection at %s\n", artifact)
+	return nil
+}
+
+/*
+BuildCollection writes <namespace>-<name>-<version>.tar.gz for the
+collection in srcDir to outputDir, with the same MANIFEST.json, FILES.json
+and layout as ansible-galaxy collection build. The files are always in the
+same order and every entry has the same mtime (SOURCE_DATE_EPOCH if set),
+so building the same tree twice gives the same bytes.
+*/
+func BuildCollection(srcDir string, outputDir string, force bool) (string, error) {
+	root, err := utils.GetAbsPath(srcDir)
+	if err != nil {
+		return "", err
+	}
+	if !utils.IsDir(root) {
+		return "", laxerrors.New(laxerrors.ErrNotFound, "%s is not a directory", srcDir)
+	}
+	meta, err := ReadGalaxyYAML(root)
+	if err != nil {
+		return "", err
+	}
+
+	var files []utils.ArchiveEntry
+	if meta.hasManifest {
+		files, err = filesFromManifestDirectives(root, meta)
+	} else {
+		files, err = filesFromBuildIgnore(root, meta)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	filesJSON, err := galaxyJSON(filesManifest(files))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(filesJSON)
+	manifestJSON, err := galaxyJSON(collectionManifest{
+		CollectionInfo: meta,
+		FileManifestFile: filesManifestEntry{
+			ChecksumSha256: ptr(hex.EncodeToString(sum[:])),
+			ChecksumType:   ptr("sha256"),
+			Format:         manifestFormat,
+			FType:          "file",
+			Name:           "FILES.json",
+		},
+		Format: manifestFormat,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	outDir, err := utils.GetAbsPath(utils.ExpandUser(outputDir))
+	if err != nil {
+		return "", err
+	}
+	if err := utils.MakeDirs(outDir); err != nil {
+		return "", err
+	}
+	artifact := filepath.Join(outDir, fmt.Sprintf("%s-%s-%s.tar.gz", meta.Namespace, meta.Name, meta.Version))
+	if utils.FileExists(artifact) && !force {
+		return "", laxerrors.New(laxerrors.ErrConflict, "%s already exists, use --force to replace it", artifact)
+	}
+
+	// MANIFEST.json and FILES.json come first, like ansible-galaxy writes them
+	entries := append([]utils.ArchiveEntry{
+		{Name: "MANIFEST.json", Data: manifestJSON},
+		{Name: "FILES.json", Data: filesJSON},
+	}, files...)
+	if err := utils.WriteTarGz(artifact+utils.PartSuffix, entries, utils.SourceDateEpoch()); err != nil {
+		os.Remove(artifact + utils.PartSuffix)
+		return "", err
+	}
+	return artifact, os.Rename(artifact+utils.PartSuffix, artifact)
+}
+
+type filesManifestEntry struct {
+	ChecksumSha256 *string `json:"chksum_sha256"`
+	ChecksumType   *string `json:"chksum_type"`
+	Format         int     `json:"format"`
+	FType          string  `json:"ftype"`
+	Name           string  `json:"name"`
+}
+
+type collectionManifest struct {
+	CollectionInfo   GalaxyYAML         `json:"collection_info"`
+	FileManifestFile filesManifestEntry `json:"file_manifest_file"`
+	Format           int                `json:"format"`
+}
+
+func filesManifest(files []utils.ArchiveEntry) interface{} {
+	entries := []filesManifestEntry{{Format: manifestFormat, FType: "dir", Name: "."}}
+	for _, f := range files {
+		if f.Dir {
+			entries = append(entries, filesManifestEntry{Format: manifestFormat, FType: "dir", Name: f.Name})
+			continue
+		}
+		entries = append(entries, filesManifestEntry{
+			ChecksumSha256: ptr(f.Sha256),
+			ChecksumType:   ptr("sha256"),
+			Format:         manifestFormat,
+			FType:          "file",
+			Name:           f.Name,
+		})
+	}
+	return struct {
+		Files  []filesManifestEntry `json:"files"`
+		Format int                  `json:"format"`
+	}{entries, manifestFormat}
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+/*
+galaxyJSON encodes like python's json.dumps(v, indent=True, sort_keys=True),
+which is what ansible-galaxy writes: one space indents, no trailing newline,
+html characters left alone and everything outside ascii \u escaped.
+*/
+func galaxyJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", " ")
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	for _, r := range strings.TrimSuffix(buf.String(), "\n") {
+		switch {
+		case r < 0x80:
+			out.WriteRune(r)
+		case r > 0xffff:
+			r1, r2 := utf16.EncodeRune(r)
+			fmt.Fprintf(&out, `\u%04x\u%04x`, r1, r2)
+		default:
+			fmt.Fprintf(&out, `\u%04x`, r)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// filesFromBuildIgnore walks the tree like ansible-galaxy does when galaxy.yml uses build_ignore
+func filesFromBuildIgnore(root string, meta GalaxyYAML) ([]utils.ArchiveEntry, error) {
+	patterns := []string{
+		"MANIFEST.json",
+		"FILES.json",
+		"galaxy.yml",
+		"galaxy.yaml",
+		".git",
+		"*.pyc",
+		"*.retry",
+		// ansible-test results
+		"tests/output",
+		// artifacts from earlier builds
+		fmt.Sprintf("%s-%s-*.tar.gz", meta.Namespace, meta.Name),
+	}
+	patterns = append(patterns, meta.BuildIgnore...)
+	ignore := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		ignore = append(ignore, regexp.MustCompile(`^(?s:`+fnmatchRegexp(p, false)+`)$`))
+	}
+	ignoredDirs := map[string]bool{"CVS": true, ".bzr": true, ".hg": true, ".git": true, ".svn": true, "__pycache__": true, ".tox": true}
+
+	ignored := func(rel string) bool {
+		for _, re := range ignore {
+			if re.MatchString(rel) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return utils.ArchiveEntriesFromDir(root, func(rel string, info os.FileInfo) bool {
+		return ignored(rel) || (info.IsDir() && ignoredDirs[path.Base(rel)])
+	})
+}
@@ -0,0 +1,265 @@
+package collections
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// ManifestControl is the manifest key of galaxy.yml
+type ManifestControl struct {
+	Directives            []string `yaml:"directives"`
+	OmitDefaultDirectives bool     `yaml:"omit_default_directives"`
+}
+
+// the plugin types ansible can document, their yaml docs are included by default
+var documentablePluginTypes = []string{
+	"become", "cache", "callback", "cliconf", "connection", "filter", "httpapi",
+	"inventory", "lookup", "netconf", "shell", "strategy", "test", "vars",
+}
+
+// manifestDirectives is the full list ansible-galaxy runs for a manifest key
+func manifestDirectives(meta GalaxyYAML) ([]string, error) {
+	control := ManifestControl{}
+	if meta.Manifest != nil {
+		control = *meta.Manifest
+	}
+	if control.OmitDefaultDirectives && len(control.Directives) == 0 {
+		return nil, laxerrors.New(laxerrors.ErrUsage, "manifest.directives must be set when manifest.omit_default_directives is true")
+	}
+
+	directives := []string{}
+	if !control.OmitDefaultDirectives {
+		directives = append(directives,
+			"include meta/*.yml",
+			"include *.txt *.md *.rst *.license COPYING LICENSE",
+			"recursive-include .reuse **",
+			"recursive-include LICENSES **",
+			"recursive-include tests **",
+			"recursive-include docs **.rst **.yml **.yaml **.json **.j2 **.txt **.license",
+			"recursive-include roles **.yml **.yaml **.json **.j2 **.license",
+			"recursive-include playbooks **.yml **.yaml **.json **.license",
+			"recursive-include changelogs **.yml **.yaml **.license",
+			"recursive-include plugins */**.py */**.license",
+		)
+		for _, plugin := range documentablePluginTypes {
+			directives = append(directives, fmt.Sprintf("recursive-include plugins/%s **.yml **.yaml", plugin))
+		}
+		directives = append(directives,
+			"recursive-include plugins/modules **.ps1 **.yml **.yaml **.license",
+			"recursive-include plugins/module_utils **.ps1 **.psm1 **.cs **.license",
+		)
+	}
+	directives = append(directives, control.Directives...)
+	// never packaged, whatever the directives say
+	directives = append(directives,
+		fmt.Sprintf("exclude galaxy.yml galaxy.yaml MANIFEST.json FILES.json %s-%s-*.tar.gz", meta.Namespace, meta.Name),
+		"recursive-exclude tests/output **",
+		"global-exclude /.* /__pycache__ *.pyc *.pyo *.bak *~ *.swp",
+	)
+	return directives, nil
+}
+
+/*
+filesFromManifestDirectives selects files with MANIFEST.in style
+directives, the way ansible-galaxy does with distlib when galaxy.yml has
+a manifest key. Directories are only included as the parents of selected
+files, and symlinked directories are not followed.
+*/
+func filesFromManifestDirectives(root string, meta GalaxyYAML) ([]utils.ArchiveEntry, error) {
+	directives, err := manifestDirectives(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	// names are "/" + the relative path, so anchored patterns and /.* work like they do on distlib's absolute paths
+	allFiles := []string{}
+	err = filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+			rel, _ := filepath.Rel(root, p)
+			allFiles = append(allFiles, "/"+filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	selected := map[string]bool{}
+	for _, directive := range directives {
+		if err := applyManifestDirective(directive, allFiles, selected); err != nil {
+			return nil, err
+		}
+	}
+
+	names := []string{}
+	dirs := map[string]bool{}
+	for name := range selected {
+		names = append(names, name)
+		for dir := path.Dir(name); dir != "/" && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+			names = append(names, dir)
+		}
+	}
+	// distlib sorts by (dirname, basename)
+	sort.Slice(names, func(i, j int) bool {
+		di, dj := path.Dir(names[i]), path.Dir(names[j])
+		if di != dj {
+			return di < dj
+		}
+		return path.Base(names[i]) < path.Base(names[j])
+	})
+
+	files := []utils.ArchiveEntry{}
+	for _, name := range names {
+		rel := strings.TrimPrefix(name, "/")
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil, err
+		}
+		entry, ok, err := utils.NewArchiveEntry(root, rel, info)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			files = append(files, entry)
+		}
+	}
+	return files, nil
+}
+
+// applyManifestDirective adds files to or removes them from selected
+func applyManifestDirective(directive string, allFiles []string, selected map[string]bool) error {
+	words := strings.Fields(directive)
+	if len(words) == 0 {
+		return nil
+	}
+	actions := map[string]int{
+		"include": 2, "exclude": 2, "global-include": 2, "global-exclude": 2,
+		"recursive-include": 3, "recursive-exclude": 3, "graft": 2, "prune": 2,
+	}
+	// a bare pattern is an include
+	if _, ok := actions[words[0]]; !ok && len(words) == 1 {
+		words = append([]string{"include"}, words...)
+	}
+	action := words[0]
+	minWords, ok := actions[action]
+	if !ok {
+		return laxerrors.New(laxerrors.ErrUsage, "unknown manifest directive %q", directive)
+	}
+	if len(words) < minWords || ((action == "graft" || action == "prune") && len(words) != 2) {
+		return laxerrors.New(laxerrors.ErrUsage, "manifest directive %q has the wrong number of arguments", directive)
+	}
+
+	patterns := []string{}
+	switch action {
+	case "include", "exclude":
+		for _, p := range words[1:] {
+			patterns = append(patterns, "^/"+fnmatchRegexp(p, true)+"$")
+		}
+	case "global-include", "global-exclude":
+		for _, p := range words[1:] {
+			patterns = append(patterns, fnmatchRegexp(p, true)+"$")
+		}
+	case "recursive-include", "recursive-exclude":
+		for _, p := range words[2:] {
+			patterns = append(patterns, "^/"+fnmatchRegexp(words[1], true)+"/.*"+fnmatchRegexp(p, true)+"$")
+		}
+	case "graft", "prune":
+		patterns = append(patterns, "^/"+fnmatchRegexp(words[1], true)+"/.*$")
+	}
+
+	include := action == "graft" || strings.HasSuffix(action, "include")
+	for _, p := range patterns {
+		re, err := regexp.Compile("(?s:" + p + ")")
+		if err != nil {
+			return laxerrors.Wrap(laxerrors.ErrUsage, err, "invalid pattern in manifest directive %q", directive)
+		}
+		matched := false
+		if include {
+			for _, f := range allFiles {
+				if re.MatchString(f) {
+					selected[f] = true
+					matched = true
+				}
+			}
+		} else {
+			for f := range selected {
+				if re.MatchString(f) {
+					delete(selected, f)
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			logrus.Debugf("manifest directive %q matched nothing", directive)
+		}
+	}
+	return nil
+}
+
+/*
+fnmatchRegexp translates a shell pattern like python's fnmatch. With
+slashSafe, * and ? don't match /, which is how distlib reads manifest
+directives, otherwise they do, which is how build_ignore is read.
+*/
+func fnmatchRegexp(pattern string, slashSafe bool) string {
+	anyRun, anyOne := ".*", "."
+	if slashSafe {
+		anyRun, anyOne = "[^/]*", "[^/]"
+	}
+	runes := []rune(pattern)
+	var sb strings.Builder
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '*':
+			sb.WriteString(anyRun)
+		case '?':
+			sb.WriteString(anyOne)
+		case '[':
+			j := i + 1
+			if j < len(runes) && runes[j] == '!' {
+				j++
+			}
+			if j < len(runes) && runes[j] == ']' {
+				j++
+			}
+			for j < len(runes) && runes[j] != ']' {
+				j++
+			}
+			if j >= len(runes) {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := string(runes[i+1 : j])
+			i = j
+			sb.WriteString("[")
+			if strings.HasPrefix(class, "!") {
+				sb.WriteString("^")
+				class = class[1:]
+			} else if strings.HasPrefix(class, "^") {
+				sb.WriteString(`\`)
+			}
+			class = strings.NewReplacer(`\`, `\\`, `[`, `\[`).Replace(class)
+			sb.WriteString(class)
+			sb.WriteString("]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+	return sb.String()
+}
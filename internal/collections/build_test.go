@@ -0,0 +1,185 @@
+package collections
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/repository"
+	"github.com/jctanner/lax/internal/testutil"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+)
+
+// builtNames lists the FILES.json entries of an artifact
+func builtNames(t *testing.T, artifact string) []string {
+	fmap, err := utils.ExtractJSONFilesFromTarGz(artifact, []string{"FILES.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files repository.CollectionFilesMeta
+	if err := json.Unmarshal(fmap["FILES.json"], &files); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range files.Files {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+const testGalaxyYAML = "namespace: testn\nname: col\nversion: 1.0.0\nreadme: README.md\nauthors: [me]\n"
+
+func TestBuildCollection(t *testing.T) {
+	tests := []struct {
+		name     string
+		extra    string
+		files    map[string]string
+		expected []string
+	}{
+		{
+			"build_ignore",
+			"build_ignore: ['*.bak', docs]\n",
+			map[string]string{
+				"README.md":               "# col",
+				"plugins/modules/mod.py":  "",
+				"plugins/modules/mod.pyc": "",
+				"docs/index.rst":          "",
+				"notes.bak":               "",
+				".git/HEAD":               "",
+				"tests/output/junit.xml":  "",
+				"testn-col-0.9.0.tar.gz":  "",
+			},
+			[]string{".", "README.md", "plugins", "plugins/modules", "plugins/modules/mod.py", "tests"},
+		},
+		{
+			"default manifest",
+			"manifest: {}\n",
+			map[string]string{
+				"README.md":                   "# col",
+				"LICENSE":                     "",
+				"plugins/modules/mod.py":      "",
+				"plugins/modules/.hidden.py":  "",
+				"plugins/lookup/thing.py":     "",
+				"plugins/lookup/thing.yml":    "",
+				"plugins/top.py":              "",
+				"roles/r/tasks/main.yml":      "",
+				"roles/r/files/binary.tar.gz": "",
+				"scratch.py":                  "",
+			},
+			[]string{".", "LICENSE", "README.md", "plugins", "roles", "plugins/lookup", "plugins/modules",
+				"plugins/lookup/thing.py", "plugins/lookup/thing.yml", "plugins/modules/mod.py",
+				"roles/r", "roles/r/tasks", "roles/r/tasks/main.yml"},
+		},
+		{
+			"manifest directives",
+			"manifest:\n  omit_default_directives: true\n  directives:\n    - include README.md\n    - graft roles\n    - prune roles/r/files\n    - global-exclude *.orig\n",
+			map[string]string{
+				"README.md":              "# col",
+				"roles/r/tasks/main.yml": "",
+				"roles/r/tasks/x.orig":   "",
+				"roles/r/files/big.bin":  "",
+				"plugins/modules/mod.py": "",
+			},
+			[]string{".", "README.md", "roles", "roles/r", "roles/r/tasks", "roles/r/tasks/main.yml"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := t.TempDir()
+			out := t.TempDir()
+			tt.files["galaxy.yml"] = testGalaxyYAML + tt.extra
+			testutil.WriteTree(t, src, tt.files)
+
+			artifact, err := BuildCollection(src, out, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filepath.Base(artifact) != "testn-col-1.0.0.tar.gz" {
+				t.Errorf("unexpected artifact name %s", artifact)
+			}
+			if names := builtNames(t, artifact); !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("files\n got %v\nwant %v", names, tt.expected)
+			}
+
+			// the same tree builds to the same bytes
+			first, _ := os.ReadFile(artifact)
+			if _, err := BuildCollection(src, out, false); !errors.Is(err, laxerrors.ErrConflict) {
+				t.Errorf("expected a conflict without force, got %v", err)
+			}
+			if _, err := BuildCollection(src, out, true); err != nil {
+				t.Fatal(err)
+			}
+			second, _ := os.ReadFile(artifact)
+			if !bytes.Equal(first, second) {
+				t.Error("two builds of the same tree differ")
+			}
+
+			// and passes the checks lax publish makes
+			if _, err := repository.PublishToDir(t.TempDir(), artifact, repository.PublishOptions{}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestReadGalaxyYAML(t *testing.T) {
+	tests := []struct {
+		name   string
+		yaml   string
+		errMsg string
+	}{
+		{"valid", testGalaxyYAML, ""},
+		{"missing keys", "namespace: testn\nname: col\n", "missing the required keys authors, readme, version"},
+		{"bad version", strings.Replace(testGalaxyYAML, "1.0.0", "1.0", 1), "invalid version"},
+		{"bad name", strings.Replace(testGalaxyYAML, "name: col", "name: my-col", 1), "not a valid collection name"},
+		{"build_ignore and manifest", testGalaxyYAML + "build_ignore: [x]\nmanifest: {}\n", "both build_ignore and manifest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			testutil.WriteTree(t, dir, map[string]string{"galaxy.yml": tt.yaml})
+			_, err := ReadGalaxyYAML(dir)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) || !errors.Is(err, laxerrors.ErrUsage) {
+				t.Errorf("expected a usage error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestInit(t *testing.T) {
+	initPath := t.TempDir()
+	kwargs := &types.CmdKwargs{DestDir: initPath}
+	if err := Init(kwargs, []string{"acme.tools"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Init(kwargs, []string{"acme.tools"}); !errors.Is(err, laxerrors.ErrConflict) {
+		t.Errorf("expected a conflict initializing twice, got %v", err)
+	}
+	if err := Init(kwargs, []string{"acme-tools"}); !errors.Is(err, laxerrors.ErrUsage) {
+		t.Errorf("expected a usage error for a bad name, got %v", err)
+	}
+
+	// the skeleton builds as it is
+	artifact, err := BuildCollection(filepath.Join(initPath, "acme", "tools"), t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{".", "README.md", "docs", "meta", "meta/runtime.yml", "plugins", "plugins/README.md", "roles"}
+	if names := builtNames(t, artifact); !reflect.DeepEqual(names, expected) {
+		t.Errorf("files\n got %v\nwant %v", names, expected)
+	}
+}
@@ -0,0 +1,219 @@
+package collections
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/packagemanager"
+	"github.com/jctanner/lax/internal/repository"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+)
+
+type depsNodeJSON struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+}
+
+type depsEdgeJSON struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Constraint string `json:"constraint"`
+}
+
+type depsGraphJSON struct {
+	Root  string         `json:"root"`
+	Nodes []depsNodeJSON `json:"nodes"`
+	Edges []depsEdgeJSON `json:"edges"`
+}
+
+type rdepJSON struct {
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Constraint string `json:"constraint"`
+}
+
+type rdepsJSON struct {
+	Collection string     `json:"collection"`
+	Version    string     `json:"version,omitempty"`
+	Dependents []rdepJSON `json:"dependents"`
+}
+
+// Deps renders the resolved dependency graph for a collection
+func Deps(kwargs *types.CmdKwargs, args []string) error {
+
+	ispec, err := utils.SpecFromArgs(kwargs.Namespace, kwargs.Name, kwargs.Version, args)
+	if err != nil {
+		return err
+	}
+
+	manifests, err := loadCollectionManifests(kwargs)
+	if err != nil {
+		return err
+	}
+
+	graph, err := repository.ResolveCollectionDepGraph(ispec, &manifests)
+	if err != nil {
+		return err
+	}
+
+	switch kwargs.OutputFormat {
+	case "json":
+		return writeDepsJSON(os.Stdout, &graph)
+	case "dot":
+		writeDepsDOT(os.Stdout, &graph)
+	case "", "text":
+		if kwargs.ShowTree {
+			writeDepsTree(os.Stdout, &graph)
+		} else {
+			for _, node := range graph.Nodes {
+				fmt.Fprintln(os.Stdout, specLabel(node))
+			}
+		}
+	default:
+		return laxerrors.New(laxerrors.ErrUsage, "unknown output format %s", kwargs.OutputFormat)
+	}
+
+	return nil
+}
+
+// RDeps lists every collection in the repo index that depends on a collection
+func RDeps(kwargs *types.CmdKwargs, args []string) error {
+
+	ispec, err := utils.SpecFromArgs(kwargs.Namespace, kwargs.Name, kwargs.Version, args)
+	if err != nil {
+		return err
+	}
+
+	manifests, err := loadCollectionManifests(kwargs)
+	if err != nil {
+		return err
+	}
+
+	rdeps := repository.FindCollectionReverseDeps(ispec.Namespace, ispec.Name, ispec.Version, &manifests)
+	fqn := ispec.Namespace + "." + ispec.Name
+
+	switch kwargs.OutputFormat {
+	case "json":
+		out := rdepsJSON{Collection: fqn, Version: ispec.Version, Dependents: []rdepJSON{}}
+		for _, rdep := range rdeps {
+			out.Dependents = append(out.Dependents, rdepJSON{
+				Namespace:  rdep.Dependent.Namespace,
+				Name:       rdep.Dependent.Name,
+				Version:    rdep.Dependent.Version,
+				Constraint: rdep.Constraint,
+			})
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(out)
+	case "dot":
+		fmt.Fprintln(os.Stdout, "digraph rdeps {")
+		fmt.Fprintf(os.Stdout, "  %q;\n", fqn)
+		for _, rdep := range rdeps {
+			fmt.Fprintf(os.Stdout, "  %q -> %q [label=%q];\n", specLabel(rdep.Dependent), fqn, rdep.Constraint)
+		}
+		fmt.Fprintln(os.Stdout, "}")
+	case "", "text":
+		if len(rdeps) == 0 {
+			fmt.Fprintf(os.Stdout, "nothing in the repository depends on %s\n", fqn)
+			return nil
+		}
+		for _, rdep := range rdeps {
+			fmt.Fprintf(os.Stdout, "%s requires %s %s\n", specLabel(rdep.Dependent), fqn, rdep.Constraint)
+		}
+	default:
+		return laxerrors.New(laxerrors.ErrUsage, "unknown output format %s", kwargs.OutputFormat)
+	}
+
+	return nil
+}
+
+func loadCollectionManifests(kwargs *types.CmdKwargs) ([]repository.CollectionManifest, error) {
+	repoClient, err := repository.GetRepoClient(kwargs.Server, kwargs.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgMgr, err := packagemanager.GetPackageManager(kwargs.CacheDir, kwargs.DestDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := pkgMgr.SyncRepoMeta(repoClient); err != nil {
+		return nil, err
+	}
+
+	return repoClient.GetCollectionManifests()
+}
+
+func specLabel(spec utils.InstallSpec) string {
+	return fmt.Sprintf("%s.%s==%s", spec.Namespace, spec.Name, spec.Version)
+}
+
+func writeDepsJSON(w io.Writer, graph *repository.DependencyGraph) error {
+	out := depsGraphJSON{
+		Root:  specLabel(graph.Root),
+		Nodes: []depsNodeJSON{},
+		Edges: []depsEdgeJSON{},
+	}
+	for _, node := range graph.Nodes {
+		out.Nodes = append(out.Nodes, depsNodeJSON{Namespace: node.Namespace, Name: node.Name, Version: node.Version})
+	}
+	for _, edge := range graph.Edges {
+		out.Edges = append(out.Edges, depsEdgeJSON{From: specLabel(edge.From), To: specLabel(edge.To), Constraint: edge.Constraint})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+func writeDepsDOT(w io.Writer, graph *repository.DependencyGraph) {
+	fmt.Fprintln(w, "digraph deps {")
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(w, "  %q;\n", specLabel(node))
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(w, "  %q -> %q [label=%q];\n", specLabel(edge.From), specLabel(edge.To), edge.Constraint)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+/*
+Render the graph as an ascii tree. Subtrees that were already printed
+are marked as deduped instead of being expanded again.
+*/
+func writeDepsTree(w io.Writer, graph *repository.DependencyGraph) {
+	fmt.Fprintln(w, specLabel(graph.Root))
+	seen := map[string]bool{specLabel(graph.Root): true}
+	writeDepsSubtree(w, graph, graph.Root, "", seen)
+}
+
+func writeDepsSubtree(w io.Writer, graph *repository.DependencyGraph, spec utils.InstallSpec, prefix string, seen map[string]bool) {
+	children := graph.Children(spec)
+	for ix, edge := range children {
+		branch := "├── "
+		nextPrefix := prefix + "│   "
+		if ix == len(children)-1 {
+			branch = "└── "
+			nextPrefix = prefix + "    "
+		}
+
+		label := specLabel(edge.To)
+		line := fmt.Sprintf("%s%s%s (%s)", prefix, branch, label, edge.Constraint)
+		if seen[label] {
+			fmt.Fprintln(w, line+" deduped")
+			continue
+		}
+		fmt.Fprintln(w, line)
+		seen[label] = true
+		writeDepsSubtree(w, graph, edge.To, nextPrefix, seen)
+	}
+}
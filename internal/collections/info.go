@@ -0,0 +1,110 @@
+package collections
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/packagemanager"
+	"github.com/jctanner/lax/internal/repository"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+)
+
+// Info prints everything the repo index knows about a collection
+func Info(kwargs *types.CmdKwargs, args []string) error {
+
+	ispec, err := utils.SpecFromArgs(kwargs.Namespace, kwargs.Name, kwargs.Version, args)
+	if err != nil {
+		return err
+	}
+	namespace := ispec.Namespace
+	name := ispec.Name
+	version := ispec.Version
+
+	repoClient, err := repository.GetRepoClient(kwargs.Server, kwargs.CacheDir)
+	if err != nil {
+		return err
+	}
+
+	pkgMgr, err := packagemanager.GetPackageManager(kwargs.CacheDir, kwargs.DestDir)
+	if err != nil {
+		return err
+	}
+	if err := pkgMgr.SyncRepoMeta(repoClient); err != nil {
+		return err
+	}
+
+	manifests, err := repoClient.GetCollectionManifests()
+	if err != nil {
+		return err
+	}
+
+	// every version of the collection in the repo
+	allVersions := repository.SpecToManifestCandidates(
+		utils.InstallSpec{Namespace: namespace, Name: name},
+		&manifests,
+	)
+	if len(allVersions) == 0 {
+		return laxerrors.New(laxerrors.ErrNotFound, "%s.%s was not found in the repository", namespace, name)
+	}
+	allVersions = repository.SortManifestsNewestFirst(allVersions)
+
+	// the version to show details for
+	selected := allVersions[0]
+	if version != "" {
+		candidates := repository.SpecToManifestCandidates(
+			utils.InstallSpec{Namespace: namespace, Name: name, Version: version},
+			&allVersions,
+		)
+		if len(candidates) == 0 {
+			return laxerrors.New(laxerrors.ErrNotFound, "%s.%s has no version matching %s", namespace, name, version)
+		}
+		selected = repository.SortManifestsNewestFirst(candidates)[0]
+	}
+
+	installed, err := pkgMgr.GetInstalledCollectionVersion(namespace, name)
+	if err != nil {
+		return err
+	}
+
+	ci := selected.CollectionInfo
+	utils.PrintInfoField("Name", fmt.Sprintf("%s.%s", ci.Namespace, ci.Name))
+	utils.PrintInfoField("Version", ci.Version)
+	utils.PrintInfoField("Description", ci.Description)
+	utils.PrintInfoField("Authors", strings.Join(ci.Authors, ", "))
+	utils.PrintInfoField("License", strings.Join(ci.License, ", "))
+	if ci.LicenseFile != "" {
+		utils.PrintInfoField("License File", ci.LicenseFile)
+	}
+	utils.PrintInfoField("Repository", ci.Repository)
+	utils.PrintInfoField("Documentation", ci.Documentation)
+	utils.PrintInfoField("Homepage", ci.Homepage)
+	utils.PrintInfoField("Issues", ci.Issues)
+	utils.PrintInfoField("Tags", strings.Join(ci.Tags, ", "))
+	if installed != "" {
+		utils.PrintInfoField("Installed", fmt.Sprintf("%s (%s)", installed, pkgMgr.GetInstalledCollectionPath(namespace, name)))
+	} else {
+		utils.PrintInfoField("Installed", "no")
+	}
+
+	fmt.Printf("Versions:\n")
+	for _, m := range allVersions {
+		fmt.Printf("  %s\n", m.CollectionInfo.Version)
+		deps := m.CollectionInfo.Dependencies
+		if len(deps) == 0 {
+			continue
+		}
+		keys := make([]string, 0, len(deps))
+		for k := range deps {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("    - %s %s\n", k, deps[k])
+		}
+	}
+
+	return nil
+}
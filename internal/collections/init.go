@@ -0,0 +1,46 @@
+package collections
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/skeleton"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+)
+
+// Init creates <init-path>/<namespace>/<name> from the built in skeleton or --collection-skeleton
+func Init(kwargs *types.CmdKwargs, args []string) error {
+	if len(args) != 1 {
+		return laxerrors.New(laxerrors.ErrUsage, "init takes one namespace.name")
+	}
+	parts := strings.Split(args[0], ".")
+	if len(parts) != 2 || !collectionNamePattern.MatchString(parts[0]) || !collectionNamePattern.MatchString(parts[1]) {
+		return laxerrors.New(laxerrors.ErrUsage, "%s is not a valid collection name, it should be namespace.name", args[0])
+	}
+	initPath := kwargs.DestDir
+	if initPath == "" {
+		initPath = "."
+	}
+	initPath, err := utils.GetAbsPath(utils.ExpandUser(initPath))
+	if err != nil {
+		return err
+	}
+
+	skel := skeleton.Builtin("collection")
+	if kwargs.Skeleton != "" {
+		if skel, err = skeleton.FromDir(kwargs.Skeleton); err != nil {
+			return err
+		}
+	}
+
+	dest := filepath.Join(initPath, parts[0], parts[1])
+	if _, err := skeleton.Render(skel, dest, skeleton.DefaultVars("collection", parts[0], parts[1]), kwargs.Force); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Created collection %s at %s\n", args[0], dest)
+	return nil
+}
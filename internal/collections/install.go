@@ -1,13 +1,15 @@
 package collections
 
 import (
-	"fmt"
-	"time"
+	"errors"
+	"os"
 
+	"github.com/jctanner/lax/internal/laxerrors"
 	"github.com/jctanner/lax/internal/packagemanager"
 	"github.com/jctanner/lax/internal/repository"
 	"github.com/jctanner/lax/internal/types"
 	"github.com/jctanner/lax/internal/utils"
+	"github.com/sirupsen/logrus"
 )
 
 // func Install(dest string, cachedir string, server string, requirements_file string, namespace string, name string, version string, args []string) error {
@@ -18,49 +20,38 @@ func Install(kwargs *types.CmdKwargs, args []string) error {
 	server := kwargs.Server
 	requirements_file := kwargs.RequirementsFile
 	if requirements_file != "" {
-		panic("collection install with requirements file not yet implemented!!!")
+		return laxerrors.New(laxerrors.ErrUsage, "collection install with a requirements file is not implemented yet")
 	}
 	namespace := kwargs.Namespace
 	name := kwargs.Name
 	version := kwargs.Version
 
-	fmt.Printf("INSTALL2: cachedir:%s dest:%s\n", cachedir, dest)
+	logrus.Debugf("INSTALL2: cachedir:%s dest:%s", cachedir, dest)
 
 	// does dest have a repodata.json file, read it in?
-	repoClient, _ := repository.GetRepoClient(server, cachedir)
-	fmt.Printf("repoclient: %s\n", repoClient)
-	if repoClient == nil {
-		return fmt.Errorf("no suitable repostiory found")
+	repoClient, err := repository.GetRepoClient(server, cachedir)
+	if err != nil {
+		return err
 	}
+	logrus.Debugf("repoclient: %v", repoClient)
 
 	// Make the local package manager client
-	pkgMgr, err := packagemanager.GetPackageManager(cachedir, dest)
-	fmt.Printf("packagemanager: %s\n", pkgMgr)
+	// a dry run leaves the cache as it is, the index is fetched somewhere temporary if it has to be
+	getPackageManager := packagemanager.GetPackageManager
+	if kwargs.DryRun {
+		getPackageManager = packagemanager.GetReadOnlyPackageManager
+	}
+	pkgMgr, err := getPackageManager(cachedir, dest)
+	logrus.Debugf("packagemanager: %v", pkgMgr)
 	if err != nil {
 		return err
 	}
 
-	// Is the package manager's meta older? Re-download if so ...
-	if !pkgMgr.HasRepoMeta() {
-		fmt.Printf("need to fetch repo meta ...\n")
-		repoClient.FetchRepoMeta(pkgMgr.CachePath)
-	} else {
-		// Is it up to date?
-		pDate := pkgMgr.RepoMeta.Date
-		fmt.Printf("pdate: %s\n", pDate)
-		rDate, _ := repoClient.GetRepoMetaDate()
-		fmt.Printf("rdate: %s\n", rDate)
-
-		d1, _ := time.Parse(time.RFC3339, pDate)
-		d2, _ := time.Parse(time.RFC3339, rDate)
-
-		if d1.Before(d2) {
-			fmt.Printf("need to update local cache\n")
-			repoClient.FetchRepoMeta(pkgMgr.CachePath)
-		} else {
-			fmt.Printf("do not need to update local cache\n")
-		}
+	defer pkgMgr.Close()
 
+	// Is the package manager's meta older? Re-download if so ...
+	if err := pkgMgr.SyncRepoMeta(repoClient); err != nil {
+		return err
 	}
 
 	if len(args) > 0 {
@@ -84,33 +75,47 @@ func Install(kwargs *types.CmdKwargs, args []string) error {
 		Name:      name,
 		Version:   version,
 	}
+	if ispec.Namespace == "" || ispec.Name == "" {
+		return laxerrors.New(laxerrors.ErrUsage, "a namespace.name is required")
+	}
 
-	fmt.Printf("spec: %s\n", ispec)
-
-	specs, err := repoClient.ResolveCollectionDeps(ispec)
+	logrus.Debugf("spec: %v", ispec)
 
+	manifests, err := repoClient.GetCollectionManifests()
 	if err != nil {
-		fmt.Printf("error solving dep tree %s\n", err)
 		return err
 	}
 
-	fmt.Printf("-----------------------------\n")
-	for _, spec := range specs {
-		fmt.Printf("install: %s.%s==%s\n", spec.Namespace, spec.Name, spec.Version)
+	// keep installed versions that still satisfy the request, like ansible-galaxy
+	prefer := pkgMgr.GetInstalledCollections()
+	if kwargs.ForceWithDeps {
+		prefer = map[string]string{}
+	} else if kwargs.Force {
+		delete(prefer, ispec.Namespace+"."+ispec.Name)
 	}
 
-	fmt.Printf("-----------------------------\n")
-	for _, spec := range specs {
-		fmt.Printf("installing: %s.%s==%s\n", spec.Namespace, spec.Name, spec.Version)
-
-		// get a local cache file from the repo to install ...
-		fn := repoClient.GetCacheFileLocationForInstallSpec(spec)
-		fmt.Printf("\tfrom %s\n", fn)
-
-		// extract it to the right place ...
-		pkgMgr.InstalCollectionFromPath(spec.Namespace, spec.Name, spec.Version, fn)
+	specs, err := repository.ResolveCollectionDepsRetrying(ispec, &manifests, prefer)
+	if errors.Is(err, laxerrors.ErrConflict) && len(prefer) > 0 {
+		// an installed version may be what conflicts, so try again without them
+		logrus.Debugf("%s, resolving again without the installed versions", err)
+		specs, err = repository.ResolveCollectionDepsRetrying(ispec, &manifests, nil)
+	}
+	if err != nil {
+		return err
+	}
 
+	txn := pkgMgr.PlanCollectionTransaction(specs, manifests)
+	if kwargs.ForceWithDeps {
+		txn.ForceReinstall("", "")
+	} else if kwargs.Force {
+		txn.ForceReinstall(ispec.Namespace, ispec.Name)
+	}
+	if err := txn.Write(os.Stdout, kwargs.OutputFormat); err != nil {
+		return err
+	}
+	if kwargs.DryRun {
+		return nil
 	}
 
-	return nil
+	return pkgMgr.ApplyTransaction(&txn, repoClient, kwargs.DownloadConcurrency)
 }
@@ -0,0 +1,167 @@
+package galaxy_sync
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jctanner/lax/internal/httpclient"
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// TokenEnvVar is read for the refresh token when neither --token nor --token-file is given
+const TokenEnvVar = "LAX_TOKEN"
+
+// refresh this long before the server says the access token expires
+const tokenExpiryMargin = 60 * time.Second
+
+/*
+ReadToken picks the refresh token from the --token flag, then the
+--token-file, then the LAX_TOKEN environment variable.
+*/
+func ReadToken(token string, tokenFile string) (string, error) {
+	if token != "" {
+		return token, nil
+	}
+	if tokenFile != "" {
+		data, err := os.ReadFile(utils.ExpandUser(tokenFile))
+		if err != nil {
+			return "", laxerrors.Wrap(laxerrors.ErrUsage, err, "failed to read the token file")
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return strings.TrimSpace(os.Getenv(TokenEnvVar)), nil
+}
+
+/*
+tokenSource exchanges a refresh token for short lived access tokens.
+The access token is refreshed shortly before it expires, and callers
+that get a 401 ask for a new one with Refresh. It's shared by every
+goroutine of a sync.
+*/
+type tokenSource struct {
+	authUrl      string
+	refreshToken string
+
+	mu          sync.Mutex
+	accessToken string
+	// zero when the server didn't say
+	expires time.Time
+	now     func() time.Time
+}
+
+// newTokenSource makes the first exchange so a bad token fails the sync up front
+func newTokenSource(authUrl string, refreshToken string) (*tokenSource, error) {
+	if refreshToken == "" {
+		return nil, laxerrors.New(laxerrors.ErrUsage, "%s needs a refresh token from --token, --token-file or %s", authUrl, TokenEnvVar)
+	}
+	ts := &tokenSource{authUrl: authUrl, refreshToken: refreshToken, now: time.Now}
+	if err := ts.exchange(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// Token returns a current access token, refreshing it if it's about to expire
+func (ts *tokenSource) Token() (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.accessToken == "" || (!ts.expires.IsZero() && ts.now().Add(tokenExpiryMargin).After(ts.expires)) {
+		logrus.Debugf("access token from %s is expiring, refreshing it", ts.authUrl)
+		if err := ts.exchange(); err != nil {
+			return "", err
+		}
+	}
+	return ts.accessToken, nil
+}
+
+// Refresh replaces a token the server rejected. Concurrent callers that
+// saw the same stale token only cause one exchange.
+func (ts *tokenSource) Refresh(stale string) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.accessToken == stale {
+		logrus.Debugf("access token was rejected, refreshing it from %s", ts.authUrl)
+		if err := ts.exchange(); err != nil {
+			return "", err
+		}
+	}
+	return ts.accessToken, nil
+}
+
+// exchange posts the refresh token, the caller holds mu (or owns ts)
+func (ts *tokenSource) exchange() error {
+	formData := url.Values{}
+	formData.Set("grant_type", "refresh_token")
+	formData.Set("client_id", "cloud-services")
+	formData.Set("refresh_token", ts.refreshToken)
+	logrus.Debugf("requesting an access token from %s", ts.authUrl)
+
+	resp, err := httpclient.Default().PostForm(ts.authUrl, formData)
+	if err != nil {
+		return laxerrors.Wrap(laxerrors.ErrAuthFailed, err, "error making request to %s", ts.authUrl)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return laxerrors.New(laxerrors.ErrAuthFailed, "%s returned %s", ts.authUrl, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return laxerrors.Wrap(laxerrors.ErrAuthFailed, err, "error reading response from %s", ts.authUrl)
+	}
+
+	var respData struct {
+		AccessToken  string `json:"access_token"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(body, &respData); err != nil {
+		return laxerrors.Wrap(laxerrors.ErrAuthFailed, err, "error unmarshalling response from %s", ts.authUrl)
+	}
+	if respData.AccessToken == "" {
+		return laxerrors.New(laxerrors.ErrAuthFailed, "access token not found in the response from %s", ts.authUrl)
+	}
+
+	ts.accessToken = respData.AccessToken
+	ts.expires = time.Time{}
+	if respData.ExpiresIn > 0 {
+		ts.expires = ts.now().Add(time.Duration(respData.ExpiresIn) * time.Second)
+	}
+	// some servers rotate the refresh token on every exchange
+	if respData.RefreshToken != "" {
+		ts.refreshToken = respData.RefreshToken
+	}
+	logrus.Debugf("got an access token from %s that expires in %ds", ts.authUrl, respData.ExpiresIn)
+	return nil
+}
+
+/*
+doWithToken sends a request built by newRequest with the current access
+token, and once more with a fresh token if the server answers 401.
+*/
+func (ts *tokenSource) doWithToken(newRequest func(token string) (*http.Response, error)) (*http.Response, error) {
+	token, err := ts.Token()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := newRequest(token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	token, err = ts.Refresh(token)
+	if err != nil {
+		return nil, err
+	}
+	return newRequest(token)
+}
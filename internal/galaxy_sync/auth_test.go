@@ -0,0 +1,160 @@
+package galaxy_sync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/utils"
+)
+
+// ssoServer hands out tok1, tok2 ... for the refresh token "good"
+func ssoServer(expiresIn int) (*httptest.Server, *int) {
+	exchanges := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("refresh_token") != "good" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		exchanges++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("tok%d", exchanges),
+			"expires_in":   expiresIn,
+		})
+	}))
+	return server, &exchanges
+}
+
+func TestTokenSource(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn int
+		elapsed   time.Duration
+		expected  string
+	}{
+		{"still valid", 300, 100 * time.Second, "tok1"},
+		{"about to expire", 300, 250 * time.Second, "tok2"},
+		{"no expiry given", 0, time.Hour, "tok1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sso, _ := ssoServer(tt.expiresIn)
+			defer sso.Close()
+
+			ts, err := newTokenSource(sso.URL, "good")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts.now = func() time.Time { return time.Now().Add(tt.elapsed) }
+			token, err := ts.Token()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != tt.expected {
+				t.Errorf("got %s, want %s", token, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTokenSourceErrors(t *testing.T) {
+	sso, _ := ssoServer(300)
+	defer sso.Close()
+
+	tests := []struct {
+		name     string
+		token    string
+		expected error
+	}{
+		{"no token", "", laxerrors.ErrUsage},
+		{"rejected token", "bad", laxerrors.ErrAuthFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newTokenSource(sso.URL, tt.token); !errors.Is(err, tt.expected) {
+				t.Errorf("got %v, want %v", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetUrlRefreshesOn401(t *testing.T) {
+	sso, exchanges := ssoServer(300)
+	defer sso.Close()
+
+	// api calls only take tok2 and downloads only take tok3, as if each
+	// token was revoked in turn
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := "Bearer tok2"
+		if strings.HasPrefix(r.URL.Path, "/download/") {
+			expected = "Bearer tok3"
+		}
+		if r.Header.Get("Authorization") != expected {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer api.Close()
+
+	client, err := NewCachedGalaxyClient(api.URL, sso.URL, "good", "/api", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.GetUrl(api.URL + "/api/v3/collections/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d after refreshing", resp.StatusCode)
+	}
+	if *exchanges != 2 {
+		t.Errorf("%d token exchanges, want 2", *exchanges)
+	}
+
+	fp := filepath.Join(t.TempDir(), "ns-name-1.0.0.tar.gz")
+	if err := client.Download(api.URL+"/download/ns-name-1.0.0.tar.gz", fp, utils.DownloadOptions{}); err != nil {
+		t.Errorf("download with the refreshed token failed: %s", err)
+	}
+	if *exchanges != 3 {
+		t.Errorf("%d token exchanges, want 3", *exchanges)
+	}
+}
+
+func TestReadToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("from-file\n"), 0600)
+	t.Setenv(TokenEnvVar, "from-env")
+
+	tests := []struct {
+		name      string
+		token     string
+		tokenFile string
+		expected  string
+	}{
+		{"flag", "from-flag", tokenFile, "from-flag"},
+		{"file", "", tokenFile, "from-file"},
+		{"env", "", "", "from-env"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ReadToken(tt.token, tt.tokenFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.expected {
+				t.Errorf("got %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
@@ -2,110 +2,98 @@ package galaxy_sync
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/jctanner/lax/internal/httpclient"
+	"github.com/jctanner/lax/internal/laxerrors"
 	"github.com/jctanner/lax/internal/utils"
 	"github.com/sirupsen/logrus"
 )
 
 type CachedGalaxyClient struct {
-	baseUrl     string
-	authUrl     string
-	token       string
-	accessToken string
-	apiPrefix   string
-	cachePath   string
+	baseUrl   string
+	authUrl   string
+	auth      *tokenSource
+	apiPrefix string
+	cachePath string
 }
 
-func NewCachedGalaxyClient(baseUrl string, authUrl string, token string, apiPrefix string, cachePath string) CachedGalaxyClient {
+func NewCachedGalaxyClient(baseUrl string, authUrl string, token string, apiPrefix string, cachePath string) (CachedGalaxyClient, error) {
 
-	// create the access token if there is an authUrl ...
-	var accessToken string
-
-	// If authUrl is provided, fetch the access token
+	// If authUrl is provided, exchange the refresh token for an access token
+	var auth *tokenSource
 	if authUrl != "" {
-		// Prepare the form data
-		formData := url.Values{}
-		formData.Set("grant_type", "refresh_token")
-		formData.Set("client_id", "cloud-services")
-		formData.Set("refresh_token", token)
-		fmt.Printf("Form Data: %s\n", formData.Encode())
-
-		// Create the HTTP POST request
-		resp, err := http.PostForm(authUrl, formData)
-		if err != nil {
-			fmt.Printf("Error making request to authUrl: %v\n", err)
-			return CachedGalaxyClient{}
-		}
-		defer resp.Body.Close()
-
-		// Read the response body
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Printf("Error reading response body: %v\n", err)
-			return CachedGalaxyClient{}
-		}
-
-		// Parse the response JSON
-		var respData map[string]interface{}
-		err = json.Unmarshal(body, &respData)
+		var err error
+		auth, err = newTokenSource(authUrl, token)
 		if err != nil {
-			fmt.Printf("Error unmarshalling response JSON: %v\n", err)
-			return CachedGalaxyClient{}
-		}
-
-		// Extract the access token
-		if newtoken, ok := respData["access_token"].(string); ok {
-			logrus.Infof("Access token: %s\n", newtoken)
-			accessToken = newtoken
-		} else {
-			fmt.Printf("Access token not found in response: %v\n", respData)
+			return CachedGalaxyClient{}, err
 		}
 	}
 
 	return CachedGalaxyClient{
-		baseUrl:     baseUrl,
-		authUrl:     authUrl,
-		token:       token,
-		accessToken: accessToken,
-		apiPrefix:   apiPrefix,
-		cachePath:   cachePath,
-	}
+		baseUrl:   baseUrl,
+		authUrl:   authUrl,
+		auth:      auth,
+		apiPrefix: apiPrefix,
+		cachePath: cachePath,
+	}, nil
 }
 
 func (c *CachedGalaxyClient) GetUrl(url string) (resp *http.Response, err error) {
 	logrus.Infof("GET URL %s", url)
 
-	// Create a new HTTP request
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		logrus.Errorf("Error creating HTTP request: %v", err)
-		return nil, err
+	get := func(token string) (*http.Response, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		}
+		return httpclient.Default().Do(req)
 	}
 
-	// Add Authorization header if accessToken is non-empty
-	if c.accessToken != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.accessToken))
-		logrus.Infof("Added Authorization header with token")
+	if c.auth == nil {
+		resp, err = get("")
+	} else {
+		resp, err = c.auth.doWithToken(get)
 	}
-
-	// Use the default HTTP client to send the request
-	resp, err = http.DefaultClient.Do(req)
 	if err != nil {
-		logrus.Errorf("Error making HTTP request: %v", err)
-		return nil, err
+		return nil, laxerrors.Wrap(laxerrors.ErrDownloadFailed, err, "GET %s", url)
 	}
 
 	return resp, nil
 }
 
+// Download fetches an artifact, refreshing the access token once if it's rejected
+func (c *CachedGalaxyClient) Download(url string, filePath string, opts utils.DownloadOptions) error {
+	if c.auth == nil {
+		return utils.DownloadToPath(url, filePath, opts)
+	}
+
+	token, err := c.auth.Token()
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		opts.Header = http.Header{}
+		opts.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		err = utils.DownloadToPath(url, filePath, opts)
+		if attempt > 0 || !errors.Is(err, laxerrors.ErrAuthFailed) {
+			return err
+		}
+		if token, err = c.auth.Refresh(token); err != nil {
+			return err
+		}
+	}
+}
+
 // Role represents a single role in the response
 type Role struct {
 	ID            int               `json:"id"`
@@ -166,7 +154,7 @@ func (c *CachedGalaxyClient) GetRoles(namespace string, name string, latest_only
 
 	for url != "" {
 		pct := Percentage(roleCount, rolesFetched)
-		fmt.Printf("%d|%d %d%% %s\n", roleCount, rolesFetched, pct, url)
+		logrus.Infof("%d|%d %d%% %s", roleCount, rolesFetched, pct, url)
 
 		cacheFile := c.getCacheFilePath(url)
 
@@ -215,7 +203,7 @@ func (c *CachedGalaxyClient) GetRoleVersions(roleID int, latest_only bool) ([]Ro
 	url := fmt.Sprintf("%s/api/v1/roles/%d/versions/", c.baseUrl, roleID)
 
 	for url != "" {
-		fmt.Printf("\t%s\n", url)
+		logrus.Debugf("\t%s", url)
 		cacheFile := c.getCacheFilePath(url)
 
 		var versionsResponse RoleVersionsResponse
@@ -308,7 +296,7 @@ func (c *CachedGalaxyClient) loadFromCache(path string, response *RolesResponse)
 }
 
 func (c *CachedGalaxyClient) loadCollectionsFromCache(path string, response *CollectionResponse) error {
-	fmt.Printf("read cached %s\n", path)
+	logrus.Debugf("read cached %s", path)
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
@@ -336,7 +324,7 @@ func (c *CachedGalaxyClient) fetchCollectionVersionDetailsFromServer(url string,
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to fetch collection version details: %s", resp.Status)
+		return fmt.Errorf("failed to fetch collection version details: %w", laxerrors.FromHTTPStatus(url, resp))
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -371,7 +359,7 @@ func (c *CachedGalaxyClient) fetchFromServer(url, cacheFile string, response *Ro
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to fetch: %s", resp.Status)
+		return fmt.Errorf("failed to fetch: %w", laxerrors.FromHTTPStatus(url, resp))
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -397,7 +385,7 @@ func (c *CachedGalaxyClient) fetchCollectionsFromServer(url, cacheFile string, r
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to fetch collections: %s", resp.Status)
+		return fmt.Errorf("failed to fetch collections: %w", laxerrors.FromHTTPStatus(url, resp))
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -422,7 +410,7 @@ func (c *CachedGalaxyClient) fetchVersionsFromServer(url, cacheFile string, resp
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to fetch role versions: %s", resp.Status)
+		return fmt.Errorf("failed to fetch role versions: %w", laxerrors.FromHTTPStatus(url, resp))
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -2,13 +2,17 @@ package galaxy_sync
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/repository"
 	"github.com/jctanner/lax/internal/types"
 	"github.com/jctanner/lax/internal/utils"
 	"github.com/sirupsen/logrus"
@@ -48,6 +52,16 @@ func GalaxySync(kwargs *types.CmdKwargs) error {
 	rolesDir := path.Join(dest, "roles")
 	utils.MakeDirs(rolesDir)
 
+	// partial downloads from an earlier sync are resumed, unless they're old
+	utils.RemoveStalePartFiles(collectionsDir, 24*time.Hour)
+	utils.RemoveStalePartFiles(rolesDir, 24*time.Hour)
+
+	// new artifacts go where the mirror's layout puts them, lax createrepo --layout picks it
+	layout, err := repository.RepoLayout(dest)
+	if err != nil {
+		return err
+	}
+
 	// make the api client
 	/*
 		apiClient := CachedGalaxyClient{
@@ -58,34 +72,51 @@ func GalaxySync(kwargs *types.CmdKwargs) error {
 			cachePath: cacheDir,
 		}
 	*/
-	apiClient := NewCachedGalaxyClient(
+	token := ""
+	if kwargs.AuthUrl != "" {
+		var err error
+		if token, err = ReadToken(kwargs.Token, kwargs.TokenFile); err != nil {
+			return err
+		}
+	}
+	apiClient, err := NewCachedGalaxyClient(
 		server,
 		kwargs.AuthUrl,
-		kwargs.Token,
+		token,
 		kwargs.ApiPrefix,
 		cacheDir,
 	)
+	if err != nil {
+		return err
+	}
 
-	var requirements *Requirements
+	var requirements *types.Requirements
 
 	if requirements_file != "" {
 		if !utils.IsFile(requirements_file) {
-			return fmt.Errorf("ERROR: %s does not exist", requirements_file)
+			return laxerrors.New(laxerrors.ErrNotFound, "%s does not exist", requirements_file)
+		}
+		requirements_, err := types.ParseRequirementsFile(requirements_file)
+		if err != nil {
+			return laxerrors.Wrap(laxerrors.ErrUsage, err, "failed to parse %s", requirements_file)
 		}
-		requirements_, _ := parseRequirements(requirements_file)
 		pretty, _ := utils.PrettyPrint(requirements_)
-		fmt.Println(pretty)
+		logrus.Debugf("requirements: %s", pretty)
 		//return nil
 		requirements = requirements_
 	}
 
+	// what was synced gets reported on stdout, even when part of it failed
+	report := syncReport{Dest: dest}
+	defer report.Print(os.Stdout)
+
 	if roles_only || !collections_only {
 
 		// cache ondisk filenames ...
 		fc := utils.FileStore{}
 		tarBalls, ferr := utils.FindMatchingFiles(rolesDir, "*.tar.gz")
 		if ferr != nil {
-			print("%s\n", ferr)
+			logrus.Errorf("%s", ferr)
 		}
 		//fmt.Printf("%s\n", tarBalls)
 		for _, tarBall := range tarBalls {
@@ -109,6 +140,9 @@ func GalaxySync(kwargs *types.CmdKwargs) error {
 
 			for _, rrole := range requirements.Roles {
 				parts := strings.Split(rrole.Name, ".")
+				if len(parts) != 2 {
+					return laxerrors.New(laxerrors.ErrUsage, "%s is not a namespace.name role", rrole.Name)
+				}
 				_namespace := parts[0]
 				_name := parts[1]
 				_roles, err := syncRoles(apiClient, _namespace, _name, latest_only)
@@ -120,12 +154,13 @@ func GalaxySync(kwargs *types.CmdKwargs) error {
 		}
 
 		logrus.Infof("%d total roles\n", len(roles))
+		report.Roles = len(roles)
 
 		maxConcurrent := download_concurrency
-		err := processRoles(maxConcurrent, latest_only, roles, rolesDir, cacheDir, version, &fc)
+		failed, err := processRoles(maxConcurrent, latest_only, roles, rolesDir, layout, cacheDir, version, &fc)
+		report.RolesFailed = failed
 		if err != nil {
-			logrus.Errorf("role processing failed: %s\n", err)
-			panic("role processing failed")
+			return err
 		}
 	}
 
@@ -145,6 +180,9 @@ func GalaxySync(kwargs *types.CmdKwargs) error {
 
 			for _, _col := range requirements.Collections {
 				parts := strings.Split(_col.Name, ".")
+				if len(parts) != 2 {
+					return laxerrors.New(laxerrors.ErrUsage, "%s is not a namespace.name collection", _col.Name)
+				}
 				_namespace := parts[0]
 				_name := parts[1]
 				_cols, err := syncCollections(server, dest, apiClient, _namespace, _name, latest_only)
@@ -156,11 +194,30 @@ func GalaxySync(kwargs *types.CmdKwargs) error {
 		}
 
 		logrus.Infof("%d total collection versions\n", len(collections))
+		report.Collections = len(collections)
 
 		maxConcurrent := download_concurrency
 
 		var wg sync.WaitGroup
 		sem := make(chan struct{}, maxConcurrent) // semaphore to limit concurrency
+		failures := syncFailures{}
+		var downloaded int64
+
+		// only the artifacts that aren't on disk yet count towards progress
+		missing, missingSize := 0, int64(0)
+		collectionDir := func(col CollectionVersionDetail) string {
+			if layout == repository.LayoutSharded {
+				return path.Join(collectionsDir, col.Namespace.Name)
+			}
+			return collectionsDir
+		}
+		for _, cv := range collections {
+			if !utils.IsFile(path.Join(collectionDir(cv), path.Base(cv.Artifact.FileName))) {
+				missing++
+				missingSize += int64(cv.Artifact.Size)
+			}
+		}
+		progress := utils.StartProgress("collection downloads", missing, missingSize)
 
 		for ix, cv := range collections {
 			sem <- struct{}{} // acquire a slot
@@ -170,26 +227,63 @@ func GalaxySync(kwargs *types.CmdKwargs) error {
 				defer func() { <-sem }() // release the slot
 
 				fn := path.Base(col.Artifact.FileName)
-				fp := path.Join(collectionsDir, fn)
+				fp := path.Join(collectionDir(col), fn)
 				if !utils.IsFile(fp) {
 					logrus.Infof("call download of %s to %s", col.DownloadUrl, fp)
-					if apiClient.accessToken != "" {
-						utils.DownloadBinaryFileToPathWithBearerToken(col.DownloadUrl, apiClient.accessToken, fp)
-					} else {
-						utils.DownloadBinaryFileToPath(col.DownloadUrl, fp)
+					opts := utils.DownloadOptions{
+						Size:   int64(col.Artifact.Size),
+						Sha256: col.Artifact.Sha256,
 					}
+					if err := apiClient.Download(col.DownloadUrl, fp, opts); err != nil {
+						logrus.Errorf("%s", err)
+						failures.add(err)
+						return
+					}
+					atomic.AddInt64(&downloaded, 1)
 				}
 
 			}(ix, cv)
 		}
 
 		wg.Wait()
+		progress.Finish()
+		report.CollectionsDownloaded = int(downloaded)
+		report.CollectionsFailed = failures.count()
+		if err := failures.err("collection versions", len(collections)); err != nil {
+			return err
+		}
 
 	}
 
 	return nil
 }
 
+// syncFailures collects the errors from concurrent downloads
+type syncFailures struct {
+	mu     sync.Mutex
+	errors []error
+}
+
+func (f *syncFailures) add(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors = append(f.errors, err)
+}
+
+func (f *syncFailures) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.errors)
+}
+
+// err summarizes the failures, keeping the first one wrapped so its kind survives
+func (f *syncFailures) err(what string, total int) error {
+	if len(f.errors) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d %s failed to sync, first error: %w", len(f.errors), total, what, f.errors[0])
+}
+
 /*
 func processRoles(maxConcurrent int, latest_only bool, roles []Role, rolesDir string, cacheDir string, version string, fc *utils.FileStore) error {
 
@@ -199,6 +293,7 @@ func processRoles(maxConcurrent int, latest_only bool, roles []Role, rolesDir st
 
 	var wg sync.WaitGroup
 	sem := make(chan struct{}, maxConcurrent) // semaphore to limit concurrency
+	failures := syncFailures{}
 
 	for ix, role := range roles {
 		wg.Add(1)
@@ -244,6 +339,7 @@ func processRoles(maxConcurrent int, latest_only bool, roles []Role, rolesDir st
 				err := handleUnversionedRole(role, rolesDir, cacheDir, fc)
 				if err != nil {
 					logrus.Errorf("%s\n", err)
+					failures.add(err)
 				}
 			}
 		}(ix, role)
@@ -251,17 +347,20 @@ func processRoles(maxConcurrent int, latest_only bool, roles []Role, rolesDir st
 
 	wg.Wait()
 	close(sem) // close the semaphore channel
-	return nil
+	return failures.err("roles", len(roles))
 }
 */
 
-func processRoles(maxConcurrent int, latest_only bool, roles []Role, rolesDir string, cacheDir string, version string, fc *utils.FileStore) error {
-	logger := logrus.New()
-	logger.SetLevel(logrus.DebugLevel)
-	logger.AddHook(&utils.GoroutineIDHook{})
+func processRoles(maxConcurrent int, latest_only bool, roles []Role, rolesDir string, layout int, cacheDir string, version string, fc *utils.FileStore) (int, error) {
+	logger := logrus.StandardLogger()
+
+	// role sizes aren't in the galaxy api, so there is no total or eta
+	progress := utils.StartProgress("role downloads", 0, 0)
+	defer progress.Finish()
 
 	var wg sync.WaitGroup
 	sem := make(chan struct{}, maxConcurrent) // semaphore to limit concurrency
+	failures := syncFailures{}
 
 	for ix, role := range roles {
 		wg.Add(1)
@@ -275,6 +374,13 @@ func processRoles(maxConcurrent int, latest_only bool, roles []Role, rolesDir st
 
 			rname := fmt.Sprintf("%s.%s", role.GithubUser, role.GithubRepo)
 
+			// a role galaxy-sync renames stays in its galaxy namespace's directory, the index says where it is
+			rolesDir := rolesDir
+			if layout == repository.LayoutSharded {
+				rolesDir = path.Join(rolesDir, role.SummaryFields.Namespace.Name)
+				utils.MakeDirs(rolesDir)
+			}
+
 			badFile := path.Join(rolesDir, fmt.Sprintf("%s-%s.bad", role.GithubUser, role.GithubRepo))
 			if utils.IsFile(badFile) {
 				logger.Debugf("%s found %s, skipping\n", rname, badFile)
@@ -297,6 +403,7 @@ func processRoles(maxConcurrent int, latest_only bool, roles []Role, rolesDir st
 					err := handleRoleVersion(role, roleVersion, rolesDir, version)
 					if err != nil {
 						logrus.Errorf("%s\n", err)
+						failures.add(err)
 					}
 				}
 			} else {
@@ -304,6 +411,7 @@ func processRoles(maxConcurrent int, latest_only bool, roles []Role, rolesDir st
 				err := handleUnversionedRole(role, rolesDir, cacheDir, fc)
 				if err != nil {
 					logrus.Errorf("%s\n", err)
+					failures.add(err)
 				}
 			}
 		}(ix, role)
@@ -311,7 +419,7 @@ func processRoles(maxConcurrent int, latest_only bool, roles []Role, rolesDir st
 
 	wg.Wait()
 	close(sem) // close the semaphore channel
-	return nil
+	return failures.count(), failures.err("roles", len(roles))
 }
 
 func handleRoleVersion(role Role, roleVersion RoleVersion, rolesDir string, filterVersion string) error {
@@ -355,12 +463,14 @@ func handleRoleVersion(role Role, roleVersion RoleVersion, rolesDir string, filt
 		time.Sleep(1 * time.Second)
 
 	} else if err != nil {
-		// mark as "BAD"
+		// mark as "BAD" so later syncs skip it
 		logrus.Errorf("%s marking as 'bad' %s\n", rvname, err)
 		file, _ := os.Create(vBadFile)
 		file.Write([]byte(fmt.Sprintf("%s\n", err)))
 		//defer file.Close()
 		file.Close()
+		os.Remove(lockfile)
+		return fmt.Errorf("%s: %w", rvname, err)
 	}
 
 	os.Remove(lockfile)
@@ -383,8 +493,24 @@ func handleUnversionedRole(role Role, rolesDir string, cacheDir string, fc *util
 		file.Write([]byte(fmt.Sprintf("%s\n", err)))
 		//defer file.Close()
 		file.Close()
-		return nil
+		return fmt.Errorf("%s: %w", rname, err)
 	}
 	logrus.Debugf("%s artifact:%s\n", rname, fn)
 	return nil
 }
+
+// syncReport is the summary printed on stdout when a sync finishes
+type syncReport struct {
+	Dest                  string
+	Roles                 int
+	RolesFailed           int
+	Collections           int
+	CollectionsDownloaded int
+	CollectionsFailed     int
+}
+
+func (r *syncReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "synced %s\n", r.Dest)
+	fmt.Fprintf(w, "  roles: %d found, %d failed\n", r.Roles, r.RolesFailed)
+	fmt.Fprintf(w, "  collection versions: %d found, %d downloaded, %d failed\n", r.Collections, r.CollectionsDownloaded, r.CollectionsFailed)
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"sort"
 
+	"github.com/jctanner/lax/internal/laxerrors"
 	"github.com/jctanner/lax/internal/repository"
 	"github.com/jctanner/lax/internal/utils"
 	"github.com/sirupsen/logrus"
@@ -48,14 +49,11 @@ func GetRoleVersionArtifact(role Role, version RoleVersion, destdir string) (str
 	//fmt.Printf("\t%s -> %s\n", baseUrl, tarFilePath)
 	logrus.Infof("\tHEAD %s\n", tarUrl)
 	if !utils.IsURLGood(tarUrl) {
-		return "", true, fmt.Errorf("url failed http.HEAD check")
+		return "", true, laxerrors.New(laxerrors.ErrDownloadFailed, "%s failed http.HEAD check", tarUrl)
 	}
 
-	utils.DownloadBinaryFileToPath(tarUrl, tarFilePath)
-
-	if !utils.IsFile(tarFilePath) {
-		logrus.Errorf("%s DID NOT ACTUALLY DOWNLOAD!!!\n", tarFilePath)
-		panic("")
+	if _, err := utils.DownloadBinaryFileToPath(tarUrl, tarFilePath); err != nil {
+		return "", true, err
 	}
 
 	newNamespace := role.SummaryFields.Namespace.Name
@@ -63,10 +61,9 @@ func GetRoleVersionArtifact(role Role, version RoleVersion, destdir string) (str
 	needsRename := false
 
 	meta, err := repository.GetRoleMetaFromTarball(tarFilePath)
-	logrus.Debugf("meta: %s\n", meta)
+	logrus.Debugf("meta: %v\n", meta)
 	if err != nil {
-		logrus.Errorf("%s\n", err)
-		panic("")
+		return "", true, fmt.Errorf("failed to read the role meta from %s: %w", tarFilePath, err)
 	}
 
 	/*
@@ -106,16 +103,14 @@ func GetRoleVersionArtifact(role Role, version RoleVersion, destdir string) (str
 	err = os.Rename(tarFilePath, newFp)
 
 	if err != nil {
-		logrus.Errorf("%s\n", err)
-		panic("")
+		return "", true, err
 	}
 
 	err = utils.CreateSymlink(newFp, tarFilePath)
 	//err = utils.CreateSymlink(tarFilePath, newFp)
 
 	if err != nil {
-		logrus.Errorf("%s\n", err)
-		panic("")
+		return "", true, err
 	}
 
 	//panic("")
@@ -166,22 +161,20 @@ func MakeRoleVersionArtifact(role Role, rolesDir string, cacheDir string, fc *ut
 		logrus.Infof("clone %s -> %s\n", repoUrl, repoPath)
 		err := utils.CloneRepo(repoUrl, repoPath)
 		if err != nil {
-			fmt.Printf("failed to clone %s to %s ::%s\n", repoUrl, repoPath, err)
-			//panic("")
-			return "", err
+			return "", laxerrors.Wrap(laxerrors.ErrDownloadFailed, err, "failed to clone %s to %s", repoUrl, repoPath)
 		}
 	}
 
 	if !utils.IsDir(repoPath) {
-		panic(fmt.Sprintf("%s failed to clone to %s for some unknown reason", repoUrl, repoPath))
+		return "", laxerrors.New(laxerrors.ErrDownloadFailed, "%s failed to clone to %s for some unknown reason", repoUrl, repoPath)
 	}
 
 	isValid, verr := utils.IsValidGitRepo(repoPath)
 	if verr != nil {
-		panic(fmt.Sprintf("%s failed to correcly clone to %s for some unknown reason: %s", repoUrl, repoPath, verr))
+		return "", laxerrors.Wrap(laxerrors.ErrDownloadFailed, verr, "%s failed to correctly clone to %s", repoUrl, repoPath)
 	}
 	if !isValid {
-		panic(fmt.Sprintf("%s failed to correctly clone to %s for some unknown reason", repoUrl, repoPath))
+		return "", laxerrors.New(laxerrors.ErrDownloadFailed, "%s failed to correctly clone to %s for some unknown reason", repoUrl, repoPath)
 	}
 
 	if role.GithubBranch != "" {
@@ -213,7 +206,7 @@ func MakeRoleVersionArtifact(role Role, rolesDir string, cacheDir string, fc *ut
 	logrus.Debugf("3. %s == %s\n", role.Commit, formattedDate)
 
 	if formattedDate == "" {
-		panic(fmt.Sprintf("bad date for '%s' '%s'", repoPath, role.Commit))
+		return "", fmt.Errorf("bad date for '%s' '%s'", repoPath, role.Commit)
 	}
 
 	//panic("")
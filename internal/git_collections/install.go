@@ -7,8 +7,8 @@ import (
 
 	"net/http"
 	"os"
-	"time"
 
+	"github.com/jctanner/lax/internal/httpclient"
 	"github.com/jctanner/lax/internal/utils"
 )
 
@@ -103,17 +103,13 @@ func ensureDir(path string) error {
 
 // exists performs an HTTP HEAD request to check if the URL exists
 func UrlExists(url string) bool {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
 	req, err := http.NewRequest("HEAD", url, nil)
 	if err != nil {
 		fmt.Println("Error creating request:", err)
 		return false
 	}
 
-	resp, err := client.Do(req)
+	resp, err := httpclient.Default().Do(req)
 	if err != nil {
 		fmt.Println("Error performing request:", err)
 		return false
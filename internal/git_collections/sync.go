@@ -12,6 +12,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/jctanner/lax/internal/httpclient"
 	"github.com/jctanner/lax/internal/utils"
 )
 
@@ -41,7 +42,7 @@ type VersionsResponse struct {
 func FetchPage(server string, limit, offset int) (*CollectionResponse, error) {
 	url := fmt.Sprintf("%s/api/v3/collections/?limit=%d&offset=%d", server, limit, offset)
 	fmt.Printf("fetching %s\n", url)
-	resp, err := http.Get(url)
+	resp, err := httpclient.Get(url)
 	if err != nil {
 		return nil, err
 	}
@@ -67,7 +68,7 @@ func FetchPage(server string, limit, offset int) (*CollectionResponse, error) {
 func FetchVersionsPage(server, versionsURL string, limit, offset int) (*VersionsResponse, error) {
 	url := fmt.Sprintf("%s%s?limit=%d&offset=%d", server, versionsURL, limit, offset)
 	fmt.Printf("fetching %s\n", url)
-	resp, err := http.Get(url)
+	resp, err := httpclient.Get(url)
 	if err != nil {
 		return nil, err
 	}
@@ -91,7 +92,7 @@ func FetchVersionsPage(server, versionsURL string, limit, offset int) (*Versions
 
 func FetchVersionPage(versionURL string) error {
 	fmt.Printf("fetching %s\n", versionURL)
-	resp, err := http.Get(versionURL)
+	resp, err := httpclient.Get(versionURL)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,274 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// UserAgent is sent with every request lax makes
+const UserAgent = "lax (+https://github.com/jctanner/lax)"
+
+// Config holds the knobs shared by every http request lax makes
+type Config struct {
+	// how long to wait for a tcp connection
+	ConnectTimeout time.Duration
+	// how long to wait for the response headers once the request is sent
+	ResponseTimeout time.Duration
+	// how many times to retry a request after the first attempt
+	Retries int
+	// the first retry waits this long and each later one waits twice as long
+	BackoffBase time.Duration
+	// no single wait, including a server's Retry-After, is longer than this
+	MaxBackoff time.Duration
+
+	// overrides HTTPS_PROXY and HTTP_PROXY, may include user:password@
+	Proxy string
+	// overrides NO_PROXY, a comma separated list of hosts and domains
+	NoProxy string
+	// a PEM bundle of extra certificate authorities to trust
+	CACert string
+	// a PEM client certificate and key for servers that require mTLS
+	ClientCert string
+	ClientKey  string
+	// skip verifying server certificates
+	Insecure bool
+
+	// per url credentials, the longest matching url wins
+	Credentials []Credential
+	// a netrc file to look up https hosts without a matching credential
+	Netrc string
+	// also send the netrc default entry to https hosts no machine matches
+	NetrcDefault bool
+}
+
+// DefaultConfig is used until Configure is called
+func DefaultConfig() Config {
+	return Config{
+		ConnectTimeout:  10 * time.Second,
+		ResponseTimeout: 60 * time.Second,
+		Retries:         4,
+		BackoffBase:     1 * time.Second,
+		MaxBackoff:      60 * time.Second,
+	}
+}
+
+/*
+Client wraps an http.Client with retries. Network errors and 5xx
+responses are retried with exponential backoff, and a 429 waits for as
+long as the server's Retry-After asks (up to MaxBackoff). Anything else
+is returned to the caller as-is to check the status code. A request with
+a body is only retried if it has a GetBody to resend it, so a caller
+whose request isn't safe to repeat leaves GetBody unset.
+*/
+type Client struct {
+	config Config
+	http   *http.Client
+	auth   *authTransport
+	sleep  func(context.Context, time.Duration) error
+}
+
+var (
+	sharedMu sync.Mutex
+	shared   *Client
+)
+
+// New makes a client from a config
+func New(config Config) (*Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   config.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.ResponseHeaderTimeout = config.ResponseTimeout
+	transport.Proxy = proxyFunc(config)
+
+	tlsConfig, err := tlsConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	auth := &authTransport{base: transport, creds: config.Credentials, netrcDefault: config.NetrcDefault}
+	if config.Netrc != "" {
+		auth.netrc, err = loadNetrc(config.Netrc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Client{
+		config: config,
+		// no overall timeout, a large artifact can legitimately take a while
+		http:  &http.Client{Transport: auth},
+		auth:  auth,
+		sleep: sleepContext,
+	}, nil
+}
+
+// Configure replaces the shared client returned by Default
+func Configure(config Config) error {
+	client, err := New(config)
+	if err != nil {
+		return err
+	}
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	shared = client
+	return nil
+}
+
+// Default returns the shared client, every caller in lax should use it
+func Default() *Client {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if shared == nil {
+		// the default config has no files to load so it can't fail
+		shared, _ = New(DefaultConfig())
+	}
+	return shared
+}
+
+// HTTPClient is the underlying client without retries, for libraries
+// like go-git that need a *http.Client but should share the proxy, tls
+// and credential settings
+func (c *Client) HTTPClient() *http.Client {
+	return c.http
+}
+
+// Get is a convenience wrapper around Default().Get
+func Get(url string) (*http.Response, error) {
+	return Default().Get(url)
+}
+
+func (c *Client) Get(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+func (c *Client) Head(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+func (c *Client) PostForm(url string, data url.Values) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.Do(req)
+}
+
+// Do sends a request, retrying as described on Client
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", UserAgent)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		resp, err := c.http.Do(req)
+		if !retryable(resp, err) || attempt >= c.config.Retries || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+
+		wait := c.backoff(attempt, resp)
+		if err != nil {
+			logrus.Warnf("%s %s failed, retrying in %s: %s", req.Method, req.URL.Redacted(), wait, err)
+		} else {
+			logrus.Warnf("%s %s returned %s, retrying in %s", req.Method, req.URL.Redacted(), resp.Status, wait)
+			// drain so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+
+		if err := c.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		// a cancelled request is not going to get better
+		return !errors.Is(err, context.Canceled)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// backoff is how long to wait before the next attempt
+func (c *Client) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return min(wait, c.config.MaxBackoff)
+		}
+	}
+
+	wait := c.config.BackoffBase << attempt
+	if wait <= 0 || wait > c.config.MaxBackoff {
+		wait = c.config.MaxBackoff
+	}
+	// up to 20% jitter so concurrent downloads don't retry in lockstep
+	if wait > 0 {
+		wait += time.Duration(rand.Int63n(int64(wait)/5 + 1))
+	}
+	return min(wait, c.config.MaxBackoff)
+}
+
+// parseRetryAfter reads either form of the header, delay-seconds or an http date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		wait := when.Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting to retry: %w", ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
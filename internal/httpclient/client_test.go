@@ -0,0 +1,98 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"", 0, false},
+		{"120", 120 * time.Second, true},
+		{"-1", 0, false},
+		{"Sat, 01 Jun 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Sat, 01 Jun 2024 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			result, ok := parseRetryAfter(tt.value, now)
+			if result != tt.expected || ok != tt.ok {
+				t.Errorf("parseRetryAfter(%q) = %s, %t; want %s, %t", tt.value, result, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDoRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		retries    int
+		expected   int
+		attempts   int32
+		firstSleep time.Duration
+	}{
+		{"ok", []int{200}, 3, 200, 1, 0},
+		{"5xx then ok", []int{503, 502, 200}, 3, 200, 3, time.Second},
+		{"429 honours retry-after", []int{429, 200}, 3, 200, 2, 7 * time.Second},
+		{"gives up", []int{500, 500, 500}, 2, 500, 3, time.Second},
+		{"4xx is not retried", []int{404, 200}, 3, 404, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("User-Agent") != UserAgent {
+					t.Errorf("unexpected user agent %q", r.Header.Get("User-Agent"))
+				}
+				n := atomic.AddInt32(&attempts, 1)
+				status := tt.statuses[n-1]
+				if status == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", "7")
+				}
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			client, err := New(Config{Retries: tt.retries, BackoffBase: time.Second, MaxBackoff: time.Minute})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sleeps []time.Duration
+			client.sleep = func(ctx context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.expected {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.expected)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("%d attempts, want %d", attempts, tt.attempts)
+			}
+			// backoff has up to 20% jitter on top
+			if tt.firstSleep > 0 && (len(sleeps) == 0 || sleeps[0] < tt.firstSleep || sleeps[0] > tt.firstSleep*6/5) {
+				t.Errorf("sleeps %v, want the first to be about %s", sleeps, tt.firstSleep)
+			}
+		})
+	}
+}
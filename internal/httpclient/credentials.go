@@ -0,0 +1,210 @@
+package httpclient
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+Credential authenticates requests to every url under URL. A Token is sent
+as a bearer token, otherwise Username and Password are sent with basic
+auth.
+*/
+type Credential struct {
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Token    string `yaml:"token"`
+}
+
+// netrcEntry is one machine (or the default, with an empty Machine) from a netrc file
+type netrcEntry struct {
+	Machine  string
+	Login    string
+	Password string
+}
+
+// LoadCredentials reads a yaml list of credentials
+func LoadCredentials(path string) ([]Credential, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the credentials file: %w", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		logrus.Warnf("%s can be read by other users, consider chmod 600", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the credentials file: %w", err)
+	}
+	var creds []Credential
+	if err := yaml.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, cred := range creds {
+		if _, err := url.Parse(cred.URL); err != nil || cred.URL == "" {
+			return nil, fmt.Errorf("%s has a credential without a valid url", path)
+		}
+	}
+	return creds, nil
+}
+
+// loadNetrc parses the machine, default, login and password tokens of a netrc file
+func loadNetrc(path string) ([]netrcEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the netrc file: %w", err)
+	}
+	defer f.Close()
+
+	var entries []netrcEntry
+	var current *netrcEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		switch scanner.Text() {
+		case "machine":
+			if !scanner.Scan() {
+				break
+			}
+			entries = append(entries, netrcEntry{Machine: scanner.Text()})
+			current = &entries[len(entries)-1]
+		case "default":
+			entries = append(entries, netrcEntry{})
+			current = &entries[len(entries)-1]
+		case "login":
+			if scanner.Scan() && current != nil {
+				current.Login = scanner.Text()
+			}
+		case "password":
+			if scanner.Scan() && current != nil {
+				current.Password = scanner.Text()
+			}
+		case "macdef":
+			// macros run until a blank line and aren't something lax can use
+			return entries, nil
+		}
+	}
+	return entries, scanner.Err()
+}
+
+/*
+authTransport adds credentials to requests that don't already carry an
+Authorization header. Doing it per request, rather than once up front,
+means a redirect to another host only gets that host's credentials.
+*/
+type authTransport struct {
+	base  http.RoundTripper
+	mu    sync.RWMutex
+	creds []Credential
+	netrc []netrcEntry
+	// the netrc default entry would go to every host, so it is only used when asked for
+	netrcDefault bool
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	t.mu.RLock()
+	cred, ok := t.match(req.URL)
+	t.mu.RUnlock()
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+
+	// a RoundTripper must not modify the caller's request
+	req = req.Clone(req.Context())
+	if cred.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cred.Token)
+	} else {
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+	return t.base.RoundTrip(req)
+}
+
+/*
+match finds the credential with the longest url prefix, falling back to
+netrc by host. A credential's url includes its scheme, so it is only
+ever sent where it was configured. netrc entries name a host and no
+scheme, so they are kept off plain http where anyone on the path could
+read them.
+*/
+func (t *authTransport) match(u *url.URL) (Credential, bool) {
+	target := stripUserinfo(u)
+	var best Credential
+	for _, cred := range t.creds {
+		prefix := strings.TrimSuffix(cred.URL, "/")
+		if target != prefix && !strings.HasPrefix(target, prefix+"/") {
+			continue
+		}
+		if len(prefix) > len(strings.TrimSuffix(best.URL, "/")) {
+			best = cred
+		}
+	}
+	if best.URL != "" {
+		return best, true
+	}
+
+	if u.Scheme != "https" {
+		return Credential{}, false
+	}
+	var fallback *netrcEntry
+	for i, entry := range t.netrc {
+		if entry.Machine != "" && entry.Machine == u.Hostname() {
+			return Credential{Username: entry.Login, Password: entry.Password}, true
+		}
+		if entry.Machine == "" && fallback == nil {
+			fallback = &t.netrc[i]
+		}
+	}
+	if fallback != nil && t.netrcDefault {
+		return Credential{Username: fallback.Login, Password: fallback.Password}, true
+	}
+	return Credential{}, false
+}
+
+func (t *authTransport) add(cred Credential) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.creds = append(t.creds, cred)
+}
+
+// AddCredential registers another credential with the client
+func (c *Client) AddCredential(cred Credential) {
+	c.auth.add(cred)
+}
+
+/*
+SplitUserinfo removes a user:password@ from a url so it can be logged,
+returning the credential it held (if any) scoped to the cleaned url.
+*/
+func SplitUserinfo(rawURL string) (string, *Credential) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL, nil
+	}
+	password, _ := u.User.Password()
+	cred := &Credential{Username: u.User.Username(), Password: password}
+	u.User = nil
+	cred.URL = u.String()
+	return cred.URL, cred
+}
+
+func stripUserinfo(u *url.URL) string {
+	clean := *u
+	clean.User = nil
+	clean.RawQuery = ""
+	clean.Fragment = ""
+	return clean.String()
+}
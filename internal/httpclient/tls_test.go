@@ -0,0 +1,170 @@
+package httpclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeClientCert makes a self signed client certificate and returns the
+// cert and key paths along with a pool that trusts it
+func writeClientCert(t *testing.T, dir string) (string, string, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "lax test client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return certPath, keyPath, pool
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath, clientPool := writeClientCert(t, dir)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+
+	mtlsServer := httptest.NewUnstartedServer(handler)
+	mtlsServer.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientPool}
+	mtlsServer.StartTLS()
+	defer mtlsServer.Close()
+
+	// both servers use the same httptest certificate
+	caPath := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+
+	tests := []struct {
+		name   string
+		url    string
+		config Config
+		ok     bool
+	}{
+		{"untrusted", server.URL, Config{}, false},
+		{"ca bundle", server.URL, Config{CACert: caPath}, true},
+		{"insecure", server.URL, Config{Insecure: true}, true},
+		{"mtls without a cert", mtlsServer.URL, Config{CACert: caPath}, false},
+		{"mtls", mtlsServer.URL, Config{CACert: caPath, ClientCert: certPath, ClientKey: keyPath}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := New(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Get(tt.url)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if ok := err == nil && resp.StatusCode == http.StatusOK; ok != tt.ok {
+				t.Errorf("request succeeded: %t, want %t (%v)", ok, tt.ok, err)
+			}
+		})
+	}
+}
+
+func TestBadTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	os.WriteFile(empty, []byte("nothing here"), 0600)
+
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"missing ca", Config{CACert: filepath.Join(dir, "nope.pem")}},
+		{"empty ca", Config{CACert: empty}},
+		{"cert without key", Config{ClientCert: empty}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.config); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestProxy(t *testing.T) {
+	var seen []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.URL.String()+" "+r.Header.Get("Proxy-Authorization"))
+		w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+
+	tests := []struct {
+		name     string
+		config   Config
+		url      string
+		expected string
+	}{
+		{"proxied", Config{Proxy: "http://user:pass@" + proxy.Listener.Addr().String()}, "http://repo.example.test/repometa.json", "http://repo.example.test/repometa.json Basic dXNlcjpwYXNz"},
+		{"no proxy", Config{Proxy: proxy.URL, NoProxy: "example.test"}, "http://repo.example.test/repometa.json", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			client, err := New(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.config.Retries = 0
+			resp, err := client.Get(tt.url)
+			if err == nil {
+				resp.Body.Close()
+			}
+			result := ""
+			if len(seen) > 0 {
+				result = seen[0]
+			}
+			if result != tt.expected {
+				t.Errorf("proxy saw %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
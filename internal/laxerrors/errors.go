@@ -0,0 +1,96 @@
+package laxerrors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+/*
+Sentinel error kinds. Anything returned up to the cli is matched against
+these with errors.Is to pick the process exit code, so wrap with New or
+Wrap (or fmt.Errorf and %w) instead of flattening to a string.
+*/
+var (
+	ErrUsage            = errors.New("usage error")
+	ErrNotFound         = errors.New("not found")
+	ErrConflict         = errors.New("dependency conflict")
+	ErrDownloadFailed   = errors.New("download failed")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrAuthFailed       = errors.New("authentication failed")
+)
+
+// Exit codes returned by the lax cli
+const (
+	ExitOK               = 0
+	ExitGeneric          = 1
+	ExitUsage            = 2
+	ExitNotFound         = 3
+	ExitConflict         = 4
+	ExitDownloadFailed   = 5
+	ExitChecksumMismatch = 6
+	ExitAuthFailed       = 7
+)
+
+// Error is a message tagged with one of the sentinel kinds and an
+// optional underlying cause
+type Error struct {
+	Kind error
+	Msg  string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Msg + ": " + e.Err.Error()
+	}
+	return e.Msg
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Kind}
+}
+
+// New makes an error of the given kind
+func New(kind error, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Wrap makes an error of the given kind around an underlying cause
+func Wrap(kind error, err error, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, args...), Err: err}
+}
+
+// FromHTTPStatus classifies a failed http response. 401 and 403 are auth
+// failures and everything else is a failed download.
+func FromHTTPStatus(url string, resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return New(ErrAuthFailed, "%s returned %s", url, resp.Status)
+	}
+	return New(ErrDownloadFailed, "%s returned %s", url, resp.Status)
+}
+
+// ExitCode maps an error returned by a command to the process exit code
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrUsage):
+		return ExitUsage
+	case errors.Is(err, ErrNotFound):
+		return ExitNotFound
+	case errors.Is(err, ErrConflict):
+		return ExitConflict
+	case errors.Is(err, ErrChecksumMismatch):
+		return ExitChecksumMismatch
+	case errors.Is(err, ErrAuthFailed):
+		return ExitAuthFailed
+	case errors.Is(err, ErrDownloadFailed):
+		return ExitDownloadFailed
+	}
+	return ExitGeneric
+}
@@ -0,0 +1,44 @@
+package laxerrors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"nil", nil, ExitOK},
+		{"plain error", errors.New("boom"), ExitGeneric},
+		{"usage", New(ErrUsage, "bad flag"), ExitUsage},
+		{"not found", New(ErrNotFound, "ns.name was not found"), ExitNotFound},
+		{"conflict", New(ErrConflict, "ns.name"), ExitConflict},
+		{"checksum", New(ErrChecksumMismatch, "file"), ExitChecksumMismatch},
+		{"auth", New(ErrAuthFailed, "url"), ExitAuthFailed},
+		{"download wrapping auth", Wrap(ErrDownloadFailed, New(ErrAuthFailed, "url"), "fetch"), ExitAuthFailed},
+		{"fmt wrapped", fmt.Errorf("install: %w", New(ErrDownloadFailed, "url")), ExitDownloadFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ExitCode(tt.err)
+			if result != tt.expected {
+				t.Errorf("ExitCode(%v) = %d, want %d", tt.err, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	err := Wrap(ErrDownloadFailed, errors.New("connection refused"), "fetching %s", "repometa.json")
+	if err.Error() != "fetching repometa.json: connection refused" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+	if !errors.Is(err, ErrDownloadFailed) {
+		t.Errorf("expected the error to match ErrDownloadFailed")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/jctanner/lax/internal/repository"
@@ -38,18 +39,30 @@ type PackageManager struct {
 	RepoMeta            repository.RepoMetaFile
 	CollectionManifests repository.RepoMetaFile
 	CollectionFiles     repository.RepoMetaFile
+
+	// a --dry-run reads the cache but never writes to it
+	ReadOnly bool
+	// where a read only package manager fetched an index the cache didn't have
+	tempCache string
 }
 
 func (pkgmgr *PackageManager) Initialize() error {
 
-	// where everything goes
+	// where everything goes, created on the first install
 	pkgmgr.BasePath, _ = utils.GetAbsPath(pkgmgr.BasePath)
-	utils.MakeDirs(pkgmgr.BasePath)
 
-	// store meta here
-	//pkgmgr.CachePath = filepath.Join(pkgmgr.BasePath, ".cache")
-	utils.MakeDirs(pkgmgr.CachePath)
+	if !pkgmgr.ReadOnly {
+		// store meta here
+		//pkgmgr.CachePath = filepath.Join(pkgmgr.BasePath, ".cache")
+		if err := utils.MakeDirs(pkgmgr.CachePath); err != nil {
+			return err
+		}
+
+		// partial downloads are resumed by the next install, unless they're old
+		utils.RemoveStalePartFiles(pkgmgr.CachePath, 24*time.Hour)
+	}
 
+	// there is no cached meta until the first sync
 	pkgmgr.ReadRepoMeta()
 	return nil
 }
@@ -57,7 +70,7 @@ func (pkgmgr *PackageManager) Initialize() error {
 func (pkgmgr *PackageManager) HasRepoMeta() bool {
 	// Construct the full path to the repometa.json file
 	filePath := filepath.Join(pkgmgr.CachePath, "repometa.json")
-	fmt.Printf("checking %s\n", filePath)
+	logrus.Debugf("checking %s", filePath)
 	return utils.IsFile(filePath)
 }
 
@@ -85,33 +98,204 @@ func (pkgmgr *PackageManager) ReadRepoMeta() error {
 	}
 	pkgmgr.RepoMeta = rm
 
-	fmt.Printf("repometa: %s\n", repoMeta)
+	logrus.Debugf("repometa: %v", repoMeta)
 	pkgmgr.CollectionManifests = repoMeta.CollectionManifests
 	pkgmgr.CollectionFiles = repoMeta.CollectionFiles
 
 	return nil
 }
 
+/*
+SyncRepoMeta refreshes the cached repo meta if the repo has a newer one.
+A read only package manager fetches it into a temporary directory
+instead, which Close removes.
+*/
+func (pkgmgr *PackageManager) SyncRepoMeta(repoClient repository.RepoClient) error {
+
+	// Is the package manager's meta older? Re-download if so ...
+	if !pkgmgr.HasRepoMeta() {
+		logrus.Debugf("no repo meta found on disk, fetching ...")
+		return pkgmgr.fetchRepoMeta(repoClient)
+	}
+
+	// Is it up to date?
+	pDate := pkgmgr.RepoMeta.Date
+	logrus.Debugf("package manager meta date: %s", pDate)
+	rDate, err := repoClient.GetRepoMetaDate()
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("repo client meta date: %s", rDate)
+
+	d1, _ := time.Parse(time.RFC3339, pDate)
+	d2, _ := time.Parse(time.RFC3339, rDate)
+
+	if d1.Before(d2) {
+		logrus.Debugf("updating local meta cache")
+		return pkgmgr.fetchRepoMeta(repoClient)
+	}
+
+	logrus.Debugf("not updating local meta cache")
+	return nil
+}
+
+func (pkgmgr *PackageManager) fetchRepoMeta(repoClient repository.RepoClient) error {
+	if !pkgmgr.ReadOnly {
+		return repoClient.FetchRepoMeta(pkgmgr.CachePath)
+	}
+	tempCache, err := os.MkdirTemp("", "lax-index-")
+	if err != nil {
+		return err
+	}
+	pkgmgr.Close()
+	pkgmgr.tempCache = tempCache
+	logrus.Debugf("fetching the repo meta into %s, the cache is read only", tempCache)
+	return repoClient.FetchRepoMeta(tempCache)
+}
+
+// Close removes the repo meta a read only package manager fetched
+func (pkgmgr *PackageManager) Close() {
+	if pkgmgr.tempCache != "" {
+		os.RemoveAll(pkgmgr.tempCache)
+		pkgmgr.tempCache = ""
+	}
+}
+
+// GetInstalledCollectionPath returns where namespace.name would be installed
+func (pkgmgr *PackageManager) GetInstalledCollectionPath(namespace string, name string) string {
+	return filepath.Join(pkgmgr.BasePath, "collections", "ansible_collections", namespace, name)
+}
+
+// GetInstalledCollectionVersion reads the version of an installed collection
+// from its MANIFEST.json, returning an empty string if it is not installed
+func (pkgmgr *PackageManager) GetInstalledCollectionVersion(namespace string, name string) (string, error) {
+	manifestFile := filepath.Join(pkgmgr.GetInstalledCollectionPath(namespace, name), "MANIFEST.json")
+	if !utils.IsFile(manifestFile) {
+		return "", nil
+	}
+
+	fileData, err := os.ReadFile(manifestFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var manifest repository.CollectionManifest
+	if err := json.Unmarshal(fileData, &manifest); err != nil {
+		return "", fmt.Errorf("failed to unmarshal JSON: %w", err)
+	}
+
+	return manifest.CollectionInfo.Version, nil
+}
+
+// GetInstalledRolePath returns where namespace.name would be installed
+func (pkgmgr *PackageManager) GetInstalledRolePath(namespace string, name string) string {
+	return filepath.Join(pkgmgr.BasePath, "roles", namespace+"."+name)
+}
+
+// GetInstalledRoleVersion reads the version of an installed role from its
+// meta/.galaxy_install_info, returning an empty string if it is not installed
+func (pkgmgr *PackageManager) GetInstalledRoleVersion(namespace string, name string) (string, error) {
+	infoFile := filepath.Join(pkgmgr.GetInstalledRolePath(namespace, name), "meta", ".galaxy_install_info")
+	if !utils.IsFile(infoFile) {
+		return "", nil
+	}
+
+	fileData, err := os.ReadFile(infoFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var info RoleInstallInfo
+	if err := yaml.Unmarshal(fileData, &info); err != nil {
+		return "", fmt.Errorf("failed to unmarshal YAML: %w", err)
+	}
+
+	return info.Version, nil
+}
+
+// GetInstalledCollections maps namespace.name to version for every collection in the dest dir
+func (pkgmgr *PackageManager) GetInstalledCollections() map[string]string {
+	installed := map[string]string{}
+	cPath := filepath.Join(pkgmgr.BasePath, "collections", "ansible_collections")
+	manifests, _ := filepath.Glob(filepath.Join(cPath, "*", "*", "MANIFEST.json"))
+	for _, manifestFile := range manifests {
+		name := filepath.Base(filepath.Dir(manifestFile))
+		namespace := filepath.Base(filepath.Dir(filepath.Dir(manifestFile)))
+		version, err := pkgmgr.GetInstalledCollectionVersion(namespace, name)
+		if err != nil || version == "" {
+			logrus.Debugf("skipping %s: %v", manifestFile, err)
+			continue
+		}
+		installed[namespace+"."+name] = version
+	}
+	return installed
+}
+
+// GetInstalledRoles maps namespace.name to version for every role in the dest dir
+func (pkgmgr *PackageManager) GetInstalledRoles() map[string]string {
+	installed := map[string]string{}
+	infoFiles, _ := filepath.Glob(filepath.Join(pkgmgr.BasePath, "roles", "*", "meta", ".galaxy_install_info"))
+	for _, infoFile := range infoFiles {
+		fqn := filepath.Base(filepath.Dir(filepath.Dir(infoFile)))
+		parts := strings.SplitN(fqn, ".", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		version, err := pkgmgr.GetInstalledRoleVersion(parts[0], parts[1])
+		if err != nil || version == "" {
+			logrus.Debugf("skipping %s: %v", infoFile, err)
+			continue
+		}
+		installed[fqn] = version
+	}
+	return installed
+}
+
+// RemoveInstalledCollection deletes an installed collection and its galaxy info dir
+func (pkgmgr *PackageManager) RemoveInstalledCollection(namespace string, name string) error {
+	version, _ := pkgmgr.GetInstalledCollectionVersion(namespace, name)
+	if version != "" {
+		infoDir := filepath.Join(pkgmgr.BasePath, "collections", "ansible_collections", fmt.Sprintf("%s.%s-%s.info", namespace, name, version))
+		if err := os.RemoveAll(infoDir); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(pkgmgr.GetInstalledCollectionPath(namespace, name))
+}
+
+// RemoveInstalledRole deletes an installed role
+func (pkgmgr *PackageManager) RemoveInstalledRole(namespace string, name string) error {
+	return os.RemoveAll(pkgmgr.GetInstalledRolePath(namespace, name))
+}
+
 func (pkgmgr *PackageManager) InstalCollectionFromPath(namespace string, name string, version string, fn string) error {
 
 	cPath := filepath.Join(pkgmgr.BasePath, "collections", "ansible_collections")
 	cPath, _ = utils.GetAbsPath(cPath)
-	utils.MakeDirs(cPath)
+	if err := utils.MakeDirs(cPath); err != nil {
+		return err
+	}
 
 	// Basepath / collections / ansible_collections / namespace / name / ...
 	dirPath := filepath.Join(pkgmgr.BasePath, "collections", "ansible_collections", namespace, name)
 	dirPath, _ = utils.GetAbsPath(dirPath)
-	fmt.Printf("\t%s\n", dirPath)
-	utils.MakeDirs(dirPath)
-	utils.ExtractTarGz(fn, dirPath)
+	logrus.Debugf("\t%s", dirPath)
+	if err := utils.MakeDirs(dirPath); err != nil {
+		return err
+	}
+	if err := utils.ExtractTarGz(fn, dirPath); err != nil {
+		return fmt.Errorf("error extracting %s: %w", fn, err)
+	}
 
 	// Basepath / collections / ansible_collections / <namespace>.<name>-<version>.info / GALAXY.yml
 	ymlDirName := fmt.Sprintf("%s.%s-%s.info", namespace, name, version)
 	ymlDirPath := filepath.Join(pkgmgr.BasePath, "collections", "ansible_collections", ymlDirName)
 	ymlDirPath, _ = utils.GetAbsPath(ymlDirPath)
 	ymlFileName := filepath.Join(ymlDirPath, "GALAXY.tml")
-	//fmt.Printf("MAKEDIR %s\n", ymlDirPath)
-	utils.MakeDirs(ymlDirPath)
+	//fmt.Printf("MAKEDIR %s", ymlDirPath)
+	if err := utils.MakeDirs(ymlDirPath); err != nil {
+		return err
+	}
 
 	galaxyYAML := GalaxyYamlMeta{
 		Namespace:     namespace,
@@ -123,20 +307,20 @@ func (pkgmgr *PackageManager) InstalCollectionFromPath(namespace string, name st
 	// Marshal the struct to JSON
 	jsonData, err := json.MarshalIndent(galaxyYAML, "", "  ")
 	if err != nil {
-		fmt.Printf("Error marshaling JSON: %v\n", err)
+		logrus.Errorf("Error marshaling JSON: %v", err)
 		return err
 	}
 
 	// Write the JSON data to a file
 	file, err := os.Create(ymlFileName)
 	if err != nil {
-		fmt.Printf("Error creating yml file: %v\n", err)
+		logrus.Errorf("Error creating yml file: %v", err)
 		return err
 	}
 	defer file.Close()
 
 	if _, err := file.Write(jsonData); err != nil {
-		fmt.Printf("Error writing to yml file: %v\n", err)
+		logrus.Errorf("Error writing to yml file: %v", err)
 		return err
 	}
 
@@ -152,17 +336,20 @@ func (pkgmgr *PackageManager) InstallRoleFromPath(namespace string, name string,
 
 	rPath := filepath.Join(pkgmgr.BasePath, "roles")
 	rPath, _ = utils.GetAbsPath(rPath)
-	utils.MakeDirs(rPath)
+	if err := utils.MakeDirs(rPath); err != nil {
+		return err
+	}
 
 	// Basepath / collections / ansible_collections / namespace / name / ...
 	dirPath := filepath.Join(rPath, namespace+"."+name)
 	logrus.Debugf("package manager using %s dir", dirPath)
-	utils.MakeDirs(dirPath)
+	if err := utils.MakeDirs(dirPath); err != nil {
+		return err
+	}
 	//logrus.Debugf("extracting %s to %s", fn, dirPath)
 	err := utils.ExtractRoleTarGz(fn, dirPath)
 	if err != nil {
-		logrus.Errorf("error extracting file: %s", err)
-		panic("")
+		return fmt.Errorf("error extracting %s: %w", fn, err)
 	}
 
 	currentTime := time.Now()
@@ -191,19 +378,29 @@ func (pkgmgr *PackageManager) InstallRoleFromPath(namespace string, name string,
 }
 
 func GetPackageManager(cachepath string, basepath string) (PackageManager, error) {
+	return newPackageManager(cachepath, basepath, false)
+}
+
+// GetReadOnlyPackageManager is a package manager for a --dry-run, it creates and cleans up nothing in the cache
+func GetReadOnlyPackageManager(cachepath string, basepath string) (PackageManager, error) {
+	return newPackageManager(cachepath, basepath, true)
+}
+
+func newPackageManager(cachepath string, basepath string, readOnly bool) (PackageManager, error) {
 
-	fmt.Printf("GETPKGMGR: cache:%s basepath:%s\n", cachepath, basepath)
+	logrus.Debugf("GETPKGMGR: cache:%s basepath:%s", cachepath, basepath)
 
 	pkgmgr := PackageManager{
 		BasePath:  basepath,
 		CachePath: cachepath,
+		ReadOnly:  readOnly,
 	}
 	pkgmgr.BasePath = basepath
 	pkgmgr.CachePath = cachepath
-	fmt.Printf("created pkgmgr: %s\n", pkgmgr)
+	logrus.Debugf("created pkgmgr: %v", pkgmgr)
 	err := pkgmgr.Initialize()
 	if err != nil {
-		fmt.Printf("%s\n", err)
+		logrus.Debugf("%s", err)
 		return pkgmgr, err
 	}
 
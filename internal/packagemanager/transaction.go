@@ -0,0 +1,396 @@
+package packagemanager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/blang/semver/v4"
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/repository"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+type TransactionAction string
+
+const (
+	ActionInstall   TransactionAction = "install"
+	ActionUpgrade   TransactionAction = "upgrade"
+	ActionDowngrade TransactionAction = "downgrade"
+	ActionReinstall TransactionAction = "reinstall"
+	ActionSatisfied TransactionAction = "satisfied"
+)
+
+// TransactionItem is a single resolved spec and what will happen to it
+type TransactionItem struct {
+	Spec             utils.InstallSpec
+	Action           TransactionAction
+	InstalledVersion string
+	LatestVersion    string
+	Size             int64
+	Sha256           string
+}
+
+// Transaction is the full set of changes an install will make to the dest dir
+type Transaction struct {
+	Kind  string
+	Dest  string
+	Items []TransactionItem
+}
+
+type transactionItemJSON struct {
+	Namespace        string `json:"namespace"`
+	Name             string `json:"name"`
+	Version          string `json:"version"`
+	Action           string `json:"action"`
+	InstalledVersion string `json:"installed_version,omitempty"`
+	NewerVersion     string `json:"newer_version,omitempty"`
+	Size             int64  `json:"size"`
+}
+
+type transactionJSON struct {
+	Kind         string                `json:"kind"`
+	Dest         string                `json:"dest"`
+	Items        []transactionItemJSON `json:"items"`
+	DownloadSize int64                 `json:"download_size"`
+}
+
+// PlanCollectionTransaction compares resolved specs with the installed collections
+func (pkgmgr *PackageManager) PlanCollectionTransaction(specs []utils.InstallSpec, manifests []repository.CollectionManifest) Transaction {
+	artifacts := collectionArtifacts(manifests)
+	txn := Transaction{Kind: "collection", Dest: pkgmgr.BasePath}
+	for _, spec := range specs {
+		installed, err := pkgmgr.GetInstalledCollectionVersion(spec.Namespace, spec.Name)
+		present := utils.IsDir(pkgmgr.GetInstalledCollectionPath(spec.Namespace, spec.Name))
+		txn.Items = append(txn.Items, TransactionItem{
+			Spec:             spec,
+			Action:           planAction(spec.Version, installed, present, err),
+			InstalledVersion: installed,
+			LatestVersion:    repository.LatestCollectionVersion(spec.Namespace, spec.Name, &manifests),
+			Size:             artifacts[specKey(spec)].Size,
+			Sha256:           artifacts[specKey(spec)].Sha256,
+		})
+	}
+	return txn
+}
+
+// PlanRoleTransaction compares resolved specs with the installed roles
+func (pkgmgr *PackageManager) PlanRoleTransaction(specs []utils.InstallSpec, manifests []types.RoleMeta) Transaction {
+	artifacts := roleArtifacts(manifests)
+	txn := Transaction{Kind: "role", Dest: pkgmgr.BasePath}
+	for _, spec := range specs {
+		installed, err := pkgmgr.GetInstalledRoleVersion(spec.Namespace, spec.Name)
+		present := utils.IsDir(pkgmgr.GetInstalledRolePath(spec.Namespace, spec.Name))
+		txn.Items = append(txn.Items, TransactionItem{
+			Spec:             spec,
+			Action:           planAction(spec.Version, installed, present, err),
+			InstalledVersion: installed,
+			LatestVersion:    repository.LatestRoleVersion(spec.Namespace, spec.Name, &manifests),
+			Size:             artifacts[specKey(spec)].Size,
+			Sha256:           artifacts[specKey(spec)].Sha256,
+		})
+	}
+	return txn
+}
+
+/*
+Decide what to do with a resolved version given what is on disk. A
+directory without readable version info is replaced.
+*/
+func planAction(version string, installed string, present bool, err error) TransactionAction {
+	if err != nil || (installed == "" && present) {
+		return ActionReinstall
+	}
+	if installed == "" {
+		return ActionInstall
+	}
+	if installed == version {
+		return ActionSatisfied
+	}
+
+	v1, err1 := semver.Parse(installed)
+	v2, err2 := semver.Parse(version)
+	if err1 != nil || err2 != nil {
+		return ActionReinstall
+	}
+	if v1.LT(v2) {
+		return ActionUpgrade
+	}
+	if v1.GT(v2) {
+		return ActionDowngrade
+	}
+	return ActionSatisfied
+}
+
+// collectionArtifacts maps each collection version in the index to its artifact info
+func collectionArtifacts(manifests []repository.CollectionManifest) map[string]types.ArtifactInfo {
+	artifacts := map[string]types.ArtifactInfo{}
+	for _, m := range manifests {
+		ci := m.CollectionInfo
+		artifacts[specKey(utils.InstallSpec{Namespace: ci.Namespace, Name: ci.Name, Version: ci.Version})] = m.Artifact
+	}
+	return artifacts
+}
+
+// roleArtifacts maps each role version in the index to its artifact info
+func roleArtifacts(manifests []types.RoleMeta) map[string]types.ArtifactInfo {
+	artifacts := map[string]types.ArtifactInfo{}
+	for _, m := range manifests {
+		gi := m.GalaxyInfo
+		artifacts[specKey(utils.InstallSpec{Namespace: gi.Namespace, Name: gi.RoleName, Version: gi.Version})] = m.Artifact
+	}
+	return artifacts
+}
+
+func specKey(spec utils.InstallSpec) string {
+	return fmt.Sprintf("%s.%s==%s", spec.Namespace, spec.Name, spec.Version)
+}
+
+// NewerVersion returns the latest version in the repo if it is newer than
+// the version being kept for a satisfied item
+func (item *TransactionItem) NewerVersion() string {
+	if item.Action != ActionSatisfied || item.LatestVersion == "" {
+		return ""
+	}
+	v1, err1 := semver.Parse(item.Spec.Version)
+	v2, err2 := semver.Parse(item.LatestVersion)
+	if err1 != nil || err2 != nil || !v2.GT(v1) {
+		return ""
+	}
+	return item.LatestVersion
+}
+
+/*
+Force a reinstall of namespace.name even if it is satisfied. An empty
+namespace and name forces every item in the transaction.
+*/
+func (txn *Transaction) ForceReinstall(namespace string, name string) {
+	for ix, item := range txn.Items {
+		if item.Action != ActionSatisfied {
+			continue
+		}
+		if namespace != "" && (item.Spec.Namespace != namespace || item.Spec.Name != name) {
+			continue
+		}
+		txn.Items[ix].Action = ActionReinstall
+	}
+}
+
+// Pending returns the items that require an artifact to be extracted
+func (txn *Transaction) Pending() []TransactionItem {
+	pending := []TransactionItem{}
+	for _, item := range txn.Items {
+		if item.Action != ActionSatisfied {
+			pending = append(pending, item)
+		}
+	}
+	return pending
+}
+
+// DownloadSize is the total size of the artifacts for the pending items
+func (txn *Transaction) DownloadSize() int64 {
+	var total int64
+	for _, item := range txn.Pending() {
+		total += item.Size
+	}
+	return total
+}
+
+// Print writes a human readable summary of the transaction
+func (txn *Transaction) Print(w io.Writer) {
+	fmt.Fprintf(w, "%s transaction for %s\n", txn.Kind, txn.Dest)
+	for _, item := range txn.Items {
+		fqn := fmt.Sprintf("%s.%s", item.Spec.Namespace, item.Spec.Name)
+		switch item.Action {
+		case ActionUpgrade, ActionDowngrade:
+			fmt.Fprintf(w, "  %-10s %s %s -> %s", item.Action, fqn, item.InstalledVersion, item.Spec.Version)
+		default:
+			fmt.Fprintf(w, "  %-10s %s==%s", item.Action, fqn, item.Spec.Version)
+		}
+		if item.Action != ActionSatisfied && item.Size > 0 {
+			fmt.Fprintf(w, " (%s)", utils.HumanSize(item.Size))
+		}
+		if newer := item.NewerVersion(); newer != "" {
+			fmt.Fprintf(w, " (%s is available)", newer)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%d to change, %d already satisfied\n", len(txn.Pending()), len(txn.Items)-len(txn.Pending()))
+	fmt.Fprintf(w, "download size: %s\n", utils.HumanSize(txn.DownloadSize()))
+}
+
+// WriteJSON writes the transaction in a machine readable form
+func (txn *Transaction) WriteJSON(w io.Writer) error {
+	out := transactionJSON{
+		Kind:         txn.Kind,
+		Dest:         txn.Dest,
+		Items:        []transactionItemJSON{},
+		DownloadSize: txn.DownloadSize(),
+	}
+	for _, item := range txn.Items {
+		out.Items = append(out.Items, transactionItemJSON{
+			Namespace:        item.Spec.Namespace,
+			Name:             item.Spec.Name,
+			Version:          item.Spec.Version,
+			Action:           string(item.Action),
+			InstalledVersion: item.InstalledVersion,
+			NewerVersion:     item.NewerVersion(),
+			Size:             item.Size,
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+/*
+Apply the pending items of a transaction in two phases. Every artifact is
+downloaded and verified first, concurrently, so a failed download aborts
+before anything is written to the dest dir. Then each item is extracted;
+anything already on disk for an upgrade, downgrade or reinstall is removed
+before the new version is extracted so no stale files are left behind.
+*/
+func (pkgmgr *PackageManager) ApplyTransaction(txn *Transaction, repoClient repository.RepoClient, concurrency int) error {
+	pending := txn.Pending()
+
+	artifacts, err := pkgmgr.fetchArtifacts(txn, repoClient, concurrency)
+	if err != nil {
+		return err
+	}
+
+	for ix, item := range pending {
+		spec := item.Spec
+		fn := artifacts[ix]
+		logrus.Infof("%s: %s.%s==%s", item.Action, spec.Namespace, spec.Name, spec.Version)
+		logrus.Debugf("install %s from %s", spec, fn)
+
+		if txn.Kind == "role" {
+			if item.Action != ActionInstall {
+				if err := pkgmgr.RemoveInstalledRole(spec.Namespace, spec.Name); err != nil {
+					return err
+				}
+			}
+			if err := pkgmgr.InstallRoleFromPath(spec.Namespace, spec.Name, spec.Version, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if item.Action != ActionInstall {
+			if err := pkgmgr.RemoveInstalledCollection(spec.Namespace, spec.Name); err != nil {
+				return err
+			}
+		}
+		if err := pkgmgr.InstalCollectionFromPath(spec.Namespace, spec.Name, spec.Version, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Download and verify the artifact for every pending item, at most
+concurrency at a time. The paths are returned in the same order as
+txn.Pending(). Once anything fails no new downloads are started and all
+of the failures are returned together.
+*/
+func (pkgmgr *PackageManager) fetchArtifacts(txn *Transaction, repoClient repository.RepoClient, concurrency int) ([]string, error) {
+	pending := txn.Pending()
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	progress := utils.StartProgress(txn.Kind+" downloads", len(pending), txn.DownloadSize())
+	defer progress.Finish()
+
+	artifacts := make([]string, len(pending))
+	errs := make([]error, len(pending))
+	var failed atomic.Bool
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency) // semaphore to limit concurrency
+
+	for ix, item := range pending {
+		wg.Add(1)
+		go func(ix int, item TransactionItem) {
+			defer wg.Done()
+			sem <- struct{}{} // acquire a slot
+			defer func() { <-sem }()
+
+			if failed.Load() {
+				return
+			}
+			artifacts[ix], errs[ix] = pkgmgr.fetchArtifact(txn.Kind, item, repoClient)
+			if errs[ix] != nil {
+				failed.Store(true)
+			}
+		}(ix, item)
+	}
+
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
+func (pkgmgr *PackageManager) fetchArtifact(kind string, item TransactionItem, repoClient repository.RepoClient) (string, error) {
+	var fn string
+	var err error
+	if kind == "role" {
+		fn, err = repoClient.GetCacheRoleFileLocationForInstallSpec(item.Spec)
+	} else {
+		fn, err = repoClient.GetCacheFileLocationForInstallSpec(item.Spec)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := verifyArtifact(fn, item.Sha256); err != nil {
+		// drop a bad download from the cache so the next run fetches it again
+		if strings.HasPrefix(fn, pkgmgr.CachePath+string(filepath.Separator)) {
+			os.Remove(fn)
+		}
+		return "", err
+	}
+	return fn, nil
+}
+
+/*
+Check an artifact against the sha256 recorded in the repo index. Indexes
+made before checksums were recorded have no sha256 and are not checked.
+*/
+func verifyArtifact(fn string, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	actual, _, err := utils.Sha256File(fn)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return laxerrors.New(laxerrors.ErrChecksumMismatch, "%s has sha256 %s but the repo index expects %s", fn, actual, expected)
+	}
+	return nil
+}
+
+// Write renders the transaction in the requested output format
+func (txn *Transaction) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		return txn.WriteJSON(w)
+	case "", "text":
+		txn.Print(w)
+		return nil
+	}
+	return laxerrors.New(laxerrors.ErrUsage, "unknown output format %s", format)
+}
@@ -0,0 +1,324 @@
+package packagemanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/repository"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+)
+
+// fakeRepoClient hands out the artifacts (specKey -> path) or errors it was given and counts concurrent downloads
+type fakeRepoClient struct {
+	artifacts map[string]string
+	errs      map[string]error
+	// every download waits for this many to have started, so none is skipped for an earlier failure
+	barrier *sync.WaitGroup
+	delay   time.Duration
+
+	mu        sync.Mutex
+	active    int
+	maxActive int
+}
+
+func (c *fakeRepoClient) download(spec utils.InstallSpec) (string, error) {
+	c.mu.Lock()
+	c.active++
+	if c.active > c.maxActive {
+		c.maxActive = c.active
+	}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.active--
+		c.mu.Unlock()
+	}()
+
+	if c.barrier != nil {
+		c.barrier.Done()
+		c.barrier.Wait()
+	}
+	time.Sleep(c.delay)
+	if err := c.errs[specKey(spec)]; err != nil {
+		return "", err
+	}
+	return c.artifacts[specKey(spec)], nil
+}
+
+func (c *fakeRepoClient) FetchRepoMeta(cachePath string) error { return nil }
+func (c *fakeRepoClient) GetRepoMetaDate() (string, error)     { return "", nil }
+func (c *fakeRepoClient) ResolveCollectionDeps(spec utils.InstallSpec) ([]utils.InstallSpec, error) {
+	return []utils.InstallSpec{spec}, nil
+}
+func (c *fakeRepoClient) ResolveRoleDeps(spec utils.InstallSpec) ([]utils.InstallSpec, error) {
+	return []utils.InstallSpec{spec}, nil
+}
+func (c *fakeRepoClient) GetCacheFileLocationForInstallSpec(spec utils.InstallSpec) (string, error) {
+	return c.download(spec)
+}
+func (c *fakeRepoClient) GetCacheRoleFileLocationForInstallSpec(spec utils.InstallSpec) (string, error) {
+	return c.download(spec)
+}
+func (c *fakeRepoClient) GetCollectionManifests() ([]repository.CollectionManifest, error) {
+	return nil, nil
+}
+func (c *fakeRepoClient) GetRoleManifests() ([]types.RoleMeta, error) { return nil, nil }
+
+func testItem(name string, sha256 string) TransactionItem {
+	return TransactionItem{Spec: utils.InstallSpec{Namespace: "ns", Name: name, Version: "1.0.0"}, Action: ActionInstall, Sha256: sha256}
+}
+
+func TestApplyTransactionAborts(t *testing.T) {
+	pkgmgr := &PackageManager{BasePath: filepath.Join(t.TempDir(), "dest"), CachePath: t.TempDir()}
+
+	good := filepath.Join(pkgmgr.CachePath, "ns-good-1.0.0.tar.gz")
+	bad := filepath.Join(pkgmgr.CachePath, "ns-bad-1.0.0.tar.gz")
+	os.WriteFile(good, []byte("good"), 0644)
+	os.WriteFile(bad, []byte("tampered"), 0644)
+	goodSum, _, _ := utils.Sha256File(good)
+
+	txn := &Transaction{Kind: "collection", Dest: pkgmgr.BasePath, Items: []TransactionItem{
+		testItem("good", goodSum),
+		testItem("bad", goodSum),
+		testItem("missing", ""),
+	}}
+	downloadErr := laxerrors.New(laxerrors.ErrDownloadFailed, "ns.missing is gone")
+	barrier := &sync.WaitGroup{}
+	barrier.Add(len(txn.Items))
+	client := &fakeRepoClient{
+		artifacts: map[string]string{specKey(txn.Items[0].Spec): good, specKey(txn.Items[1].Spec): bad},
+		errs:      map[string]error{specKey(txn.Items[2].Spec): downloadErr},
+		barrier:   barrier,
+	}
+
+	err := pkgmgr.ApplyTransaction(txn, client, len(txn.Items))
+	if !errors.Is(err, laxerrors.ErrChecksumMismatch) || !errors.Is(err, downloadErr) {
+		t.Fatalf("expected the checksum mismatch and the failed download, got %v", err)
+	}
+	if _, err := os.Stat(pkgmgr.BasePath); err == nil {
+		t.Error("something was extracted into the dest dir")
+	}
+	if utils.FileExists(bad) {
+		t.Error("the bad download was left in the cache")
+	}
+	if !utils.FileExists(good) {
+		t.Error("the good download was removed from the cache")
+	}
+}
+
+func TestFetchArtifactKeepsFilesOutsideTheCache(t *testing.T) {
+	pkgmgr := &PackageManager{CachePath: t.TempDir()}
+	// a file repo hands out its own artifacts, which are not lax's to remove
+	repoFile := filepath.Join(t.TempDir(), "ns-bad-1.0.0.tar.gz")
+	os.WriteFile(repoFile, []byte("tampered"), 0644)
+
+	item := testItem("bad", "0000")
+	client := &fakeRepoClient{artifacts: map[string]string{specKey(item.Spec): repoFile}}
+	if _, err := pkgmgr.fetchArtifact("collection", item, client); !errors.Is(err, laxerrors.ErrChecksumMismatch) {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	if !utils.FileExists(repoFile) {
+		t.Error("a file outside the cache was removed")
+	}
+}
+
+func TestFetchArtifactsConcurrency(t *testing.T) {
+	pkgmgr := &PackageManager{CachePath: t.TempDir()}
+	txn := &Transaction{Kind: "role"}
+	client := &fakeRepoClient{artifacts: map[string]string{}, delay: 20 * time.Millisecond}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		item := testItem(name, "")
+		txn.Items = append(txn.Items, item)
+		client.artifacts[specKey(item.Spec)] = filepath.Join(pkgmgr.CachePath, name+".tar.gz")
+	}
+
+	artifacts, err := pkgmgr.fetchArtifacts(txn, client, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.maxActive != 2 {
+		t.Errorf("expected 2 downloads at a time, got %d", client.maxActive)
+	}
+	for ix, item := range txn.Items {
+		if artifacts[ix] != client.artifacts[specKey(item.Spec)] {
+			t.Errorf("artifact %d is %s, not the one for %s", ix, artifacts[ix], item.Spec.Name)
+		}
+	}
+}
+
+func TestPlanAction(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   string
+		installed string
+		present   bool
+		err       error
+		expected  TransactionAction
+	}{
+		{"not installed", "1.0.0", "", false, nil, ActionInstall},
+		{"older installed", "2.0.0", "1.0.0", true, nil, ActionUpgrade},
+		{"newer installed", "1.0.0", "2.0.0", true, nil, ActionDowngrade},
+		{"same version", "1.0.0", "1.0.0", true, nil, ActionSatisfied},
+		{"only build metadata differs", "1.0.0+build", "1.0.0", true, nil, ActionSatisfied},
+		{"unparsable installed version", "1.0.0", "latest", true, nil, ActionReinstall},
+		{"unparsable new version", "v1", "1.0.0", true, nil, ActionReinstall},
+		{"directory without a version", "1.0.0", "", true, nil, ActionReinstall},
+		{"unreadable version", "1.0.0", "", true, errors.New("bad MANIFEST.json"), ActionReinstall},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := planAction(tt.version, tt.installed, tt.present, tt.err); got != tt.expected {
+				t.Errorf("got %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func testTransaction() Transaction {
+	item := func(name string, action TransactionAction, size int64) TransactionItem {
+		return TransactionItem{Spec: utils.InstallSpec{Namespace: "ns", Name: name, Version: "1.0.0"}, Action: action, Size: size}
+	}
+	return Transaction{Kind: "collection", Dest: "/dest", Items: []TransactionItem{
+		item("new", ActionInstall, 100),
+		item("kept", ActionSatisfied, 1000),
+		item("newer", ActionUpgrade, 10),
+	}}
+}
+
+func TestTransactionPending(t *testing.T) {
+	txn := testTransaction()
+	pending := txn.Pending()
+	if len(pending) != 2 || pending[0].Spec.Name != "new" || pending[1].Spec.Name != "newer" {
+		t.Errorf("unexpected pending items %v", pending)
+	}
+	if size := txn.DownloadSize(); size != 110 {
+		t.Errorf("expected the satisfied item not to be downloaded, got a download size of %d", size)
+	}
+}
+
+func TestTransactionWriteJSON(t *testing.T) {
+	txn := testTransaction()
+	txn.Items[0].InstalledVersion = "0.9.0"
+	var buf bytes.Buffer
+	if err := txn.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var out transactionJSON
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Kind != "collection" || out.Dest != "/dest" || out.DownloadSize != 110 || len(out.Items) != 3 {
+		t.Errorf("unexpected transaction %+v", out)
+	}
+	expected := transactionItemJSON{Namespace: "ns", Name: "new", Version: "1.0.0", Action: "install", InstalledVersion: "0.9.0", Size: 100}
+	if out.Items[0] != expected {
+		t.Errorf("got %+v, want %+v", out.Items[0], expected)
+	}
+
+	if err := txn.Write(&buf, "yaml"); err == nil {
+		t.Error("expected an unknown output format to fail")
+	}
+}
+
+func TestReadOnlyPackageManager(t *testing.T) {
+	repo := t.TempDir()
+	if err := repository.CreateRepo(&types.CmdKwargs{DestDir: repo}); err != nil {
+		t.Fatal(err)
+	}
+	cache := filepath.Join(t.TempDir(), "cache")
+	repoClient, err := repository.GetRepoClient(repo, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkgmgr, err := GetReadOnlyPackageManager(cache, filepath.Join(t.TempDir(), "dest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pkgmgr.SyncRepoMeta(repoClient); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repoClient.GetCollectionManifests(); err != nil {
+		t.Errorf("expected the index to be readable, got %v", err)
+	}
+	tempCache := pkgmgr.tempCache
+	pkgmgr.Close()
+	if _, err := os.Stat(cache); err == nil {
+		t.Error("a dry run created the cache")
+	}
+	if _, err := os.Stat(tempCache); err == nil {
+		t.Error("the index fetched for a dry run was left behind")
+	}
+
+	// a dry run doesn't clean up a cache either
+	os.MkdirAll(cache, 0755)
+	stale := filepath.Join(cache, "ns-old-1.0.0.tar.gz"+utils.PartSuffix)
+	os.WriteFile(stale, nil, 0644)
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(stale, old, old)
+	if _, err := GetReadOnlyPackageManager(cache, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if !utils.FileExists(stale) {
+		t.Error("a dry run removed a partial download")
+	}
+}
+
+func TestForceReinstall(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		fqn       string
+		expected  []TransactionAction
+	}{
+		{"the requested one", "ns", "kept", []TransactionAction{ActionInstall, ActionReinstall, ActionUpgrade}},
+		{"one that isn't satisfied", "ns", "newer", []TransactionAction{ActionInstall, ActionSatisfied, ActionUpgrade}},
+		{"one that isn't in the transaction", "other", "kept", []TransactionAction{ActionInstall, ActionSatisfied, ActionUpgrade}},
+		{"everything", "", "", []TransactionAction{ActionInstall, ActionReinstall, ActionUpgrade}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txn := testTransaction()
+			txn.ForceReinstall(tt.namespace, tt.fqn)
+			for ix, item := range txn.Items {
+				if item.Action != tt.expected[ix] {
+					t.Errorf("%s: got %s, want %s", item.Spec.Name, item.Action, tt.expected[ix])
+				}
+			}
+		})
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		action   TransactionAction
+		latest   string
+		expected string
+	}{
+		{"kept with a newer one", ActionSatisfied, "2.0.0", "2.0.0"},
+		{"kept and latest", ActionSatisfied, "1.0.0", ""},
+		{"kept with an unparsable latest", ActionSatisfied, "latest", ""},
+		{"installed", ActionInstall, "2.0.0", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := TransactionItem{Spec: utils.InstallSpec{Namespace: "ns", Name: "kept", Version: "1.0.0"}, Action: tt.action, LatestVersion: tt.latest}
+			if got := item.NewerVersion(); got != tt.expected {
+				t.Errorf("got %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
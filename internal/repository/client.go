@@ -8,10 +8,14 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
+	"github.com/jctanner/lax/internal/httpclient"
+	"github.com/jctanner/lax/internal/laxerrors"
 	"github.com/jctanner/lax/internal/types"
 	"github.com/jctanner/lax/internal/utils"
 	"github.com/sirupsen/logrus"
@@ -26,8 +30,10 @@ type RepoClient interface {
 	GetRepoMetaDate() (string, error)
 	ResolveCollectionDeps(spec utils.InstallSpec) ([]utils.InstallSpec, error)
 	ResolveRoleDeps(spec utils.InstallSpec) ([]utils.InstallSpec, error)
-	GetCacheFileLocationForInstallSpec(spec utils.InstallSpec) string
-	GetCacheRoleFileLocationForInstallSpec(spec utils.InstallSpec) string
+	GetCacheFileLocationForInstallSpec(spec utils.InstallSpec) (string, error)
+	GetCacheRoleFileLocationForInstallSpec(spec utils.InstallSpec) (string, error)
+	GetCollectionManifests() ([]CollectionManifest, error)
+	GetRoleManifests() ([]types.RoleMeta, error)
 }
 
 type FileRepoClient struct {
@@ -39,6 +45,8 @@ type FileRepoClient struct {
 	CollectionFiles     RepoMetaFile
 	RoleManifests       RepoMetaFile
 	RoleFiles           RepoMetaFile
+
+	artifacts artifactLocations
 }
 
 type HttpRepoClient struct {
@@ -50,6 +58,8 @@ type HttpRepoClient struct {
 	CollectionFiles     RepoMetaFile
 	RoleManifests       RepoMetaFile
 	RoleFiles           RepoMetaFile
+
+	artifacts artifactLocations
 }
 
 func (client *FileRepoClient) InitCache(cachePath string) error {
@@ -61,8 +71,9 @@ func (client *FileRepoClient) InitCache(cachePath string) error {
 func (client *FileRepoClient) FetchRepoMeta(cachePath string) error {
 
 	client.CachePath = cachePath
+	client.artifacts.reset()
 
-	fmt.Printf("fetching repometa from %s\n", client.BasePath)
+	logrus.Debugf("fetching repometa from %s", client.BasePath)
 
 	// Construct the full path to the repometa.json file
 	filePath := filepath.Join(client.BasePath, "repometa.json")
@@ -70,7 +81,7 @@ func (client *FileRepoClient) FetchRepoMeta(cachePath string) error {
 	// Read the file
 	fileData, err := os.ReadFile(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		return laxerrors.Wrap(laxerrors.ErrNotFound, err, "%s is not a lax repo", client.BasePath)
 	}
 
 	// Parse the JSON data
@@ -78,7 +89,7 @@ func (client *FileRepoClient) FetchRepoMeta(cachePath string) error {
 	if err := json.Unmarshal(fileData, &repoMeta); err != nil {
 		return fmt.Errorf("failed to unmarshal JSON: %w", err)
 	}
-	fmt.Printf("repometa: %s\n", repoMeta)
+	logrus.Debugf("repometa: %v", repoMeta)
 	client.CollectionManifests = repoMeta.CollectionManifests
 	client.CollectionFiles = repoMeta.CollectionFiles
 	client.RoleManifests = repoMeta.RoleManifests
@@ -91,33 +102,33 @@ func (client *FileRepoClient) FetchRepoMeta(cachePath string) error {
 	// copy repometa.json
 	src := filepath.Join(client.BasePath, "repometa.json")
 	dst := filepath.Join(cachePath, "repometa.json")
-	utils.MakeDirs(cachePath)
-	fmt.Printf("repometa: %s -> %s\n", src, dst)
-	utils.CopyFile(src, dst)
-
-	// copy the collection manifests
-	src = filepath.Join(client.BasePath, client.CollectionManifests.Filename)
-	dst = filepath.Join(cachePath, client.CollectionManifests.Filename)
-	fmt.Printf("manifests: %s -> %s\n", src, dst)
-	utils.CopyFile(src, dst)
-
-	// copy the collection files
-	src = filepath.Join(client.BasePath, client.CollectionFiles.Filename)
-	dst = filepath.Join(cachePath, client.CollectionFiles.Filename)
-	fmt.Printf("files: %s -> %s\n", src, dst)
-	utils.CopyFile(src, dst)
-
-	// copy the role manifests
-	src = filepath.Join(client.BasePath, client.RoleManifests.Filename)
-	dst = filepath.Join(cachePath, client.RoleManifests.Filename)
-	fmt.Printf("manifests: %s -> %s\n", src, dst)
-	utils.CopyFile(src, dst)
-
-	// copy the role files
-	src = filepath.Join(client.BasePath, client.RoleFiles.Filename)
-	dst = filepath.Join(cachePath, client.RoleFiles.Filename)
-	fmt.Printf("files: %s -> %s\n", src, dst)
-	utils.CopyFile(src, dst)
+	if err := utils.MakeDirs(cachePath); err != nil {
+		return err
+	}
+	logrus.Debugf("repometa: %s -> %s", src, dst)
+	if err := utils.CopyFile(src, dst); err != nil {
+		return err
+	}
+
+	// copy the index files, a repo without roles or collections won't have all of them
+	indexFiles := []string{
+		client.CollectionManifests.Filename,
+		client.CollectionFiles.Filename,
+		client.RoleManifests.Filename,
+		client.RoleFiles.Filename,
+	}
+	for _, fn := range indexFiles {
+		src = filepath.Join(client.BasePath, fn)
+		dst = filepath.Join(cachePath, fn)
+		if fn == "" || !utils.IsFile(src) {
+			logrus.Debugf("skipping missing index file %s", src)
+			continue
+		}
+		logrus.Debugf("index: %s -> %s", src, dst)
+		if err := utils.CopyFile(src, dst); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -129,7 +140,7 @@ func (client *FileRepoClient) GetRepoMetaDate() (string, error) {
 	// Read the file
 	fileData, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to read file: %w", err)
+		return "", laxerrors.Wrap(laxerrors.ErrNotFound, err, "%s is not a lax repo", client.BasePath)
 	}
 
 	// Parse the JSON data
@@ -137,9 +148,11 @@ func (client *FileRepoClient) GetRepoMetaDate() (string, error) {
 	if err := json.Unmarshal(fileData, &repoMeta); err != nil {
 		return "", fmt.Errorf("failed to unmarshal JSON: %w", err)
 	}
-	fmt.Printf("repometa: %s\n", repoMeta)
+	logrus.Debugf("repometa: %v", repoMeta)
 	client.CollectionManifests = repoMeta.CollectionManifests
 	client.CollectionFiles = repoMeta.CollectionFiles
+	client.RoleManifests = repoMeta.RoleManifests
+	client.RoleFiles = repoMeta.RoleFiles
 
 	client.RepoMeta = RepoMetaFile{
 		Filename: filePath,
@@ -149,26 +162,47 @@ func (client *FileRepoClient) GetRepoMetaDate() (string, error) {
 	return client.RepoMeta.Date, nil
 }
 
-func (client *FileRepoClient) GetCacheFileLocationForInstallSpec(spec utils.InstallSpec) string {
-	tarName := fmt.Sprintf("%s-%s-%s.tar.gz", spec.Namespace, spec.Name, spec.Version)
-	fileName := filepath.Join(client.BasePath, "collections", tarName)
-	return fileName
+func (client *FileRepoClient) GetCacheFileLocationForInstallSpec(spec utils.InstallSpec) (string, error) {
+	artifact := client.artifacts.artifact(client, "collection", spec)
+	fileName := filepath.Join(client.BasePath, filepath.FromSlash(artifact.Path))
+	if !utils.IsFile(fileName) {
+		return "", laxerrors.New(laxerrors.ErrNotFound, "%s does not exist", fileName)
+	}
+	return fileName, nil
 }
 
-func (client *FileRepoClient) GetCacheRoleFileLocationForInstallSpec(spec utils.InstallSpec) string {
-	tarName := fmt.Sprintf("%s-%s-%s.tar.gz", spec.Namespace, spec.Name, spec.Version)
-	fileName := filepath.Join(client.BasePath, "roles", tarName)
-	return fileName
+func (client *FileRepoClient) GetCacheRoleFileLocationForInstallSpec(spec utils.InstallSpec) (string, error) {
+	artifact := client.artifacts.artifact(client, "role", spec)
+	fileName := filepath.Join(client.BasePath, filepath.FromSlash(artifact.Path))
+	if !utils.IsFile(fileName) {
+		return "", laxerrors.New(laxerrors.ErrNotFound, "%s does not exist", fileName)
+	}
+	return fileName, nil
+}
+
+func (client *FileRepoClient) GetCollectionManifests() ([]CollectionManifest, error) {
+	collectionsManifestsFile := filepath.Join(client.BasePath, client.CollectionManifests.Filename)
+	logrus.Debugf("reading %s", collectionsManifestsFile)
+	return ExtractCollectionManifestsFromTarGz(collectionsManifestsFile)
+}
+
+func (client *FileRepoClient) GetRoleManifests() ([]types.RoleMeta, error) {
+	rolesManifestsFile := filepath.Join(client.BasePath, client.RoleManifests.Filename)
+	logrus.Debugf("reading %s", rolesManifestsFile)
+	return ExtractRoleManifestsFromTarGz(rolesManifestsFile)
 }
 
 func (client *FileRepoClient) ResolveCollectionDeps(spec utils.InstallSpec) ([]utils.InstallSpec, error) {
 
 	// load the collections manifests
-	collectionsManifestsFile := filepath.Join(client.BasePath, client.CollectionManifests.Filename)
-	fmt.Printf("reading %s\n", collectionsManifestsFile)
-	manifests, _ := ExtractCollectionManifestsFromTarGz(collectionsManifestsFile)
+	manifests, err := client.GetCollectionManifests()
+	if err != nil {
+		return nil, err
+	}
 	specs := []utils.InstallSpec{}
-	resolveCollectionDeps(spec, &manifests, &specs)
+	if _, err := resolveCollectionDeps(spec, &manifests, &specs, nil, nil); err != nil {
+		return nil, err
+	}
 
 	return specs, nil
 }
@@ -176,14 +210,16 @@ func (client *FileRepoClient) ResolveCollectionDeps(spec utils.InstallSpec) ([]u
 func (client *FileRepoClient) ResolveRoleDeps(spec utils.InstallSpec) ([]utils.InstallSpec, error) {
 
 	// load the collections manifests
-	fmt.Printf("assembling full path to: %s\n", client.RoleManifests.Filename)
-	rolesManifestsFile := filepath.Join(client.BasePath, client.RoleManifests.Filename)
-	fmt.Printf("reading %s\n", rolesManifestsFile)
-	manifests, _ := ExtractRoleManifestsFromTarGz(rolesManifestsFile)
-	//fmt.Printf("all-manifests: %s\n", manifests)
+	manifests, err := client.GetRoleManifests()
+	if err != nil {
+		return nil, err
+	}
+	//fmt.Printf("all-manifests: %s", manifests)
 	//panic("")
 	specs := []utils.InstallSpec{}
-	resolveRoleDeps(spec, &manifests, &specs)
+	if err := resolveRoleDeps(spec, &manifests, &specs, nil); err != nil {
+		return nil, err
+	}
 
 	return specs, nil
 }
@@ -192,34 +228,35 @@ func (client *HttpRepoClient) InitCache(cachePath string) error {
 	return nil
 }
 func (client *HttpRepoClient) FetchRepoMeta(cachePath string) error {
-	fmt.Printf("fetching repometa from %s\n", client.BaseURL)
+	logrus.Debugf("fetching repometa from %s", client.BaseURL)
 
 	client.CachePath = cachePath
+	client.artifacts.reset()
 
 	// Construct the full url to the repometa.json file
 	metaUrl := client.BaseURL + "/" + "repometa.json"
 	cachedMetaFile := filepath.Join(client.CachePath, "repometa.json")
-	fmt.Printf("rm: %s -> %s\n", metaUrl, cachedMetaFile)
-	err := DownloadFile(metaUrl, cachedMetaFile)
+	logrus.Debugf("rm: %s -> %s", metaUrl, cachedMetaFile)
+	err := DownloadFile(metaUrl, cachedMetaFile, utils.DownloadOptions{NoResume: true})
 	if err != nil {
-		fmt.Printf("ERROR: %s\n", err)
+		logrus.Errorf("%s", err)
 		return fmt.Errorf("failed to download file: %w", err)
 	}
 
 	// Read the file
 	fileData, err := ioutil.ReadFile(cachedMetaFile)
 	if err != nil {
-		fmt.Printf("ERROR: %s\n", err)
+		logrus.Errorf("%s", err)
 		return fmt.Errorf("failed to read file: %w", err)
 	}
 
 	// Parse the JSON data
 	var repoMeta RepoMeta
 	if err := json.Unmarshal(fileData, &repoMeta); err != nil {
-		fmt.Printf("ERROR: %s\n", err)
+		logrus.Errorf("%s", err)
 		return fmt.Errorf("failed to unmarshal JSON: %w", err)
 	}
-	fmt.Printf("repometa: %s\n", repoMeta)
+	logrus.Debugf("repometa: %v", repoMeta)
 	client.CollectionManifests = repoMeta.CollectionManifests
 	client.CollectionFiles = repoMeta.CollectionFiles
 	client.RoleManifests = repoMeta.RoleManifests
@@ -227,15 +264,15 @@ func (client *HttpRepoClient) FetchRepoMeta(cachePath string) error {
 
 	//filesToGet := []string{client.CollectionManifests.Filename, client.CollectionFiles.Filename}
 	filesToGet := []string{client.CollectionManifests.Filename, client.RoleManifests.Filename}
-	fmt.Printf("%s\n", filesToGet)
+	logrus.Debugf("%s", filesToGet)
 
 	for _, fn := range filesToGet {
 		localFile := filepath.Join(client.CachePath, fn)
 		url := client.BaseURL + "/" + fn
-		fmt.Printf("rm: %s -> %s\n", url, localFile)
-		err := DownloadFile(url, localFile)
+		logrus.Debugf("rm: %s -> %s", url, localFile)
+		err := DownloadFile(url, localFile, utils.DownloadOptions{NoResume: true})
 		if err != nil {
-			fmt.Printf("ERROR: %s\n", err)
+			logrus.Errorf("%s", err)
 			return fmt.Errorf("failed to download file: %w", err)
 		}
 	}
@@ -244,57 +281,108 @@ func (client *HttpRepoClient) FetchRepoMeta(cachePath string) error {
 }
 
 func (client *HttpRepoClient) GetRepoMetaDate() (string, error) {
+
+	// read the remote repometa.json without touching the cache
+	metaUrl := client.BaseURL + "/" + "repometa.json"
+	resp, err := httpclient.Get(metaUrl)
+	if err != nil {
+		return "", laxerrors.Wrap(laxerrors.ErrDownloadFailed, err, "get %s", metaUrl)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", laxerrors.FromHTTPStatus(metaUrl, resp)
+	}
+
+	fileData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read body: %w", err)
+	}
+
+	var repoMeta RepoMeta
+	if err := json.Unmarshal(fileData, &repoMeta); err != nil {
+		return "", fmt.Errorf("failed to unmarshal JSON: %w", err)
+	}
+	client.CollectionManifests = repoMeta.CollectionManifests
+	client.CollectionFiles = repoMeta.CollectionFiles
+	client.RoleManifests = repoMeta.RoleManifests
+	client.RoleFiles = repoMeta.RoleFiles
+	client.RepoMeta = RepoMetaFile{
+		Filename: metaUrl,
+		Date:     repoMeta.Date,
+	}
+
 	return client.RepoMeta.Date, nil
 }
 
-func (client *HttpRepoClient) GetCacheFileLocationForInstallSpec(spec utils.InstallSpec) string {
-	//fmt.Printf("ERROR: GetCacheFileLocationForInstallSpec NOT YET IMPLEMENTED\n")
+func (client *HttpRepoClient) GetCacheFileLocationForInstallSpec(spec utils.InstallSpec) (string, error) {
 	cDir := filepath.Join(client.CachePath, "collections")
 	utils.MakeDirs(cDir)
 
-	tarName := fmt.Sprintf("%s-%s-%s.tar.gz", spec.Namespace, spec.Name, spec.Version)
+	// the cache stays flat whatever the repo's layout is
+	artifact := client.artifacts.artifact(client, "collection", spec)
+	tarName := path.Base(artifact.Path)
 	logrus.Infof("found: %s", tarName)
 
 	cFile := filepath.Join(cDir, tarName)
-	if utils.FileExists(cFile) {
-		return cFile
+	if cachedArtifactMatches(cFile, artifact) {
+		return cFile, nil
 	}
 
 	// download it ...
-	url := client.BaseURL + "/collections/" + tarName
-	DownloadFile(url, cFile)
+	url := client.BaseURL + "/" + artifact.Path
+	if err := DownloadFile(url, cFile, artifactDownloadOptions(artifact)); err != nil {
+		return "", err
+	}
 
-	return cFile
+	return cFile, nil
 }
 
-func (client *HttpRepoClient) GetCacheRoleFileLocationForInstallSpec(spec utils.InstallSpec) string {
-	//fmt.Printf("ERROR: GetCacheFileLocationForInstallSpec NOT YET IMPLEMENTED\n")
+func (client *HttpRepoClient) GetCacheRoleFileLocationForInstallSpec(spec utils.InstallSpec) (string, error) {
 	rDir := filepath.Join(client.CachePath, "roles")
 	utils.MakeDirs(rDir)
 
-	tarName := fmt.Sprintf("%s-%s-%s.tar.gz", spec.Namespace, spec.Name, spec.Version)
-	logrus.Infof("found %s/roles/%s", client.BaseURL, tarName)
+	artifact := client.artifacts.artifact(client, "role", spec)
+	tarName := path.Base(artifact.Path)
+	logrus.Infof("found %s/%s", client.BaseURL, artifact.Path)
 
 	rFile := filepath.Join(rDir, tarName)
-	if utils.FileExists(rFile) {
-		return rFile
+	if cachedArtifactMatches(rFile, artifact) {
+		return rFile, nil
 	}
 
 	// download it ...
-	url := client.BaseURL + "/roles/" + tarName
+	url := client.BaseURL + "/" + artifact.Path
 	logrus.Infof("download %s to %s", url, rFile)
-	DownloadFile(url, rFile)
+	if err := DownloadFile(url, rFile, artifactDownloadOptions(artifact)); err != nil {
+		return "", err
+	}
 
-	return rFile
+	return rFile, nil
+}
+
+func (client *HttpRepoClient) GetCollectionManifests() ([]CollectionManifest, error) {
+	collectionsManifestsFile := filepath.Join(client.CachePath, client.CollectionManifests.Filename)
+	logrus.Debugf("reading %s", collectionsManifestsFile)
+	return ExtractCollectionManifestsFromTarGz(collectionsManifestsFile)
+}
+
+func (client *HttpRepoClient) GetRoleManifests() ([]types.RoleMeta, error) {
+	rolesManifestsFile := filepath.Join(client.CachePath, client.RoleManifests.Filename)
+	logrus.Debugf("reading %s", rolesManifestsFile)
+	return ExtractRoleManifestsFromTarGz(rolesManifestsFile)
 }
 
 func (client *HttpRepoClient) ResolveCollectionDeps(spec utils.InstallSpec) ([]utils.InstallSpec, error) {
 	// load the collections manifests
-	collectionsManifestsFile := filepath.Join(client.CachePath, client.CollectionManifests.Filename)
-	fmt.Printf("reading %s\n", collectionsManifestsFile)
-	manifests, _ := ExtractCollectionManifestsFromTarGz(collectionsManifestsFile)
+	manifests, err := client.GetCollectionManifests()
+	if err != nil {
+		return nil, err
+	}
 	specs := []utils.InstallSpec{}
-	resolveCollectionDeps(spec, &manifests, &specs)
+	if _, err := resolveCollectionDeps(spec, &manifests, &specs, nil, nil); err != nil {
+		return nil, err
+	}
 
 	return specs, nil
 }
@@ -302,71 +390,107 @@ func (client *HttpRepoClient) ResolveCollectionDeps(spec utils.InstallSpec) ([]u
 func (client *HttpRepoClient) ResolveRoleDeps(spec utils.InstallSpec) ([]utils.InstallSpec, error) {
 
 	// load the collections manifests
-	rolesManifestsFile := filepath.Join(client.CachePath, client.RoleManifests.Filename)
-	fmt.Printf("reading %s\n", rolesManifestsFile)
-	manifests, _ := ExtractRoleManifestsFromTarGz(rolesManifestsFile)
+	manifests, err := client.GetRoleManifests()
+	if err != nil {
+		return nil, err
+	}
 	specs := []utils.InstallSpec{}
-	resolveRoleDeps(spec, &manifests, &specs)
+	if err := resolveRoleDeps(spec, &manifests, &specs, nil); err != nil {
+		return nil, err
+	}
 
 	return specs, nil
 }
 
 func GetRepoClient(repo string, cachePath string) (RepoClient, error) {
 	if utils.IsURL(repo) {
-		return &HttpRepoClient{BaseURL: repo, CachePath: cachePath}, nil
+		// keep user:password@ out of the logs, the shared client sends it instead
+		baseURL, cred := httpclient.SplitUserinfo(repo)
+		if cred != nil {
+			httpclient.Default().AddCredential(*cred)
+		}
+		return &HttpRepoClient{BaseURL: baseURL, CachePath: cachePath}, nil
 	} else if utils.IsDir(repo) {
 		return &FileRepoClient{BasePath: repo, CachePath: cachePath}, nil
 	} else {
-		return nil, fmt.Errorf("unsupported repo format")
+		return nil, laxerrors.New(laxerrors.ErrNotFound, "%s is neither a url nor a repo directory", repo)
 	}
 }
 
-func resolveCollectionDeps(spec utils.InstallSpec, manifests *[]CollectionManifest, specs *[]utils.InstallSpec) {
+/*
+Resolve a collection spec and its dependencies into specs. The latest
+matching version is used unless prefer (namespace.name -> version) names
+a version that also matches, which is how installed versions are kept.
+A spec with no matching version is a not found error and a constraint
+that the already selected version does not satisfy is a conflict.
+*/
+func resolveCollectionDeps(spec utils.InstallSpec, manifests *[]CollectionManifest, specs *[]utils.InstallSpec, edges *[]DependencyEdge, prefer map[string]string) (utils.InstallSpec, error) {
+
+	// was a version already picked for this namespace.name?
+	if existing, ok := specListFindNamespaceName(specs, spec); ok {
+		if !constraintAdmitsVersion(spec.Version, existing.Version) {
+			return existing, laxerrors.New(
+				laxerrors.ErrConflict,
+				"%s.%s %s is required but %s was already selected",
+				spec.Namespace, spec.Name, spec.Version, existing.Version,
+			)
+		}
+		return existing, nil
+	}
 
 	candidates := SpecToManifestCandidates(spec, manifests)
 
-	// exit early if nothing was found
-	if len(candidates) == 0 {
-		fmt.Printf("ERROR2: found no candidates for %s %s ... %d\n", spec, candidates, len(candidates))
-		return
-	}
-
 	// sort by version
 	sortedManifests, _ := SortManifestsByVersion(candidates)
 
+	// exit early if nothing was found
 	if len(sortedManifests) == 0 {
-		fmt.Printf("ERROR: found no candidates for %s\n", spec)
-		return
+		return spec, notFoundError(spec)
 	}
 
 	// use the latest version
 	thisManifest := sortedManifests[len(sortedManifests)-1]
-	fmt.Printf("%s.%s latest: %s\n", thisManifest.CollectionInfo.Namespace, thisManifest.CollectionInfo.Name, thisManifest.CollectionInfo.Version)
+	if v, ok := prefer[spec.Namespace+"."+spec.Name]; ok {
+		for _, m := range sortedManifests {
+			if m.CollectionInfo.Version == v {
+				thisManifest = m
+			}
+		}
+	}
+	logrus.Debugf("%s.%s latest: %s", thisManifest.CollectionInfo.Namespace, thisManifest.CollectionInfo.Name, thisManifest.CollectionInfo.Version)
 	thisSpec := utils.InstallSpec{
 		Namespace: thisManifest.CollectionInfo.Namespace,
 		Name:      thisManifest.CollectionInfo.Name,
 		Version:   thisManifest.CollectionInfo.Version,
 	}
-	if specListContainsNamespaceName(specs, thisSpec) {
-		return
-	}
-	if specListContainsNamespaceNameVersion(specs, thisSpec) {
-		return
-	}
 	*specs = append(*specs, thisSpec)
 
-	// what are the 1st order deps
-	for j, d := range thisManifest.CollectionInfo.Dependencies {
-		fmt.Printf("\tdep: %s %s\n", j, d)
+	// what are the 1st order deps (in a stable order)
+	depNames := make([]string, 0, len(thisManifest.CollectionInfo.Dependencies))
+	for j := range thisManifest.CollectionInfo.Dependencies {
+		depNames = append(depNames, j)
+	}
+	sort.Strings(depNames)
+	for _, j := range depNames {
+		d := thisManifest.CollectionInfo.Dependencies[j]
+		logrus.Debugf("\tdep: %s %s", j, d)
 		parts := strings.Split(j, ".")
-		fmt.Printf("\t\t%s\n", parts)
+		if len(parts) != 2 {
+			return thisSpec, laxerrors.New(laxerrors.ErrNotFound, "%s has an invalid dependency name %q", specString(thisSpec), j)
+		}
 		dSpec := utils.InstallSpec{
 			Namespace: parts[0],
 			Name:      parts[1],
 			Version:   d,
 		}
-		fmt.Printf("\t\t%s\n", dSpec)
-		resolveCollectionDeps(dSpec, manifests, specs)
+		logrus.Debugf("\t\t%s", dSpec)
+		resolved, err := resolveCollectionDeps(dSpec, manifests, specs, edges, prefer)
+		if err != nil {
+			return thisSpec, fmt.Errorf("%s: %w", specString(thisSpec), err)
+		}
+		if edges != nil {
+			*edges = append(*edges, DependencyEdge{From: thisSpec, To: resolved, Constraint: d})
+		}
 	}
 
 	// sort the specs
@@ -375,59 +499,68 @@ func resolveCollectionDeps(spec utils.InstallSpec, manifests *[]CollectionManife
 	// check for duplicates ... ?
 	DeduplicateSpecs(specs)
 
-	//return specs
-
+	return thisSpec, nil
 }
 
-func resolveRoleDeps(spec utils.InstallSpec, manifests *[]types.RoleMeta, specs *[]utils.InstallSpec) {
+/*
+Resolve a role spec and its dependencies. Dependencies are expected to be
+namespace.name references to other roles in the repo.
+*/
+func resolveRoleDeps(spec utils.InstallSpec, manifests *[]types.RoleMeta, specs *[]utils.InstallSpec, prefer map[string]string) error {
+
+	if existing, ok := specListFindNamespaceName(specs, spec); ok {
+		if !constraintAdmitsVersion(spec.Version, existing.Version) {
+			return laxerrors.New(
+				laxerrors.ErrConflict,
+				"%s.%s %s is required but %s was already selected",
+				spec.Namespace, spec.Name, spec.Version, existing.Version,
+			)
+		}
+		return nil
+	}
 
 	candidates := RoleSpecToManifestCandidates(spec, manifests)
 
-	// exit early if nothing was found
-	if len(candidates) == 0 {
-		fmt.Printf("ERROR2: found no candidates for %s %s ... %d\n", spec, candidates, len(candidates))
-		return
-	}
-
 	// sort by version
 	sortedManifests, _ := SortRoleManifestsByVersion(candidates)
 
+	// exit early if nothing was found
 	if len(sortedManifests) == 0 {
-		fmt.Printf("ERROR: found no candidates for %s\n", spec)
-		return
+		return notFoundError(spec)
 	}
 
 	// use the latest version
 	thisManifest := sortedManifests[len(sortedManifests)-1]
-	fmt.Printf("%s.%s latest: %s\n", thisManifest.GalaxyInfo.Namespace, thisManifest.GalaxyInfo.RoleName, thisManifest.GalaxyInfo.Version)
+	if v, ok := prefer[spec.Namespace+"."+spec.Name]; ok {
+		for _, m := range sortedManifests {
+			if m.GalaxyInfo.Version == v {
+				thisManifest = m
+			}
+		}
+	}
+	logrus.Debugf("%s.%s latest: %s", thisManifest.GalaxyInfo.Namespace, thisManifest.GalaxyInfo.RoleName, thisManifest.GalaxyInfo.Version)
 	thisSpec := utils.InstallSpec{
 		Namespace: thisManifest.GalaxyInfo.Namespace,
 		Name:      thisManifest.GalaxyInfo.RoleName,
 		Version:   thisManifest.GalaxyInfo.Version,
 	}
-	if specListContainsNamespaceName(specs, thisSpec) {
-		return
-	}
-	if specListContainsNamespaceNameVersion(specs, thisSpec) {
-		return
-	}
 	*specs = append(*specs, thisSpec)
 
 	// what are the 1st order deps
-	for j, d := range thisManifest.GalaxyInfo.Dependencies {
-		fmt.Printf("\tdep: %d %s\n", j, d)
-		panic("")
-		/*
-			parts := strings.Split(j, ".")
-			fmt.Printf("\t\t%s\n", parts)
-			dSpec := utils.InstallSpec{
-				Namespace: parts[0],
-				Name:      parts[1],
-				Version:   d,
-			}
-			fmt.Printf("\t\t%s\n", dSpec)
-			resolveRoleDeps(dSpec, manifests, specs)
-		*/
+	for _, d := range thisManifest.GalaxyInfo.Dependencies {
+		logrus.Debugf("\tdep: %s %s", d.Name, d.Version)
+		parts := strings.Split(d.Name, ".")
+		if len(parts) != 2 {
+			return laxerrors.New(laxerrors.ErrNotFound, "%s depends on %q which is not a namespace.name role", specString(thisSpec), d.Name)
+		}
+		dSpec := utils.InstallSpec{
+			Namespace: parts[0],
+			Name:      parts[1],
+			Version:   d.Version,
+		}
+		if err := resolveRoleDeps(dSpec, manifests, specs, prefer); err != nil {
+			return fmt.Errorf("%s: %w", specString(thisSpec), err)
+		}
 	}
 
 	// sort the specs
@@ -436,8 +569,18 @@ func resolveRoleDeps(spec utils.InstallSpec, manifests *[]types.RoleMeta, specs
 	// check for duplicates ... ?
 	DeduplicateSpecs(specs)
 
-	//return specs
+	return nil
+}
+
+func specString(spec utils.InstallSpec) string {
+	return fmt.Sprintf("%s.%s==%s", spec.Namespace, spec.Name, spec.Version)
+}
 
+func notFoundError(spec utils.InstallSpec) error {
+	if spec.Version == "" || spec.Version == "*" {
+		return laxerrors.New(laxerrors.ErrNotFound, "%s.%s was not found in the repository", spec.Namespace, spec.Name)
+	}
+	return laxerrors.New(laxerrors.ErrNotFound, "no version of %s.%s in the repository matches %s", spec.Namespace, spec.Name, spec.Version)
 }
 
 /*
@@ -482,35 +625,14 @@ func SpecToManifestCandidates(spec utils.InstallSpec, manifests *[]CollectionMan
 
 func RoleSpecToManifestCandidates(spec utils.InstallSpec, manifests *[]types.RoleMeta) []types.RoleMeta {
 
-	fmt.Printf("##############################################\n")
-
-	fmt.Printf("%s\n", manifests)
-
 	// get the meta for the incoming spec
 	candidates := []types.RoleMeta{}
 	for _, manifest := range *manifests {
 
-		pretty, _ := utils.PrettyPrint(manifest)
-		fmt.Println(pretty)
-
 		if manifest.GalaxyInfo.Namespace != spec.Namespace {
-			fmt.Printf(
-				"skip %s %s.%s==%s\n",
-				manifest,
-				manifest.GalaxyInfo.Namespace,
-				manifest.GalaxyInfo.RoleName,
-				manifest.GalaxyInfo.Version,
-			)
 			continue
 		}
 		if manifest.GalaxyInfo.RoleName != spec.Name {
-			fmt.Printf(
-				"skip %s %s.%s==%s\n",
-				manifest,
-				manifest.GalaxyInfo.Namespace,
-				manifest.GalaxyInfo.RoleName,
-				manifest.GalaxyInfo.Version,
-			)
 			continue
 		}
 
@@ -536,7 +658,7 @@ func RoleSpecToManifestCandidates(spec utils.InstallSpec, manifests *[]types.Rol
 		candidates = append(candidates, manifest)
 	}
 
-	fmt.Printf("##############################################\n")
+	logrus.Debugf("%d candidates for %s", len(candidates), specString(spec))
 	return candidates
 }
 
@@ -570,6 +692,15 @@ func specListContainsNamespaceNameVersion(specs *[]utils.InstallSpec, newSpec ut
 	return false
 }
 
+func specListFindNamespaceName(specs *[]utils.InstallSpec, newSpec utils.InstallSpec) (utils.InstallSpec, bool) {
+	for _, spec := range *specs {
+		if spec.Namespace == newSpec.Namespace && spec.Name == newSpec.Name {
+			return spec, true
+		}
+	}
+	return utils.InstallSpec{}, false
+}
+
 func specListContainsNamespaceName(specs *[]utils.InstallSpec, newSpec utils.InstallSpec) bool {
 	for _, spec := range *specs {
 		if spec.Namespace == newSpec.Namespace && spec.Name == newSpec.Name {
@@ -591,39 +722,46 @@ func DeduplicateSpecs(specs *[]utils.InstallSpec) {
 
 }
 
-func DownloadFile(url, dest string) error {
+/*
+DownloadFile fetches url into dest through a .part file that is only
+renamed into place once it matches opts. Index files can't be checked
+and change in place, so they are fetched with NoResume.
+*/
+func DownloadFile(url, dest string, opts utils.DownloadOptions) error {
 	// Define the directory
 	destDir := filepath.Dir(dest)
 	err := utils.MakeDirs(destDir)
 	if err != nil {
-		fmt.Printf("%s\n", err)
+		logrus.Debugf("%s", err)
 		return err
 	}
 
-	// Create the file
-	outFile, err := os.Create(dest)
-	if err != nil {
-		return fmt.Errorf("create file: %v", err)
-	}
-	defer outFile.Close()
+	return utils.DownloadToPath(url, dest, opts)
+}
 
-	// Get the data
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("get url: %v", err)
+/*
+cachedArtifactMatches reports whether file is already cached with the
+sha256 the index expects. A cached file that doesn't match, like one
+replaced in the repo under the same name, is removed so it is fetched
+again.
+*/
+func cachedArtifactMatches(file string, artifact types.ArtifactInfo) bool {
+	if !utils.FileExists(file) {
+		return false
 	}
-	defer resp.Body.Close()
-
-	// Check server response
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+	if artifact.Sha256 == "" {
+		return true
 	}
-
-	// Writer the body to file
-	_, err = io.Copy(outFile, resp.Body)
-	if err != nil {
-		return fmt.Errorf("write to file: %v", err)
+	actual, _, err := utils.Sha256File(file)
+	if err == nil && actual == artifact.Sha256 {
+		return true
 	}
+	logrus.Warnf("cached %s does not match the repo index, downloading it again", file)
+	os.Remove(file)
+	return false
+}
 
-	return nil
+// artifactDownloadOptions checks a download against what the index says about the artifact
+func artifactDownloadOptions(artifact types.ArtifactInfo) utils.DownloadOptions {
+	return utils.DownloadOptions{Size: artifact.Size, Sha256: artifact.Sha256}
 }
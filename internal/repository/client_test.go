@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+)
+
+func TestHttpCacheRedownloadsStaleArtifacts(t *testing.T) {
+	repo := writePruneRepo(t)
+	if err := CreateRepo(&types.CmdKwargs{DestDir: repo}); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.FileServer(http.Dir(repo)))
+	defer server.Close()
+
+	cache := t.TempDir()
+	client := &HttpRepoClient{BaseURL: server.URL, CachePath: cache}
+	if err := client.FetchRepoMeta(cache); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		lookup func(utils.InstallSpec) (string, error)
+		spec   utils.InstallSpec
+		source string
+	}{
+		{"collection", client.GetCacheFileLocationForInstallSpec, utils.InstallSpec{Namespace: "ns", Name: "base", Version: "2.0.0"}, "collections/ns-base-2.0.0.tar.gz"},
+		{"role", client.GetCacheRoleFileLocationForInstallSpec, utils.InstallSpec{Namespace: "geer", Name: "java", Version: "2.0.0"}, "roles/geer-java-2.0.0.tar.gz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cached, err := tt.lookup(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, _, _ := utils.Sha256File(filepath.Join(repo, filepath.FromSlash(tt.source)))
+
+			// a cached copy that no longer matches the index is fetched again
+			f, _ := os.OpenFile(cached, os.O_APPEND|os.O_WRONLY, 0644)
+			f.Write([]byte("stale"))
+			f.Close()
+
+			again, err := tt.lookup(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual, _, _ := utils.Sha256File(again); actual != expected {
+				t.Errorf("the stale cached %s was returned", again)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// ClosureProblem is a version in the index that can't be installed from the repo alone
+type ClosureProblem struct {
+	Type      string `json:"type"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	// missing when a requirement has no matching version, conflict when two requirements disagree
+	Reason string `json:"reason"`
+	// the resolver's error, which names the requirement that failed and the chain that led to it
+	Message string `json:"message"`
+}
+
+// ClosureReport is the result of a closure check over a whole repo
+type ClosureReport struct {
+	Repo        string           `json:"repo"`
+	Collections int              `json:"collections"`
+	Roles       int              `json:"roles"`
+	Problems    []ClosureProblem `json:"problems"`
+}
+
+// Closure checks that every version in the repo in args[0] (a directory or url) resolves, like dnf repoclosure
+func Closure(kwargs *types.CmdKwargs, args []string) error {
+	repo := "."
+	if len(args) > 1 {
+		return laxerrors.New(laxerrors.ErrUsage, "closure takes at most one repo")
+	}
+	if len(args) == 1 {
+		repo = args[0]
+	}
+
+	// always read the index as it is now, closure is meant to run right after a sync
+	repo, collections, roles, err := loadRepoManifests(repo, kwargs.CacheDir, !kwargs.RolesOnly, !kwargs.CollectionsOnly)
+	if err != nil {
+		return err
+	}
+
+	report := CheckClosure(collections, roles)
+	report.Repo = repo
+
+	switch kwargs.OutputFormat {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	case "", "text":
+		for _, p := range report.Problems {
+			fmt.Fprintf(os.Stdout, "%s %s.%s %s: %s: %s\n", p.Type, p.Namespace, p.Name, p.Version, p.Reason, p.Message)
+		}
+		fmt.Fprintf(os.Stdout, "checked %d collection and %d role versions: %d can't be installed\n", report.Collections, report.Roles, len(report.Problems))
+	default:
+		return laxerrors.New(laxerrors.ErrUsage, "unknown output format %s", kwargs.OutputFormat)
+	}
+
+	if len(report.Problems) > 0 {
+		return fmt.Errorf("%d versions in %s can't be installed from it", len(report.Problems), repo)
+	}
+	return nil
+}
+
+/*
+CheckClosure resolves every collection and role version in the index on
+its own, with the same resolver and rules as lax install, and reports
+the ones that fail. Each version is pinned exactly and its dependencies
+get the latest matching version. A conflict is tried again with older
+versions of the dependencies like install does, so a problem here is a
+problem a user would hit installing that version.
+*/
+func CheckClosure(collections []CollectionManifest, roles []types.RoleMeta) ClosureReport {
+	report := ClosureReport{Collections: len(collections), Roles: len(roles), Problems: []ClosureProblem{}}
+
+	reachable := collectionsReachableFrom(collections)
+	for _, m := range collections {
+		info := m.CollectionInfo
+		spec := utils.InstallSpec{Namespace: info.Namespace, Name: info.Name, Version: info.Version}
+		manifests := reachable(info.Namespace + "." + info.Name)
+		if _, err := ResolveCollectionDepsRetrying(spec, &manifests, nil); err != nil {
+			report.Problems = append(report.Problems, closureProblem("collection", spec, err))
+		}
+	}
+
+	reachableRoles := rolesReachableFrom(roles)
+	for _, m := range roles {
+		info := m.GalaxyInfo
+		spec := utils.InstallSpec{Namespace: info.Namespace, Name: info.RoleName, Version: info.Version}
+		manifests := reachableRoles(info.Namespace + "." + info.RoleName)
+		if _, err := ResolveRoleDepsRetrying(spec, &manifests, nil); err != nil {
+			report.Problems = append(report.Problems, closureProblem("role", spec, err))
+		}
+	}
+
+	// the index order is whatever createrepo walked, sort so reports can be diffed between syncs
+	sort.SliceStable(report.Problems, func(i, j int) bool {
+		a, b := report.Problems[i], report.Problems[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Namespace+"."+a.Name != b.Namespace+"."+b.Name {
+			return a.Namespace+"."+a.Name < b.Namespace+"."+b.Name
+		}
+		return versionLess(a.Version, b.Version)
+	})
+
+	return report
+}
+
+func closureProblem(kind string, spec utils.InstallSpec, err error) ClosureProblem {
+	reason := "missing"
+	if errors.Is(err, laxerrors.ErrConflict) {
+		reason = "conflict"
+	}
+	logrus.Debugf("%s %s: %s", kind, specString(spec), err)
+	return ClosureProblem{
+		Type:      kind,
+		Namespace: spec.Namespace,
+		Name:      spec.Name,
+		Version:   spec.Version,
+		Reason:    reason,
+		// the resolver starts the chain with the spec itself
+		Message: strings.TrimPrefix(err.Error(), specString(spec)+": "),
+	}
+}
+
+/*
+collectionsReachableFrom returns a function giving, for a namespace.name,
+every manifest of it and of anything any of its versions could pull in.
+Resolving against that instead of the whole index keeps a closure check
+of a large mirror from being quadratic.
+*/
+func collectionsReachableFrom(manifests []CollectionManifest) func(string) []CollectionManifest {
+	byName := map[string][]CollectionManifest{}
+	for _, m := range manifests {
+		fqn := m.CollectionInfo.Namespace + "." + m.CollectionInfo.Name
+		byName[fqn] = append(byName[fqn], m)
+	}
+	cache := map[string][]CollectionManifest{}
+	return func(root string) []CollectionManifest {
+		if found, ok := cache[root]; ok {
+			return found
+		}
+		found := []CollectionManifest{}
+		seen := map[string]bool{root: true}
+		queue := []string{root}
+		for len(queue) > 0 {
+			fqn := queue[0]
+			queue = queue[1:]
+			for _, m := range byName[fqn] {
+				found = append(found, m)
+				for dep := range m.CollectionInfo.Dependencies {
+					if !seen[dep] {
+						seen[dep] = true
+						queue = append(queue, dep)
+					}
+				}
+			}
+		}
+		cache[root] = found
+		return found
+	}
+}
+
+// rolesReachableFrom is the role equivalent of collectionsReachableFrom
+func rolesReachableFrom(manifests []types.RoleMeta) func(string) []types.RoleMeta {
+	byName := map[string][]types.RoleMeta{}
+	for _, m := range manifests {
+		fqn := m.GalaxyInfo.Namespace + "." + m.GalaxyInfo.RoleName
+		byName[fqn] = append(byName[fqn], m)
+	}
+	cache := map[string][]types.RoleMeta{}
+	return func(root string) []types.RoleMeta {
+		if found, ok := cache[root]; ok {
+			return found
+		}
+		found := []types.RoleMeta{}
+		seen := map[string]bool{root: true}
+		queue := []string{root}
+		for len(queue) > 0 {
+			fqn := queue[0]
+			queue = queue[1:]
+			for _, m := range byName[fqn] {
+				found = append(found, m)
+				for _, dep := range m.GalaxyInfo.Dependencies {
+					if !seen[dep.Name] {
+						seen[dep.Name] = true
+						queue = append(queue, dep.Name)
+					}
+				}
+			}
+		}
+		cache[root] = found
+		return found
+	}
+}
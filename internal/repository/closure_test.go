@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jctanner/lax/internal/types"
+)
+
+func testRoleManifest(namespace string, name string, version string, deps ...string) types.RoleMeta {
+	meta := types.RoleMeta{GalaxyInfo: types.GalaxyInfo{Namespace: namespace, RoleName: name, Version: version}}
+	for _, dep := range deps {
+		meta.GalaxyInfo.Dependencies = append(meta.GalaxyInfo.Dependencies, types.RoleDependency{Name: dep})
+	}
+	return meta
+}
+
+func TestCheckClosure(t *testing.T) {
+	tests := []struct {
+		name        string
+		collections []CollectionManifest
+		roles       []types.RoleMeta
+		expected    []string
+	}{
+		{
+			"everything resolves",
+			testManifests(),
+			[]types.RoleMeta{testRoleManifest("geer", "java", "1.0.0"), testRoleManifest("geer", "tomcat", "1.0.0", "geer.java")},
+			[]string{},
+		},
+		{
+			"partial sync",
+			append(testManifests(), testManifest("ns4", "d", "1.0.0", map[string]string{"ns5.gone": "*"})),
+			[]types.RoleMeta{testRoleManifest("geer", "tomcat", "1.0.0", "geer.java")},
+			[]string{
+				"collection ns4.d 1.0.0 missing: ns5.gone was not found in the repository",
+				"role geer.tomcat 1.0.0 missing: geer.java was not found in the repository",
+			},
+		},
+		{
+			"old versions only",
+			[]CollectionManifest{
+				testManifest("ns1", "a", "1.0.0", map[string]string{"ns2.b": ">=2.0.0"}),
+				testManifest("ns1", "a", "2.0.0", map[string]string{"ns2.b": "*"}),
+				testManifest("ns2", "b", "1.0.0", map[string]string{}),
+			},
+			nil,
+			[]string{"collection ns1.a 1.0.0 missing: no version of ns2.b in the repository matches >=2.0.0"},
+		},
+		{
+			"conflict an older version settles",
+			[]CollectionManifest{
+				testManifest("ns", "app", "1.0.0", map[string]string{"ns.base": "*", "ns.web": "*"}),
+				testManifest("ns", "base", "1.0.0", map[string]string{}),
+				testManifest("ns", "base", "0.9.0", map[string]string{}),
+				testManifest("ns", "web", "1.0.0", map[string]string{"ns.base": "<1.0.0"}),
+			},
+			nil,
+			[]string{},
+		},
+		{
+			"conflict",
+			[]CollectionManifest{
+				testManifest("ns", "app", "1.0.0", map[string]string{"ns.base": ">=1.0.0", "ns.web": "*"}),
+				testManifest("ns", "base", "1.0.0", map[string]string{}),
+				testManifest("ns", "base", "0.9.0", map[string]string{}),
+				testManifest("ns", "web", "1.0.0", map[string]string{"ns.base": "<1.0.0"}),
+			},
+			nil,
+			[]string{"collection ns.app 1.0.0 conflict: ns.web==1.0.0: ns.base <1.0.0 is required but 1.0.0 was already selected"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := CheckClosure(tt.collections, tt.roles)
+			got := []string{}
+			for _, p := range report.Problems {
+				got = append(got, p.Type+" "+p.Namespace+"."+p.Name+" "+p.Version+" "+p.Reason+": "+p.Message)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("problems\n got %v\nwant %v", got, tt.expected)
+			}
+			if report.Collections != len(tt.collections) || report.Roles != len(tt.roles) {
+				t.Errorf("expected %d collections and %d roles checked, got %d and %d", len(tt.collections), len(tt.roles), report.Collections, report.Roles)
+			}
+		})
+	}
+}
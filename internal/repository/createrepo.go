@@ -7,8 +7,10 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/jctanner/lax/internal/laxerrors"
 	"github.com/jctanner/lax/internal/types"
 	"github.com/jctanner/lax/internal/utils"
+	"github.com/sirupsen/logrus"
 )
 
 // func CreateRepo(dest string, roles_only bool, collectios_only bool) error {
@@ -18,7 +20,7 @@ func CreateRepo(kwargs *types.CmdKwargs) error {
 	//roles_only := kwargs.RolesOnly
 	//collections_only := kwargs.CollectionsOnly
 
-	fmt.Printf("Create repo in %s\n", dest)
+	logrus.Infof("create repo in %s", dest)
 
 	// find the full path
 	apath, err := utils.GetAbsPath(dest)
@@ -28,25 +30,51 @@ func CreateRepo(kwargs *types.CmdKwargs) error {
 
 	// assert it's a dir that exists
 	if !utils.IsDir(apath) {
-		fmt.Printf("%s is not a directory\n", apath)
-		return nil
+		return laxerrors.New(laxerrors.ErrNotFound, "%s is not a directory", apath)
+	}
+
+	// keep the layout the repo has unless --layout changes it
+	var layout int
+	if kwargs.Layout != "" {
+		if layout, err = ParseLayout(kwargs.Layout); err != nil {
+			return err
+		}
+		moved, err := relayoutRepo(apath, layout)
+		if err != nil {
+			return err
+		}
+		if moved > 0 {
+			fmt.Printf("moved %d artifacts to the %s layout\n", moved, LayoutName(layout))
+		}
+	} else if layout, err = RepoLayout(apath); err != nil {
+		return err
 	}
 
 	// assert it has a collections subdir
 	collectionsPath := filepath.Join(apath, "collections")
-	err = processCollections(apath, collectionsPath)
+	collectionCount, err := processCollections(apath, collectionsPath)
 	if err != nil {
-		fmt.Printf("ERROR: %s\n", err)
+		logrus.Errorf("%s", err)
 	}
 
 	// assert it has a collections subdir
 	rolesPath := filepath.Join(apath, "roles")
-	err = processRoles(apath, rolesPath)
+	roleCount, err := processRoles(apath, rolesPath)
 	if err != nil {
-		fmt.Printf("ERROR: %s\n", err)
+		logrus.Errorf("%s", err)
 	}
 
 	// write repodata.json
+	if err := writeRepoMeta(apath, layout); err != nil {
+		return err
+	}
+
+	fmt.Printf("indexed %d collections and %d roles in %s\n", collectionCount, roleCount, apath)
+	return nil
+}
+
+// writeRepoMeta points repometa.json at the index files with a new date
+func writeRepoMeta(apath string, layout int) error {
 	currentTime := time.Now().UTC()
 	isoFormattedCurrent := currentTime.Format(time.RFC3339)
 	rMeta := RepoMeta{
@@ -67,52 +95,47 @@ func CreateRepo(kwargs *types.CmdKwargs) error {
 			Date:     isoFormattedCurrent,
 			Filename: "role_files.tar.gz",
 		},
+		Layout: layout,
 	}
+	return saveRepoMeta(apath, rMeta)
+}
 
+// saveRepoMeta writes repometa.json, the switch that makes clients use the index files it names
+func saveRepoMeta(apath string, rMeta RepoMeta) error {
 	// Marshal the RepoMeta instance to JSON
 	jsonData, err := json.MarshalIndent(rMeta, "", "  ")
 	if err != nil {
-		fmt.Println("Error marshaling to JSON:", err)
-		return err
+		return fmt.Errorf("error marshaling to JSON: %w", err)
 	}
 
-	// Write the JSON data to a file
+	// Write the JSON data to a file, clients read it first so it is swapped in whole
 	fn := filepath.Join(apath, "repometa.json")
-	file, err := os.Create(fn)
-	if err != nil {
-		fmt.Println("Error creating file:", err)
-		return err
+	if err := os.WriteFile(fn+utils.PartSuffix, jsonData, 0644); err != nil {
+		return fmt.Errorf("error writing to file: %w", err)
 	}
-	defer file.Close()
-
-	_, err = file.Write(jsonData)
-	if err != nil {
-		fmt.Println("Error writing to file:", err)
-		return err
-	}
-
-	return nil
+	return os.Rename(fn+utils.PartSuffix, fn)
 }
 
-func processCollections(basePath string, collectionsPath string) error {
-
-	if !utils.IsDir(collectionsPath) {
-		fmt.Printf("%s is not a directory\n", collectionsPath)
-		return nil
-		//hasCollections = false
-	}
+func processCollections(basePath string, collectionsPath string) (int, error) {
 
-	// make a list of tarballs
-	collectionTarBalls, err := utils.ListTarGzFiles(collectionsPath)
-	if err != nil {
-		return err
+	// make a list of tarballs, repometa.json always names the index
+	// files so they are written even when there is nothing to index
+	var collectionTarBalls []string
+	var err error
+	if utils.IsDir(collectionsPath) {
+		collectionTarBalls, err = utils.ListTarGzFiles(collectionsPath)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		logrus.Warnf("%s is not a directory", collectionsPath)
 	}
 
 	// we need the metdata from each file
 	metadataDir := filepath.Join(basePath, "metadata")
 	err = utils.MakeDirs(metadataDir)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// store all collectionManifests
@@ -120,79 +143,54 @@ func processCollections(basePath string, collectionsPath string) error {
 	collectionFilesCache := []CollectionCachedFileInfo{}
 
 	for _, file := range collectionTarBalls {
-		fmt.Printf("%s\n", file)
+		logrus.Debugf("%s", file)
 
-		// get MANIFEST.json + FILES.json
-		fmap, err := utils.ExtractJSONFilesFromTarGz(file, []string{"MANIFEST.json", "FILES.json"})
+		manifest, files, err := collectionIndexEntry(file)
 		if err != nil {
-			fmt.Printf("ERROR extracting %s\n", err)
+			logrus.Errorf("%s: %s", file, err)
 			continue
 		}
-
-		var manifest CollectionManifest
-		var filesdata CollectionFilesMeta
-
-		err = json.Unmarshal(fmap["MANIFEST.json"], &manifest)
-		if err != nil {
-			fmt.Printf("ERROR %s\n", err)
-			continue
-		}
-		//fmt.Printf("%s\n", manifest)
+		manifest.Artifact.Path = repoRelPath(basePath, file)
 		collectionManifests = append(collectionManifests, manifest)
-
-		err = json.Unmarshal(fmap["FILES.json"], &filesdata)
-		if err != nil {
-			fmt.Printf("ERROR %s\n", err)
-			continue
-		}
-
-		for _, f := range filesdata.Files {
-			fDs := CollectionCachedFileInfo{
-				Namespace:      manifest.CollectionInfo.Namespace,
-				Name:           manifest.CollectionInfo.Name,
-				Version:        manifest.CollectionInfo.Version,
-				FileName:       f.Name,
-				FileType:       f.FType,
-				CheckSumSHA256: f.CheckSumSHA256,
-			}
-
-			collectionFilesCache = append(collectionFilesCache, fDs)
-
-		}
-
+		collectionFilesCache = append(collectionFilesCache, files...)
 	}
 
 	// write manifests.tar.gz
 	collectionManifestsFilePath := filepath.Join(basePath, "collection_manifests.tar.gz")
-	fmt.Printf("write %s\n", collectionManifestsFilePath)
-	createCollectionManifestsTarGz(collectionManifests, collectionManifestsFilePath)
+	logrus.Infof("write %s", collectionManifestsFilePath)
+	if err := createCollectionManifestsTarGz(collectionManifests, collectionManifestsFilePath); err != nil {
+		return 0, err
+	}
 
 	// write files.tar.gz
-	fmt.Printf("total files %d\n", len(collectionFilesCache))
+	logrus.Infof("total files %d", len(collectionFilesCache))
 	collectionsCachedFilesPath := filepath.Join(basePath, "collection_files.tar.gz")
-	saveCachedCollectionFilesToGzippedFile(collectionFilesCache, collectionsCachedFilesPath, 1000000)
+	if err := saveCachedCollectionFilesToGzippedFile(collectionFilesCache, collectionsCachedFilesPath, 1000000); err != nil {
+		return 0, err
+	}
 
-	return nil
+	return len(collectionManifests), nil
 }
 
-func processRoles(basePath string, rolesPath string) error {
-	if !utils.IsDir(rolesPath) {
-		fmt.Printf("%s is not a directory\n", rolesPath)
-		return nil
-		//hasCollections = false
-	}
-
-	// make a list of tarballs
-	roleTarBalls, err := utils.ListTarGzFiles(rolesPath)
-	if err != nil {
-		return err
+func processRoles(basePath string, rolesPath string) (int, error) {
+	// make a list of tarballs, repometa.json always names the index
+	// files so they are written even when there is nothing to index
+	var roleTarBalls []string
+	var err error
+	if utils.IsDir(rolesPath) {
+		roleTarBalls, err = utils.ListTarGzFiles(rolesPath)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		logrus.Warnf("%s is not a directory", rolesPath)
 	}
 
 	// we need the metdata from each file
 	metadataDir := filepath.Join(basePath, "metadata")
 	err = utils.MakeDirs(metadataDir)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// store all role manifests
@@ -200,7 +198,7 @@ func processRoles(basePath string, rolesPath string) error {
 	roleFilesCache := []RoleCachedFileInfo{}
 
 	for _, f := range roleTarBalls {
-		fmt.Printf("tar: %s\n", f)
+		logrus.Debugf("tar: %s", f)
 
 		rmeta, _ := GetRoleMetaFromTarball(f)
 
@@ -218,37 +216,90 @@ func processRoles(basePath string, rolesPath string) error {
 			rmeta.GalaxyInfo.Version = extractRoleVersionFromTarName(f)
 		}
 
-		pretty, _ := utils.PrettyPrint(rmeta)
-		fmt.Println(pretty)
-
-		rolesMeta = append(rolesMeta, rmeta)
-
-		tarFileNames, _ := utils.ListFilenamesInTarGz(f)
-		for _, tfn := range tarFileNames {
-			fmt.Printf("%s\n", tfn)
-			relativePath := utils.RemoveFirstPathElement(tfn)
-			fmt.Printf("\t%s\n", relativePath)
-
-			cf := RoleCachedFileInfo{
-				Namespace: rmeta.GalaxyInfo.Namespace,
-				Name:      rmeta.GalaxyInfo.RoleName,
-				Version:   rmeta.GalaxyInfo.Version,
-				FileName:  tfn,
-			}
-			roleFilesCache = append(roleFilesCache, cf)
+		rmeta, files, err := roleIndexEntry(f, rmeta)
+		if err != nil {
+			logrus.Errorf("%s: %s", f, err)
+			continue
 		}
-
+		rmeta.Artifact.Path = repoRelPath(basePath, f)
+		rolesMeta = append(rolesMeta, rmeta)
+		roleFilesCache = append(roleFilesCache, files...)
 	}
 
 	// write manifests.tar.gz
 	roleMetaFilePath := filepath.Join(basePath, "role_manifests.tar.gz")
-	fmt.Printf("write %s\n", roleMetaFilePath)
-	createRoleMetaTarGz(rolesMeta, roleMetaFilePath)
+	logrus.Infof("write %s", roleMetaFilePath)
+	if err := createRoleMetaTarGz(rolesMeta, roleMetaFilePath); err != nil {
+		return 0, err
+	}
 
 	// write files.tar.gz
-	fmt.Printf("total files %d\n", len(roleFilesCache))
+	logrus.Infof("total files %d", len(roleFilesCache))
 	roleCachedFilesPath := filepath.Join(basePath, "role_files.tar.gz")
-	saveCachedRoleFilesToGzippedFile(roleFilesCache, roleCachedFilesPath, 1000000)
+	if err := saveCachedRoleFilesToGzippedFile(roleFilesCache, roleCachedFilesPath, 1000000); err != nil {
+		return 0, err
+	}
 
-	return nil
+	return len(rolesMeta), nil
+}
+
+// collectionIndexEntry reads the manifest and file list of one collection artifact
+func collectionIndexEntry(file string) (CollectionManifest, []CollectionCachedFileInfo, error) {
+	var manifest CollectionManifest
+	var filesdata CollectionFilesMeta
+
+	// get MANIFEST.json + FILES.json
+	fmap, err := utils.ExtractJSONFilesFromTarGz(file, []string{"MANIFEST.json", "FILES.json"})
+	if err != nil {
+		return manifest, nil, fmt.Errorf("error extracting %w", err)
+	}
+
+	if err := json.Unmarshal(fmap["MANIFEST.json"], &manifest); err != nil {
+		return manifest, nil, err
+	}
+
+	// record the artifact details so clients can plan and verify downloads
+	manifest.Artifact.Sha256, manifest.Artifact.Size, err = utils.Sha256File(file)
+	if err != nil {
+		return manifest, nil, err
+	}
+
+	if err := json.Unmarshal(fmap["FILES.json"], &filesdata); err != nil {
+		return manifest, nil, err
+	}
+
+	files := []CollectionCachedFileInfo{}
+	for _, f := range filesdata.Files {
+		files = append(files, CollectionCachedFileInfo{
+			Namespace:      manifest.CollectionInfo.Namespace,
+			Name:           manifest.CollectionInfo.Name,
+			Version:        manifest.CollectionInfo.Version,
+			FileName:       f.Name,
+			FileType:       f.FType,
+			CheckSumSHA256: f.CheckSumSHA256,
+		})
+	}
+
+	return manifest, files, nil
+}
+
+// roleIndexEntry adds the artifact details and file list to a role's meta
+func roleIndexEntry(f string, rmeta types.RoleMeta) (types.RoleMeta, []RoleCachedFileInfo, error) {
+	var err error
+	rmeta.Artifact.Sha256, rmeta.Artifact.Size, err = utils.Sha256File(f)
+	if err != nil {
+		return rmeta, nil, err
+	}
+
+	files := []RoleCachedFileInfo{}
+	tarFileNames, _ := utils.ListFilenamesInTarGz(f)
+	for _, tfn := range tarFileNames {
+		files = append(files, RoleCachedFileInfo{
+			Namespace: rmeta.GalaxyInfo.Namespace,
+			Name:      rmeta.GalaxyInfo.RoleName,
+			Version:   rmeta.GalaxyInfo.Version,
+			FileName:  tfn,
+		})
+	}
+	return rmeta, files, nil
 }
@@ -0,0 +1,307 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver/v4"
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+)
+
+// DependencyChange is one dependency that was added, removed or given a different constraint
+type DependencyChange struct {
+	Name string `json:"name"`
+	// empty when the dependency was added
+	Old string `json:"old,omitempty"`
+	// empty when the dependency was removed
+	New string `json:"new,omitempty"`
+}
+
+// DiffEntry is one collection or role version that differs between two repos
+type DiffEntry struct {
+	Type      string `json:"type"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	// for a changed version, artifact and/or dependencies
+	Changes []string `json:"changes,omitempty"`
+	// the old version the dependencies are compared to, the same version when it changed or the latest one when it was added
+	DependenciesSince string             `json:"dependencies_since,omitempty"`
+	Dependencies      []DependencyChange `json:"dependencies,omitempty"`
+}
+
+// RepoDiff is everything lax repo diff found between two repos
+type RepoDiff struct {
+	Old     string      `json:"old"`
+	New     string      `json:"new"`
+	Added   []DiffEntry `json:"added"`
+	Removed []DiffEntry `json:"removed"`
+	Changed []DiffEntry `json:"changed"`
+}
+
+// diffVersion is the part of a collection or role manifest that a diff compares
+type diffVersion struct {
+	Type         string
+	Namespace    string
+	Name         string
+	Version      string
+	Sha256       string
+	Dependencies map[string]string
+}
+
+func (v diffVersion) fqn() string {
+	return v.Type + " " + v.Namespace + "." + v.Name
+}
+
+func (v diffVersion) entry() DiffEntry {
+	return DiffEntry{Type: v.Type, Namespace: v.Namespace, Name: v.Name, Version: v.Version}
+}
+
+// Diff prints what changed between the old repo in args[0] and the new one in args[1]
+func Diff(kwargs *types.CmdKwargs, args []string) error {
+	if len(args) != 2 {
+		return laxerrors.New(laxerrors.ErrUsage, "diff takes an old and a new repo")
+	}
+
+	sides := [2]diffSide{}
+	for i, label := range []string{"old", "new"} {
+		// each side gets its own cache, the index files have the same names in both
+		repo, collections, roles, err := loadRepoManifests(args[i], filepath.Join(kwargs.CacheDir, "diff", label), !kwargs.RolesOnly, !kwargs.CollectionsOnly)
+		if err != nil {
+			return err
+		}
+		sides[i] = diffSide{repo, collections, roles}
+	}
+
+	diff := DiffRepos(sides[0].Collections, sides[0].Roles, sides[1].Collections, sides[1].Roles)
+	diff.Old = sides[0].Repo
+	diff.New = sides[1].Repo
+
+	switch kwargs.OutputFormat {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(diff)
+	case "", "text":
+		printDiffEntries("+", diff.Added)
+		printDiffEntries("-", diff.Removed)
+		printDiffEntries("~", diff.Changed)
+		fmt.Fprintf(os.Stdout, "%d added, %d removed, %d changed\n", len(diff.Added), len(diff.Removed), len(diff.Changed))
+		return nil
+	default:
+		return laxerrors.New(laxerrors.ErrUsage, "unknown output format %s", kwargs.OutputFormat)
+	}
+}
+
+type diffSide struct {
+	Repo        string
+	Collections []CollectionManifest
+	Roles       []types.RoleMeta
+}
+
+func printDiffEntries(marker string, entries []DiffEntry) {
+	for _, e := range entries {
+		line := fmt.Sprintf("%s %s %s.%s %s", marker, e.Type, e.Namespace, e.Name, e.Version)
+		if len(e.Changes) > 0 {
+			line += " (" + strings.Join(e.Changes, ", ") + " changed)"
+		}
+		fmt.Fprintln(os.Stdout, line)
+		for _, d := range e.Dependencies {
+			switch {
+			case d.Old == "":
+				fmt.Fprintf(os.Stdout, "    + depends on %s %s\n", d.Name, d.New)
+			case d.New == "":
+				fmt.Fprintf(os.Stdout, "    - depends on %s %s\n", d.Name, d.Old)
+			default:
+				fmt.Fprintf(os.Stdout, "    ~ depends on %s %s, was %s\n", d.Name, d.New, d.Old)
+			}
+		}
+		if len(e.Dependencies) > 0 && e.DependenciesSince != e.Version {
+			fmt.Fprintf(os.Stdout, "      (dependencies compared to %s)\n", e.DependenciesSince)
+		}
+	}
+}
+
+/*
+loadRepoManifests reads the index of a repo dir, a repo url or a saved
+repometa.json with its index files next to it, copying it into cacheDir
+like install does. It returns the repo as it should be shown to users.
+*/
+func loadRepoManifests(repo string, cacheDir string, withCollections bool, withRoles bool) (string, []CollectionManifest, []types.RoleMeta, error) {
+	if utils.IsURL(repo) {
+		repo = strings.TrimSuffix(strings.TrimSuffix(repo, "/repometa.json"), "/")
+	} else {
+		var err error
+		if repo, err = utils.GetAbsPath(utils.ExpandUser(repo)); err != nil {
+			return repo, nil, nil, err
+		}
+		if filepath.Base(repo) == "repometa.json" && utils.IsFile(repo) {
+			repo = filepath.Dir(repo)
+		}
+	}
+
+	repoClient, err := GetRepoClient(repo, cacheDir)
+	if err != nil {
+		return repo, nil, nil, err
+	}
+	if err := repoClient.FetchRepoMeta(cacheDir); err != nil {
+		return repo, nil, nil, err
+	}
+
+	var collections []CollectionManifest
+	var roles []types.RoleMeta
+	if withCollections {
+		if collections, err = repoClient.GetCollectionManifests(); err != nil {
+			return repo, nil, nil, err
+		}
+	}
+	if withRoles {
+		if roles, err = repoClient.GetRoleManifests(); err != nil {
+			return repo, nil, nil, err
+		}
+	}
+	return repo, collections, roles, nil
+}
+
+/*
+DiffRepos compares two repo indexes. A version only in the new one is
+added and a version only in the old one is removed. A version in both
+changed when its artifact checksum or its dependencies differ. Added
+versions also list how their dependencies differ from the latest old
+version of the same collection or role, which is usually the part a
+reviewer of a mirror promotion cares about.
+*/
+func DiffRepos(oldCollections []CollectionManifest, oldRoles []types.RoleMeta, newCollections []CollectionManifest, newRoles []types.RoleMeta) RepoDiff {
+	diff := RepoDiff{Added: []DiffEntry{}, Removed: []DiffEntry{}, Changed: []DiffEntry{}}
+
+	oldVersions := diffVersions(oldCollections, oldRoles)
+	newVersions := diffVersions(newCollections, newRoles)
+
+	oldByKey := map[string]diffVersion{}
+	latestOld := map[string]diffVersion{}
+	for _, v := range oldVersions {
+		oldByKey[v.fqn()+" "+v.Version] = v
+		if latest, ok := latestOld[v.fqn()]; !ok || versionLess(latest.Version, v.Version) {
+			latestOld[v.fqn()] = v
+		}
+	}
+	newKeys := map[string]bool{}
+
+	for _, v := range newVersions {
+		key := v.fqn() + " " + v.Version
+		newKeys[key] = true
+		old, ok := oldByKey[key]
+		if !ok {
+			entry := v.entry()
+			if since, ok := latestOld[v.fqn()]; ok {
+				entry.Dependencies = diffDependencies(since.Dependencies, v.Dependencies)
+				if len(entry.Dependencies) > 0 {
+					entry.DependenciesSince = since.Version
+				}
+			}
+			diff.Added = append(diff.Added, entry)
+			continue
+		}
+
+		entry := v.entry()
+		if old.Sha256 != v.Sha256 {
+			entry.Changes = append(entry.Changes, "artifact")
+		}
+		if deps := diffDependencies(old.Dependencies, v.Dependencies); len(deps) > 0 {
+			entry.Changes = append(entry.Changes, "dependencies")
+			entry.Dependencies = deps
+			entry.DependenciesSince = v.Version
+		}
+		if len(entry.Changes) > 0 {
+			diff.Changed = append(diff.Changed, entry)
+		}
+	}
+
+	for _, v := range oldVersions {
+		if !newKeys[v.fqn()+" "+v.Version] {
+			diff.Removed = append(diff.Removed, v.entry())
+		}
+	}
+
+	return diff
+}
+
+// diffVersions flattens both kinds of manifest and sorts them by type, name and version
+func diffVersions(collections []CollectionManifest, roles []types.RoleMeta) []diffVersion {
+	versions := []diffVersion{}
+	seen := map[string]bool{}
+	add := func(v diffVersion) {
+		// an index can list a version twice, lint reports that, a diff only needs it once
+		if key := v.fqn() + " " + v.Version; !seen[key] {
+			seen[key] = true
+			versions = append(versions, v)
+		}
+	}
+	for _, m := range collections {
+		info := m.CollectionInfo
+		deps := map[string]string{}
+		for name, constraint := range info.Dependencies {
+			deps[name] = constraint
+		}
+		add(diffVersion{"collection", info.Namespace, info.Name, info.Version, m.Artifact.Sha256, deps})
+	}
+	for _, m := range roles {
+		info := m.GalaxyInfo
+		deps := map[string]string{}
+		for _, d := range info.Dependencies {
+			constraint := d.Version
+			if constraint == "" {
+				constraint = "*"
+			}
+			deps[d.Name] = constraint
+		}
+		add(diffVersion{"role", info.Namespace, info.RoleName, info.Version, m.Artifact.Sha256, deps})
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].fqn() != versions[j].fqn() {
+			return versions[i].fqn() < versions[j].fqn()
+		}
+		return versionLess(versions[i].Version, versions[j].Version)
+	})
+	return versions
+}
+
+// diffDependencies lists the dependencies that were added, removed or changed, by name
+func diffDependencies(old map[string]string, new map[string]string) []DependencyChange {
+	names := []string{}
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []DependencyChange{}
+	for _, name := range names {
+		if old[name] != new[name] {
+			changes = append(changes, DependencyChange{Name: name, Old: old[name], New: new[name]})
+		}
+	}
+	return changes
+}
+
+// versionLess compares semver versions, falling back to plain string order for anything else
+func versionLess(a string, b string) bool {
+	va, errA := semver.Parse(a)
+	vb, errB := semver.Parse(b)
+	if errA != nil || errB != nil {
+		return a < b
+	}
+	return va.LT(vb)
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jctanner/lax/internal/types"
+)
+
+func withSha256(m CollectionManifest, sum string) CollectionManifest {
+	m.Artifact.Sha256 = sum
+	return m
+}
+
+func TestDiffRepos(t *testing.T) {
+	oldCollections := []CollectionManifest{
+		withSha256(testManifest("ns1", "a", "1.0.0", map[string]string{"ns2.b": ">=1.0.0"}), "aaa"),
+		withSha256(testManifest("ns2", "b", "1.0.0", map[string]string{}), "bbb"),
+		withSha256(testManifest("ns3", "c", "1.0.0", map[string]string{}), "ccc"),
+	}
+	newCollections := []CollectionManifest{
+		withSha256(testManifest("ns1", "a", "1.0.0", map[string]string{"ns2.b": ">=1.0.0"}), "aaa"),
+		withSha256(testManifest("ns1", "a", "1.1.0", map[string]string{"ns2.b": ">=1.1.0", "ns3.c": "*"}), "aaa2"),
+		withSha256(testManifest("ns2", "b", "1.0.0", map[string]string{"ns3.c": "*"}), "bbb2"),
+		withSha256(testManifest("ns4", "d", "1.0.0", map[string]string{"ns2.b": "*"}), "ddd"),
+	}
+	oldRoles := []types.RoleMeta{testRoleManifest("geer", "java", "1.0.0")}
+	newRoles := []types.RoleMeta{testRoleManifest("geer", "java", "1.0.0", "geer.repo"), testRoleManifest("geer", "repo", "1.0.0")}
+
+	diff := DiffRepos(oldCollections, oldRoles, newCollections, newRoles)
+
+	expectedAdded := []DiffEntry{
+		{
+			Type: "collection", Namespace: "ns1", Name: "a", Version: "1.1.0",
+			DependenciesSince: "1.0.0",
+			Dependencies: []DependencyChange{
+				{Name: "ns2.b", Old: ">=1.0.0", New: ">=1.1.0"},
+				{Name: "ns3.c", New: "*"},
+			},
+		},
+		{Type: "collection", Namespace: "ns4", Name: "d", Version: "1.0.0"},
+		{Type: "role", Namespace: "geer", Name: "repo", Version: "1.0.0"},
+	}
+	expectedRemoved := []DiffEntry{
+		{Type: "collection", Namespace: "ns3", Name: "c", Version: "1.0.0"},
+	}
+	expectedChanged := []DiffEntry{
+		{
+			Type: "collection", Namespace: "ns2", Name: "b", Version: "1.0.0",
+			Changes:           []string{"artifact", "dependencies"},
+			DependenciesSince: "1.0.0",
+			Dependencies:      []DependencyChange{{Name: "ns3.c", New: "*"}},
+		},
+		{
+			Type: "role", Namespace: "geer", Name: "java", Version: "1.0.0",
+			Changes:           []string{"dependencies"},
+			DependenciesSince: "1.0.0",
+			Dependencies:      []DependencyChange{{Name: "geer.repo", New: "*"}},
+		},
+	}
+
+	for _, tt := range []struct {
+		name     string
+		got      []DiffEntry
+		expected []DiffEntry
+	}{
+		{"added", diff.Added, expectedAdded},
+		{"removed", diff.Removed, expectedRemoved},
+		{"changed", diff.Changed, expectedChanged},
+	} {
+		if !reflect.DeepEqual(tt.got, tt.expected) {
+			t.Errorf("%s\n got %+v\nwant %+v", tt.name, tt.got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package repository
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver/v4"
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+)
+
+// DependencyEdge records the constraint that pulled a spec into the graph
+type DependencyEdge struct {
+	From       utils.InstallSpec
+	To         utils.InstallSpec
+	Constraint string
+}
+
+// DependencyGraph is the resolved set of specs for a root spec and the
+// edges between them
+type DependencyGraph struct {
+	Root  utils.InstallSpec
+	Nodes []utils.InstallSpec
+	Edges []DependencyEdge
+}
+
+// Children returns the edges leaving the given spec
+func (graph *DependencyGraph) Children(spec utils.InstallSpec) []DependencyEdge {
+	children := []DependencyEdge{}
+	for _, edge := range graph.Edges {
+		if edge.From.Equals(spec) {
+			children = append(children, edge)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].To.Namespace+"."+children[i].To.Name < children[j].To.Namespace+"."+children[j].To.Name
+	})
+	return children
+}
+
+// ReverseDependency is a collection version that declares a dependency
+// on some other collection
+type ReverseDependency struct {
+	Dependent  utils.InstallSpec
+	Constraint string
+}
+
+/*
+Resolve the dependency graph for a collection spec using the same
+rules as the installer (latest matching version wins).
+*/
+func ResolveCollectionDepGraph(spec utils.InstallSpec, manifests *[]CollectionManifest) (DependencyGraph, error) {
+	graph := DependencyGraph{}
+	specs := []utils.InstallSpec{}
+	root, err := resolveCollectionDeps(spec, manifests, &specs, &graph.Edges, nil)
+	if err != nil {
+		return graph, err
+	}
+	graph.Root = root
+	graph.Nodes = specs
+	return graph, nil
+}
+
+/*
+Resolve a collection spec like ResolveCollectionDeps, but keep any version
+in prefer (namespace.name -> version) that satisfies the constraints.
+Preferred versions that are not in the index are ignored.
+*/
+func ResolveCollectionDepsPreferring(spec utils.InstallSpec, manifests *[]CollectionManifest, prefer map[string]string) ([]utils.InstallSpec, error) {
+	specs := []utils.InstallSpec{}
+	if _, err := resolveCollectionDeps(spec, manifests, &specs, nil, prefer); err != nil {
+		return nil, err
+	}
+	return specs, nil
+}
+
+// ResolveRoleDepsPreferring is the role equivalent of ResolveCollectionDepsPreferring
+func ResolveRoleDepsPreferring(spec utils.InstallSpec, manifests *[]types.RoleMeta, prefer map[string]string) ([]utils.InstallSpec, error) {
+	specs := []utils.InstallSpec{}
+	if err := resolveRoleDeps(spec, manifests, &specs, prefer); err != nil {
+		return nil, err
+	}
+	return specs, nil
+}
+
+/*
+ResolveCollectionDepsRetrying resolves like ResolveCollectionDepsPreferring
+and, when that ends in a conflict, tries again preferring each other
+version of the collections the failed attempt had picked, newest first.
+The resolver takes the latest matching version and never backtracks,
+this gets it past the conflicts that one older version settles.
+*/
+func ResolveCollectionDepsRetrying(spec utils.InstallSpec, manifests *[]CollectionManifest, prefer map[string]string) ([]utils.InstallSpec, error) {
+	picked := []utils.InstallSpec{}
+	_, err := resolveCollectionDeps(spec, manifests, &picked, nil, prefer)
+	if err == nil {
+		return picked, nil
+	}
+	if !errors.Is(err, laxerrors.ErrConflict) {
+		return nil, err
+	}
+	versions := []utils.InstallSpec{}
+	for _, m := range *manifests {
+		info := m.CollectionInfo
+		versions = append(versions, utils.InstallSpec{Namespace: info.Namespace, Name: info.Name, Version: info.Version})
+	}
+	for _, alternative := range retryAlternatives(spec, picked, versions, prefer) {
+		if specs, retryErr := ResolveCollectionDepsPreferring(spec, manifests, alternative); retryErr == nil {
+			return specs, nil
+		}
+	}
+	return nil, err
+}
+
+// ResolveRoleDepsRetrying is the role equivalent of ResolveCollectionDepsRetrying
+func ResolveRoleDepsRetrying(spec utils.InstallSpec, manifests *[]types.RoleMeta, prefer map[string]string) ([]utils.InstallSpec, error) {
+	picked := []utils.InstallSpec{}
+	err := resolveRoleDeps(spec, manifests, &picked, prefer)
+	if err == nil {
+		return picked, nil
+	}
+	if !errors.Is(err, laxerrors.ErrConflict) {
+		return nil, err
+	}
+	versions := []utils.InstallSpec{}
+	for _, m := range *manifests {
+		info := m.GalaxyInfo
+		versions = append(versions, utils.InstallSpec{Namespace: info.Namespace, Name: info.RoleName, Version: info.Version})
+	}
+	for _, alternative := range retryAlternatives(spec, picked, versions, prefer) {
+		if specs, retryErr := ResolveRoleDepsPreferring(spec, manifests, alternative); retryErr == nil {
+			return specs, nil
+		}
+	}
+	return nil, err
+}
+
+// retryAlternatives is prefer with one other version of a picked dependency, for every such version, newest first
+func retryAlternatives(root utils.InstallSpec, picked []utils.InstallSpec, versions []utils.InstallSpec, prefer map[string]string) []map[string]string {
+	pickedVersions := map[string]string{}
+	for _, p := range picked {
+		pickedVersions[p.Namespace+"."+p.Name] = p.Version
+	}
+	others := []utils.InstallSpec{}
+	for _, v := range versions {
+		fqn := v.Namespace + "." + v.Name
+		current, ok := pickedVersions[fqn]
+		if ok && fqn != root.Namespace+"."+root.Name && v.Version != current {
+			others = append(others, v)
+		}
+	}
+	sort.SliceStable(others, func(i, j int) bool {
+		return versionLess(others[j].Version, others[i].Version)
+	})
+
+	alternatives := []map[string]string{}
+	for _, other := range others {
+		alternative := map[string]string{other.Namespace + "." + other.Name: other.Version}
+		for fqn, version := range prefer {
+			if _, ok := alternative[fqn]; !ok {
+				alternative[fqn] = version
+			}
+		}
+		alternatives = append(alternatives, alternative)
+	}
+	return alternatives
+}
+
+// LatestCollectionVersion returns the newest version of namespace.name in the index
+func LatestCollectionVersion(namespace string, name string, manifests *[]CollectionManifest) string {
+	candidates := SpecToManifestCandidates(utils.InstallSpec{Namespace: namespace, Name: name}, manifests)
+	sorted, err := SortManifestsByVersion(candidates)
+	if err != nil || len(sorted) == 0 {
+		return ""
+	}
+	return sorted[len(sorted)-1].CollectionInfo.Version
+}
+
+// LatestRoleVersion returns the newest version of namespace.name in the index
+func LatestRoleVersion(namespace string, name string, manifests *[]types.RoleMeta) string {
+	candidates := RoleSpecToManifestCandidates(utils.InstallSpec{Namespace: namespace, Name: name}, manifests)
+	sorted, err := SortRoleManifestsByVersion(candidates)
+	if err != nil || len(sorted) == 0 {
+		return ""
+	}
+	return sorted[len(sorted)-1].GalaxyInfo.Version
+}
+
+/*
+Find every collection version in the index that depends on namespace.name.
+If version is set, only dependents whose constraint admits that version
+are returned.
+*/
+func FindCollectionReverseDeps(namespace string, name string, version string, manifests *[]CollectionManifest) []ReverseDependency {
+	fqn := namespace + "." + name
+	rdeps := []ReverseDependency{}
+
+	for _, manifest := range *manifests {
+		constraint, ok := manifest.CollectionInfo.Dependencies[fqn]
+		if !ok {
+			continue
+		}
+		if version != "" && !constraintAdmitsVersion(constraint, version) {
+			continue
+		}
+		rdeps = append(rdeps, ReverseDependency{
+			Dependent: utils.InstallSpec{
+				Namespace: manifest.CollectionInfo.Namespace,
+				Name:      manifest.CollectionInfo.Name,
+				Version:   manifest.CollectionInfo.Version,
+			},
+			Constraint: constraint,
+		})
+	}
+
+	SortReverseDeps(rdeps)
+	return rdeps
+}
+
+func SortReverseDeps(rdeps []ReverseDependency) {
+	sort.Slice(rdeps, func(i, j int) bool {
+		a := rdeps[i].Dependent
+		b := rdeps[j].Dependent
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+}
+
+/*
+Check a single version against a dependency constraint such as ">=1.0.0",
+"1.2.3" or "*". Comma separated constraints must all be satisfied.
+*/
+func constraintAdmitsVersion(constraint string, version string) bool {
+	if constraint == "" || constraint == "*" {
+		return true
+	}
+
+	_, v, err := splitVersion(version)
+	if err != nil {
+		return false
+	}
+	ver, err := semver.Make(v)
+	if err != nil {
+		return false
+	}
+
+	for _, part := range strings.Split(constraint, ",") {
+		op, c, err := splitVersion(strings.TrimSpace(part))
+		if err != nil {
+			return false
+		}
+		cVer, err := semver.Make(c)
+		if err != nil {
+			return false
+		}
+		res, _ := utils.CompareSemVersions(op, &ver, &cVer)
+		if !res {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/jctanner/lax/internal/utils"
+)
+
+func testManifest(namespace string, name string, version string, deps map[string]string) CollectionManifest {
+	return CollectionManifest{
+		CollectionInfo: CollectionInfo{
+			Namespace:    namespace,
+			Name:         name,
+			Version:      version,
+			Dependencies: deps,
+		},
+	}
+}
+
+func testManifests() []CollectionManifest {
+	return []CollectionManifest{
+		testManifest("ns1", "a", "1.0.0", map[string]string{"ns2.b": ">=1.0.0"}),
+		testManifest("ns1", "a", "1.1.0", map[string]string{"ns2.b": ">=1.1.0", "ns3.c": "*"}),
+		testManifest("ns2", "b", "1.0.0", map[string]string{}),
+		testManifest("ns2", "b", "1.2.0", map[string]string{"ns3.c": ">=2.0.0"}),
+		testManifest("ns3", "c", "2.0.0", map[string]string{}),
+	}
+}
+
+func TestResolveCollectionDepGraph(t *testing.T) {
+	manifests := testManifests()
+	graph, err := ResolveCollectionDepGraph(utils.InstallSpec{Namespace: "ns1", Name: "a"}, &manifests)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if !graph.Root.Equals(utils.InstallSpec{Namespace: "ns1", Name: "a", Version: "1.1.0"}) {
+		t.Errorf("unexpected root %v", graph.Root)
+	}
+	if len(graph.Nodes) != 3 {
+		t.Errorf("expected 3 nodes, got %d: %v", len(graph.Nodes), graph.Nodes)
+	}
+	if len(graph.Edges) != 3 {
+		t.Errorf("expected 3 edges, got %d: %v", len(graph.Edges), graph.Edges)
+	}
+
+	children := graph.Children(graph.Root)
+	if len(children) != 2 {
+		t.Fatalf("expected 2 children of the root, got %d", len(children))
+	}
+	if children[0].To.Name != "b" || children[0].Constraint != ">=1.1.0" {
+		t.Errorf("unexpected first child %v", children[0])
+	}
+	if children[1].To.Name != "c" || children[1].Constraint != "*" {
+		t.Errorf("unexpected second child %v", children[1])
+	}
+}
+
+func TestFindCollectionReverseDeps(t *testing.T) {
+	manifests := testManifests()
+
+	tests := []struct {
+		name     string
+		version  string
+		expected []string
+	}{
+		{
+			name:     "Any version",
+			version:  "",
+			expected: []string{"a==1.0.0", "a==1.1.0"},
+		},
+		{
+			name:     "Only dependents admitting 1.0.0",
+			version:  "1.0.0",
+			expected: []string{"a==1.0.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdeps := FindCollectionReverseDeps("ns2", "b", tt.version, &manifests)
+			result := []string{}
+			for _, rdep := range rdeps {
+				result = append(result, rdep.Dependent.Name+"=="+rdep.Dependent.Version)
+			}
+			if len(result) != len(tt.expected) {
+				t.Fatalf("FindCollectionReverseDeps() = %v, want %v", result, tt.expected)
+			}
+			for i := range result {
+				if result[i] != tt.expected[i] {
+					t.Errorf("FindCollectionReverseDeps() = %v, want %v", result, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestConstraintAdmitsVersion(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"*", "1.0.0", true},
+		{"", "1.0.0", true},
+		{">=1.0.0", "1.0.0", true},
+		{">=1.1.0", "1.0.0", false},
+		{">=1.0.0,<2.0.0", "2.0.0", false},
+		{">=1.0.0,<2.0.0", "1.5.0", true},
+		{"1.2.3", "1.2.3", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.constraint+" "+tt.version, func(t *testing.T) {
+			result := constraintAdmitsVersion(tt.constraint, tt.version)
+			if result != tt.expected {
+				t.Errorf("constraintAdmitsVersion(%q, %q) = %v, want %v", tt.constraint, tt.version, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResolveCollectionDepsPreferring(t *testing.T) {
+	manifests := testManifests()
+
+	tests := []struct {
+		name     string
+		spec     utils.InstallSpec
+		prefer   map[string]string
+		expected []string
+	}{
+		{
+			name:     "No preferences picks the latest",
+			spec:     utils.InstallSpec{Namespace: "ns2", Name: "b"},
+			prefer:   nil,
+			expected: []string{"b==1.2.0", "c==2.0.0"},
+		},
+		{
+			name:     "Installed version is kept",
+			spec:     utils.InstallSpec{Namespace: "ns2", Name: "b"},
+			prefer:   map[string]string{"ns2.b": "1.0.0"},
+			expected: []string{"b==1.0.0"},
+		},
+		{
+			name:     "Installed version that does not satisfy is replaced",
+			spec:     utils.InstallSpec{Namespace: "ns1", Name: "a", Version: "1.1.0"},
+			prefer:   map[string]string{"ns2.b": "1.0.0"},
+			expected: []string{"a==1.1.0", "b==1.2.0", "c==2.0.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			specs, err := ResolveCollectionDepsPreferring(tt.spec, &manifests, tt.prefer)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			result := []string{}
+			for _, spec := range specs {
+				result = append(result, spec.Name+"=="+spec.Version)
+			}
+			if len(result) != len(tt.expected) {
+				t.Fatalf("ResolveCollectionDepsPreferring() = %v, want %v", result, tt.expected)
+			}
+			for i := range result {
+				if result[i] != tt.expected[i] {
+					t.Errorf("ResolveCollectionDepsPreferring() = %v, want %v", result, tt.expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// the repo layouts, repometa.json says which one a repo uses
+const (
+	// collections/<ns>-<name>-<ver>.tar.gz, what repos without a layout use
+	LayoutFlat = 1
+	// collections/<ns>/<ns>-<name>-<ver>.tar.gz, so no directory holds a whole mirror
+	LayoutSharded = 2
+)
+
+var layoutNames = map[int]string{
+	LayoutFlat:    "flat",
+	LayoutSharded: "sharded",
+}
+
+// ParseLayout turns a --layout name into a layout
+func ParseLayout(name string) (int, error) {
+	for layout, layoutName := range layoutNames {
+		if name == layoutName {
+			return layout, nil
+		}
+	}
+	return 0, laxerrors.New(laxerrors.ErrUsage, "unknown repo layout %s, use flat or sharded", name)
+}
+
+// LayoutName is the --layout name of a layout
+func LayoutName(layout int) string {
+	if name, ok := layoutNames[layout]; ok {
+		return name
+	}
+	return fmt.Sprintf("layout %d", layout)
+}
+
+// ArtifactRelPath is where a layout puts an artifact, relative to the repo root. kind is collection or role
+func ArtifactRelPath(layout int, kind string, namespace string, name string, version string) string {
+	dir := kind + "s"
+	tarName := fmt.Sprintf("%s-%s-%s.tar.gz", namespace, name, version)
+	if layout == LayoutSharded {
+		return path.Join(dir, namespace, tarName)
+	}
+	return path.Join(dir, tarName)
+}
+
+/*
+RepoLayout is the layout the repometa.json of a repo directory records.
+A repo without one, or from before layouts, is flat. A layout this lax
+doesn't know is an error, it can't tell where to put new artifacts.
+*/
+func RepoLayout(apath string) (int, error) {
+	if !utils.IsFile(filepath.Join(apath, "repometa.json")) {
+		return LayoutFlat, nil
+	}
+	repoMeta, err := readRepoMeta(apath)
+	if err != nil {
+		return 0, err
+	}
+	if repoMeta.Layout == 0 {
+		return LayoutFlat, nil
+	}
+	if _, ok := layoutNames[repoMeta.Layout]; !ok {
+		return 0, laxerrors.New(laxerrors.ErrUsage, "%s uses repo layout %d, this lax only knows flat and sharded", apath, repoMeta.Layout)
+	}
+	return repoMeta.Layout, nil
+}
+
+// indexedRelPath is where the index says an artifact is, or where the flat layout put it when the index doesn't say
+func indexedRelPath(artifact types.ArtifactInfo, kind string, namespace string, name string, version string) string {
+	if artifact.Path != "" {
+		// the index may come from anywhere, it only gets to point inside the repo
+		clean := path.Clean(artifact.Path)
+		if clean == artifact.Path && !path.IsAbs(clean) && !strings.HasPrefix(clean, "../") && clean != ".." {
+			return clean
+		}
+		logrus.Warnf("ignoring the path %s the index gives for %s.%s %s", artifact.Path, namespace, name, version)
+	}
+	return ArtifactRelPath(LayoutFlat, kind, namespace, name, version)
+}
+
+// repoRelPath is the slash separated path of p relative to the repo root, which is what the index records
+func repoRelPath(apath string, p string) string {
+	rel, err := filepath.Rel(apath, p)
+	if err != nil {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
+
+/*
+artifactLocations remembers where the index a client fetched says each
+artifact is, and its size and digest. It is loaded the first time a
+download needs it, downloads run concurrently so it is locked.
+*/
+type artifactLocations struct {
+	mu        sync.Mutex
+	loaded    bool
+	artifacts map[string]types.ArtifactInfo
+}
+
+// reset forgets the locations, the client fetched a new index
+func (l *artifactLocations) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loaded = false
+	l.artifacts = nil
+}
+
+// artifact is what the index says about the artifact of spec, Path is always set and relative to the repo root
+func (l *artifactLocations) artifact(client RepoClient, kind string, spec utils.InstallSpec) types.ArtifactInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.loaded {
+		l.artifacts = map[string]types.ArtifactInfo{}
+		if manifests, err := client.GetCollectionManifests(); err == nil {
+			for _, m := range manifests {
+				info := m.CollectionInfo
+				artifact := m.Artifact
+				artifact.Path = indexedRelPath(m.Artifact, "collection", info.Namespace, info.Name, info.Version)
+				l.artifacts["collection "+specString(utils.InstallSpec{Namespace: info.Namespace, Name: info.Name, Version: info.Version})] = artifact
+			}
+		}
+		if manifests, err := client.GetRoleManifests(); err == nil {
+			for _, m := range manifests {
+				info := m.GalaxyInfo
+				artifact := m.Artifact
+				artifact.Path = indexedRelPath(m.Artifact, "role", info.Namespace, info.RoleName, info.Version)
+				l.artifacts["role "+specString(utils.InstallSpec{Namespace: info.Namespace, Name: info.RoleName, Version: info.Version})] = artifact
+			}
+		}
+		l.loaded = true
+	}
+	if artifact, ok := l.artifacts[kind+" "+specString(spec)]; ok {
+		return artifact
+	}
+	return types.ArtifactInfo{Path: ArtifactRelPath(LayoutFlat, kind, spec.Namespace, spec.Name, spec.Version)}
+}
+
+/*
+relayoutRepo moves the artifacts under collections/ and roles/ to where
+layout puts them, along with the .bad and .lock files galaxy-sync keeps
+next to them. The links galaxy-sync leaves for renamed roles move next
+to their target, and a linked role is sharded by the namespace of its
+link, which is the galaxy namespace galaxy-sync looks for it under.
+Artifacts that can't be identified stay where they are. Returns how
+many artifacts moved.
+*/
+func relayoutRepo(apath string, layout int) (int, error) {
+	moved := map[string]string{}
+	links := []string{}
+	for _, kind := range []string{"collection", "role"} {
+		dir := filepath.Join(apath, kind+"s")
+		if !utils.IsDir(dir) {
+			continue
+		}
+		files, err := utils.ListTarGzFiles(dir)
+		if err != nil {
+			return len(moved), err
+		}
+		linkedBy := map[string]string{}
+		for _, f := range files {
+			if !utils.IsLink(f) {
+				continue
+			}
+			links = append(links, f)
+			if target, err := linkTarget(f); err == nil {
+				if _, ok := linkedBy[target]; !ok {
+					linkedBy[target] = f
+				}
+			}
+		}
+		for _, f := range files {
+			if utils.IsLink(f) {
+				continue
+			}
+			v, ok := identifyArtifact(kind+"s", f)
+			if !ok {
+				logrus.Warnf("%s: can't tell what version this is, leaving it where it is", f)
+				continue
+			}
+			relPath := ArtifactRelPath(layout, kind, v.Namespace, v.Name, v.Version)
+			if link, ok := linkedBy[filepath.Clean(f)]; ok && layout == LayoutSharded {
+				relPath = path.Join(kind+"s", extractRoleNamespaceFromTarName(link), path.Base(relPath))
+			}
+			dst := filepath.Join(apath, filepath.FromSlash(relPath))
+			if dst == f {
+				continue
+			}
+			if utils.FileExists(dst) {
+				logrus.Warnf("can't move %s, %s is already there", f, dst)
+				continue
+			}
+			if err := moveArtifact(f, dst); err != nil {
+				return len(moved), err
+			}
+			moved[f] = dst
+		}
+	}
+
+	for _, link := range links {
+		target, err := linkTarget(link)
+		if err != nil {
+			return len(moved), err
+		}
+		dst, ok := moved[target]
+		if !ok {
+			continue
+		}
+		newLink := filepath.Join(filepath.Dir(dst), filepath.Base(link))
+		if utils.FileExists(newLink) {
+			logrus.Warnf("can't move %s, %s is already there", link, newLink)
+			continue
+		}
+		if err := os.Symlink(filepath.Base(dst), newLink); err != nil {
+			return len(moved), err
+		}
+		if err := os.Remove(link); err != nil {
+			return len(moved), err
+		}
+		if err := moveArtifact(link, newLink); err != nil {
+			return len(moved), err
+		}
+	}
+
+	// the namespace directories going flat leaves behind, removing one that isn't empty fails
+	for f := range moved {
+		if dir := filepath.Dir(f); filepath.Dir(dir) != apath {
+			os.Remove(dir)
+		}
+	}
+	return len(moved), nil
+}
+
+// linkTarget is the cleaned path a link points to
+func linkTarget(link string) (string, error) {
+	target, err := os.Readlink(link)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(link), target)
+	}
+	return filepath.Clean(target), nil
+}
+
+// moveArtifact renames an artifact and its sidecars, an artifact that is already gone only moves its sidecars
+func moveArtifact(src string, dst string) error {
+	if err := utils.MakeDirs(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(src); err == nil {
+		logrus.Infof("move %s -> %s", src, dst)
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+	}
+	srcStem, dstStem := strings.TrimSuffix(src, ".tar.gz"), strings.TrimSuffix(dst, ".tar.gz")
+	for _, suffix := range pruneSidecarSuffixes {
+		if utils.FileExists(srcStem + suffix) {
+			if err := os.Rename(srcStem+suffix, dstStem+suffix); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
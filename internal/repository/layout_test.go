@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+)
+
+func TestIndexedRelPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		recorded string
+		expected string
+	}{
+		{"index from before layouts", "", "collections/ns-base-1.0.0.tar.gz"},
+		{"sharded", "collections/ns/ns-base-1.0.0.tar.gz", "collections/ns/ns-base-1.0.0.tar.gz"},
+		{"outside the repo", "../ns-base-1.0.0.tar.gz", "collections/ns-base-1.0.0.tar.gz"},
+		{"absolute", "/etc/passwd", "collections/ns-base-1.0.0.tar.gz"},
+		{"not clean", "collections/../../x.tar.gz", "collections/ns-base-1.0.0.tar.gz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := indexedRelPath(types.ArtifactInfo{Path: tt.recorded}, "collection", "ns", "base", "1.0.0")
+			if got != tt.expected {
+				t.Errorf("got %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCreateRepoLayout(t *testing.T) {
+	repo := writePruneRepo(t)
+
+	if err := CreateRepo(&types.CmdKwargs{DestDir: repo, Layout: "sharded"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{
+		"collections/ns/ns-base-1.0.0.tar.gz",
+		"collections/ns/ns-app-1.0.0.tar.gz",
+		"roles/geer/geer-java-1.0.0.tar.gz",
+		"roles/geer/geer-java-1.0.0.bad",
+		"roles/geer/geer-jdk-1.0.0.lock",
+	} {
+		if !utils.IsFile(filepath.Join(repo, filepath.FromSlash(f))) {
+			t.Errorf("%s wasn't moved", f)
+		}
+	}
+	if target, err := filepath.EvalSymlinks(filepath.Join(repo, "roles", "geer", "geer-jdk-1.0.0.tar.gz")); err != nil || filepath.Base(target) != "geer-java-1.0.0.tar.gz" {
+		t.Errorf("the link to the renamed role didn't follow it: %s %v", target, err)
+	}
+	if layout, err := RepoLayout(repo); err != nil || layout != LayoutSharded {
+		t.Errorf("expected the sharded layout to be recorded, got %d %v", layout, err)
+	}
+
+	// clients find the artifacts where the index says
+	client := &FileRepoClient{BasePath: repo}
+	if err := client.FetchRepoMeta(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	found, err := client.GetCacheFileLocationForInstallSpec(utils.InstallSpec{Namespace: "ns", Name: "base", Version: "2.0.0"})
+	if err != nil || found != filepath.Join(repo, "collections", "ns", "ns-base-2.0.0.tar.gz") {
+		t.Errorf("unexpected collection location %s %v", found, err)
+	}
+	found, err = client.GetCacheRoleFileLocationForInstallSpec(utils.InstallSpec{Namespace: "geer", Name: "java", Version: "2.0.0"})
+	if err != nil || found != filepath.Join(repo, "roles", "geer", "geer-java-2.0.0.tar.gz") {
+		t.Errorf("unexpected role location %s %v", found, err)
+	}
+
+	// publishing keeps to the layout
+	artifact := filepath.Join(t.TempDir(), "ns-other-1.0.0.tar.gz")
+	writeTarGz(t, artifact, testCollectionFiles("ns", "other", "1.0.0"))
+	result, err := PublishToDir(repo, artifact, PublishOptions{})
+	if err != nil || result.Path != "collections/ns/ns-other-1.0.0.tar.gz" {
+		t.Errorf("unexpected publish %+v %v", result, err)
+	}
+
+	// and back
+	if err := CreateRepo(&types.CmdKwargs{DestDir: repo, Layout: "flat"}); err != nil {
+		t.Fatal(err)
+	}
+	if !utils.IsFile(filepath.Join(repo, "collections", "ns-other-1.0.0.tar.gz")) || !utils.IsFile(filepath.Join(repo, "roles", "geer-java-1.0.0.bad")) {
+		t.Error("the artifacts weren't moved back")
+	}
+	if _, err := os.Stat(filepath.Join(repo, "collections", "ns")); err == nil {
+		t.Error("the empty namespace directory was left behind")
+	}
+	if err := CreateRepo(&types.CmdKwargs{DestDir: repo, Layout: "deep"}); err == nil {
+		t.Error("expected an unknown layout to fail")
+	}
+}
+
+func TestCreateRepoLayoutRenamedRole(t *testing.T) {
+	// galaxy-sync sharded geer.jdk by its galaxy namespace, the metadata renamed it to other.java
+	repo := t.TempDir()
+	shard := filepath.Join(repo, "roles", "geer")
+	os.MkdirAll(shard, 0755)
+	writeTarGz(t, filepath.Join(shard, "other-java-1.0.0.tar.gz"), map[string]string{"java/meta/main.yml": "galaxy_info:\n  author: geer\n"})
+	os.Symlink(filepath.Join(shard, "other-java-1.0.0.tar.gz"), filepath.Join(shard, "geer-jdk-1.0.0.tar.gz"))
+	os.WriteFile(filepath.Join(shard, "geer-jdk-1.0.0.lock"), nil, 0644)
+
+	for _, layout := range []string{"sharded", "flat", "sharded"} {
+		if err := CreateRepo(&types.CmdKwargs{DestDir: repo, Layout: layout}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// where galaxy-sync looks before downloading it again
+	if target, err := filepath.EvalSymlinks(filepath.Join(shard, "geer-jdk-1.0.0.tar.gz")); err != nil || target != filepath.Join(shard, "other-java-1.0.0.tar.gz") {
+		t.Errorf("the link galaxy-sync looks for moved: %s %v", target, err)
+	}
+	if !utils.IsFile(filepath.Join(shard, "geer-jdk-1.0.0.lock")) {
+		t.Error("the lock galaxy-sync keeps moved")
+	}
+	if _, err := os.Stat(filepath.Join(repo, "roles", "other")); err == nil {
+		t.Error("the role was sharded by its metadata namespace")
+	}
+
+	client := &FileRepoClient{BasePath: repo}
+	if err := client.FetchRepoMeta(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	found, err := client.GetCacheRoleFileLocationForInstallSpec(utils.InstallSpec{Namespace: "other", Name: "java", Version: "1.0.0"})
+	if err != nil || found != filepath.Join(shard, "other-java-1.0.0.tar.gz") {
+		t.Errorf("unexpected role location %s %v", found, err)
+	}
+}
@@ -0,0 +1,338 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver/v4"
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// lint severities, only errors make lax repo lint fail
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// LintIssue is one problem lax repo lint found with an artifact
+type LintIssue struct {
+	Severity string `json:"severity"`
+	// which check found it, e.g. metadata, filename, version, duplicate, dependency, symlink
+	Check string `json:"check"`
+	// relative to the repo directory
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// LintReport is everything lax repo lint found in a repo
+type LintReport struct {
+	Repo      string      `json:"repo"`
+	Artifacts int         `json:"artifacts"`
+	Errors    int         `json:"errors"`
+	Warnings  int         `json:"warnings"`
+	Issues    []LintIssue `json:"issues"`
+}
+
+func (r *LintReport) add(severity string, check string, relPath string, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, LintIssue{Severity: severity, Check: check, Path: relPath, Message: fmt.Sprintf(format, args...)})
+	if severity == LintError {
+		r.Errors++
+	} else {
+		r.Warnings++
+	}
+}
+
+// lintedArtifact is what the per artifact checks learned, for the checks across artifacts
+type lintedArtifact struct {
+	Type         string
+	Namespace    string
+	Name         string
+	Version      string
+	Path         string
+	Dependencies map[string]string
+}
+
+// Lint checks the repo in args[0] (or the current directory) and prints what it finds as text or json
+func Lint(kwargs *types.CmdKwargs, args []string) error {
+	repoDir := "."
+	if len(args) > 1 {
+		return laxerrors.New(laxerrors.ErrUsage, "lint takes at most one repo directory")
+	}
+	if len(args) == 1 {
+		repoDir = args[0]
+	}
+
+	report, err := LintRepo(repoDir)
+	if err != nil {
+		return err
+	}
+
+	switch kwargs.OutputFormat {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		// messages quote version constraints like >=1.0.0
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	case "", "text":
+		for _, issue := range report.Issues {
+			fmt.Fprintf(os.Stdout, "%s: %s: %s [%s]\n", issue.Path, issue.Severity, issue.Message, issue.Check)
+		}
+		fmt.Fprintf(os.Stdout, "checked %d artifacts: %d errors, %d warnings\n", report.Artifacts, report.Errors, report.Warnings)
+	default:
+		return laxerrors.New(laxerrors.ErrUsage, "unknown output format %s", kwargs.OutputFormat)
+	}
+
+	if report.Errors > 0 {
+		return fmt.Errorf("%s has %d lint errors", repoDir, report.Errors)
+	}
+	return nil
+}
+
+/*
+LintRepo checks every artifact under the collections and roles dirs of
+repoDir for the problems that otherwise only show up at install time:
+metadata that doesn't parse, filenames that disagree with the metadata,
+versions that aren't semver, the same version under two filenames,
+collection dependencies the repo can't satisfy and symlinks whose
+target is gone, which galaxy-sync leaves behind when it renames roles.
+Symlinks that resolve are aliases and aren't checked themselves.
+*/
+func LintRepo(repoDir string) (LintReport, error) {
+	apath, err := utils.GetAbsPath(utils.ExpandUser(repoDir))
+	if err != nil {
+		return LintReport{}, err
+	}
+	report := LintReport{Repo: apath, Issues: []LintIssue{}}
+
+	artifacts := []lintedArtifact{}
+	found := false
+	for _, kind := range []string{"collections", "roles"} {
+		dir := filepath.Join(apath, kind)
+		if !utils.IsDir(dir) {
+			continue
+		}
+		found = true
+		files, err := utils.ListTarGzFiles(dir)
+		if err != nil {
+			return report, err
+		}
+		for _, f := range files {
+			relPath, _ := filepath.Rel(apath, f)
+			relPath = filepath.ToSlash(relPath)
+			report.Artifacts++
+			logrus.Debugf("lint %s", relPath)
+
+			if !lintSymlink(&report, f, relPath) {
+				continue
+			}
+			var artifact lintedArtifact
+			var ok bool
+			if kind == "collections" {
+				artifact, ok = lintCollection(&report, f, relPath)
+			} else {
+				artifact, ok = lintRole(&report, f, relPath)
+			}
+			if ok {
+				artifacts = append(artifacts, artifact)
+			}
+		}
+	}
+	if !found {
+		return report, laxerrors.New(laxerrors.ErrNotFound, "%s has no collections or roles directory", repoDir)
+	}
+
+	lintDuplicates(&report, artifacts)
+	lintDependencies(&report, artifacts)
+
+	sort.SliceStable(report.Issues, func(i, j int) bool {
+		return report.Issues[i].Path < report.Issues[j].Path
+	})
+	return report, nil
+}
+
+/*
+lintSymlink reports a symlink whose target is gone. It returns false for
+every symlink, one that resolves is the alias galaxy-sync leaves under a
+renamed role's old name, and its target is checked on its own.
+*/
+func lintSymlink(report *LintReport, f string, relPath string) bool {
+	if !utils.IsLink(f) {
+		return true
+	}
+	if _, err := os.Stat(f); err != nil {
+		target, _ := os.Readlink(f)
+		report.add(LintError, "symlink", relPath, "symlink to %s, which does not exist", target)
+	}
+	return false
+}
+
+// lintCollection checks one collection artifact's MANIFEST.json, FILES.json and filename
+func lintCollection(report *LintReport, f string, relPath string) (lintedArtifact, bool) {
+	manifest, _, err := collectionIndexEntry(f)
+	if err != nil {
+		report.add(LintError, "metadata", relPath, "unreadable MANIFEST.json or FILES.json: %s", err)
+		return lintedArtifact{}, false
+	}
+	info := manifest.CollectionInfo
+	artifact := lintedArtifact{
+		Type:         "collection",
+		Namespace:    info.Namespace,
+		Name:         info.Name,
+		Version:      info.Version,
+		Path:         relPath,
+		Dependencies: info.Dependencies,
+	}
+	if info.Namespace == "" || info.Name == "" || info.Version == "" {
+		report.add(LintError, "metadata", relPath, "MANIFEST.json has no namespace, name or version")
+		return artifact, false
+	}
+
+	if expected := fmt.Sprintf("%s-%s-%s.tar.gz", info.Namespace, info.Name, info.Version); filepath.Base(f) != expected {
+		report.add(LintError, "filename", relPath, "MANIFEST.json says %s.%s %s, so the file should be named %s", info.Namespace, info.Name, info.Version, expected)
+	}
+	if _, err := semver.Parse(info.Version); err != nil {
+		report.add(LintError, "version", relPath, "%q is not a semver version", info.Version)
+		return artifact, true
+	}
+
+	// the checks lax publish makes, so a broken tarball shows up here and not at install time
+	sums, err := tarGzSha256s(f)
+	if err == nil {
+		_, _, err = validateCollectionArtifact(f, sums)
+	}
+	if err != nil {
+		check := "metadata"
+		if errors.Is(err, laxerrors.ErrChecksumMismatch) {
+			check = "checksum"
+		}
+		// the message starts with the artifact's absolute path, the issue already has the relative one
+		report.add(LintError, check, relPath, "%s", strings.TrimLeft(strings.TrimPrefix(err.Error(), f), ": "))
+	}
+	return artifact, true
+}
+
+// lintRole checks one role artifact's meta/main.yml and filename, filling in what the meta leaves out like createrepo does
+func lintRole(report *LintReport, f string, relPath string) (lintedArtifact, bool) {
+	rmeta, src, err := readRoleMetaFromTarball(f)
+	if err != nil {
+		report.add(LintError, "metadata", relPath, "unreadable meta/main.yml: %s", err)
+		return lintedArtifact{}, false
+	}
+	if src.MetaFile == "" {
+		report.add(LintError, "metadata", relPath, "there is no meta/main.yml in the tarball")
+		return lintedArtifact{}, false
+	}
+	if src.ParseErr != nil {
+		report.add(LintWarning, "metadata", relPath, "%s only parses after lax fixes it up: %s", src.MetaFile, src.ParseErr)
+	}
+
+	info := rmeta.GalaxyInfo
+	artifact := lintedArtifact{Type: "role", Namespace: info.Namespace, Name: info.RoleName, Version: info.Version, Path: relPath}
+
+	// a built role's lax_role.json has the final say, and nothing is taken from the filename
+	base := filepath.Base(f)
+	if src.BuildInfoFile == "" {
+		parts := strings.Split(strings.TrimSuffix(base, ".tar.gz"), "-")
+		if len(parts) >= 3 {
+			for _, field := range []struct {
+				label    string
+				value    *string
+				fromName string
+			}{
+				{"namespace", &artifact.Namespace, extractRoleNamespaceFromTarName(base)},
+				{"name", &artifact.Name, extractRoleNameFromTarName(base)},
+				{"version", &artifact.Version, extractRoleVersionFromTarName(base)},
+			} {
+				if *field.value == "" {
+					*field.value = field.fromName
+				} else if *field.value != field.fromName {
+					report.add(LintError, "filename", relPath, "meta/main.yml says the %s is %s but the filename says %s", field.label, *field.value, field.fromName)
+				}
+			}
+		}
+	}
+	if artifact.Namespace == "" || artifact.Name == "" || artifact.Version == "" {
+		report.add(LintError, "metadata", relPath, "the namespace, name and version can't be worked out from meta/main.yml or the filename")
+		return artifact, false
+	}
+	if _, err := semver.Parse(artifact.Version); err != nil {
+		report.add(LintError, "version", relPath, "%q is not a semver version", artifact.Version)
+	}
+	return artifact, true
+}
+
+// lintDuplicates reports versions that come from more than one file
+func lintDuplicates(report *LintReport, artifacts []lintedArtifact) {
+	paths := map[string][]string{}
+	keys := []string{}
+	for _, a := range artifacts {
+		key := fmt.Sprintf("%s %s.%s %s", a.Type, a.Namespace, a.Name, a.Version)
+		if _, ok := paths[key]; !ok {
+			keys = append(keys, key)
+		}
+		paths[key] = append(paths[key], a.Path)
+	}
+	for _, key := range keys {
+		if len(paths[key]) < 2 {
+			continue
+		}
+		for _, p := range paths[key] {
+			others := []string{}
+			for _, other := range paths[key] {
+				if other != p {
+					others = append(others, other)
+				}
+			}
+			report.add(LintError, "duplicate", p, "%s is also in %s", key, strings.Join(others, ", "))
+		}
+	}
+}
+
+// lintDependencies reports collection dependencies the repo has no version of, or no matching version of
+func lintDependencies(report *LintReport, artifacts []lintedArtifact) {
+	versions := map[string][]string{}
+	seen := map[string]bool{}
+	for _, a := range artifacts {
+		fqn := a.Namespace + "." + a.Name
+		if a.Type == "collection" && !seen[fqn+" "+a.Version] {
+			seen[fqn+" "+a.Version] = true
+			versions[fqn] = append(versions[fqn], a.Version)
+		}
+	}
+	for _, a := range artifacts {
+		deps := make([]string, 0, len(a.Dependencies))
+		for dep := range a.Dependencies {
+			deps = append(deps, dep)
+		}
+		sort.Strings(deps)
+		for _, dep := range deps {
+			constraint := a.Dependencies[dep]
+			available, ok := versions[dep]
+			if !ok {
+				report.add(LintError, "dependency", a.Path, "depends on %s, which is not in the repo", dep)
+				continue
+			}
+			admitted := false
+			for _, v := range available {
+				if constraintAdmitsVersion(constraint, v) {
+					admitted = true
+					break
+				}
+			}
+			if !admitted {
+				report.add(LintError, "dependency", a.Path, "depends on %s %s, but the repo only has %s", dep, constraint, strings.Join(available, ", "))
+			}
+		}
+	}
+}
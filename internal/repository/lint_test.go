@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// withDependencies sets the dependencies in a testCollectionFiles MANIFEST.json
+func withDependencies(files map[string]string, deps map[string]string) map[string]string {
+	var manifest map[string]interface{}
+	json.Unmarshal([]byte(files["MANIFEST.json"]), &manifest)
+	manifest["collection_info"].(map[string]interface{})["dependencies"] = deps
+	data, _ := json.Marshal(manifest)
+	files["MANIFEST.json"] = string(data)
+	return files
+}
+
+func TestLintRepo(t *testing.T) {
+	repo := t.TempDir()
+	collections := filepath.Join(repo, "collections")
+	roles := filepath.Join(repo, "roles")
+	os.MkdirAll(collections, 0755)
+	os.MkdirAll(roles, 0755)
+
+	artifacts := map[string]map[string]string{
+		"collections/ns-base-1.0.0.tar.gz": testCollectionFiles("ns", "base", "1.0.0"),
+		"collections/ns-app-1.0.0.tar.gz": withDependencies(testCollectionFiles("ns", "app", "1.0.0"),
+			map[string]string{"ns.base": ">=1.0.0", "ns.gone": "*"}),
+		"collections/ns-old-1.0.0.tar.gz":    withDependencies(testCollectionFiles("ns", "old", "1.0.0"), map[string]string{"ns.base": ">=2.0.0"}),
+		"collections/renamed.tar.gz":         testCollectionFiles("ns", "base", "1.0.0"),
+		"collections/ns-loose-1.0.tar.gz":    testCollectionFiles("ns", "loose", "1.0"),
+		"collections/ns-broken-1.0.0.tar.gz": {"README.md": "no manifest"},
+		"roles/geer-docker-1.0.0.tar.gz":     {"docker/meta/main.yml": "galaxy_info:\n  author: geer\n"},
+		"roles/geer-java-2.0.0.tar.gz":       {"java/meta/main.yml": "galaxy_info:\n  role_name: jdk\n"},
+		"roles/nameless.tar.gz":              {"x/meta/main.yml": "galaxy_info:\n  author: geer\n"},
+		"roles/geer-tasks-1.0.0.tar.gz":      {"tasks/tasks/main.yml": "- debug: msg=hi\n"},
+		"roles/geer-nginx-v1.tar.gz":         {"nginx/meta/main.yml": "galaxy_info:\n  author: geer\n"},
+		"roles/newns-newname-1.0.0.tar.gz":   {"newname/meta/main.yml": "galaxy_info:\n  author: geer\n"},
+	}
+	for name, files := range artifacts {
+		writeTarGz(t, filepath.Join(repo, filepath.FromSlash(name)), files)
+	}
+	// what galaxy-sync leaves when it renames a role, the link is only an alias
+	os.Symlink(filepath.Join(roles, "newns-newname-1.0.0.tar.gz"), filepath.Join(roles, "oldns-oldname-1.0.0.tar.gz"))
+	// what galaxy-sync leaves behind when the renamed file goes away
+	os.Symlink(filepath.Join(roles, "geer-gone-1.0.0.tar.gz"), filepath.Join(roles, "geer-link-1.0.0.tar.gz"))
+
+	report, err := LintRepo(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, issue := range report.Issues {
+		got = append(got, issue.Path+" "+issue.Check)
+	}
+	sort.Strings(got)
+	expected := []string{
+		"collections/ns-app-1.0.0.tar.gz dependency",
+		"collections/ns-base-1.0.0.tar.gz duplicate",
+		"collections/ns-broken-1.0.0.tar.gz metadata",
+		"collections/ns-loose-1.0.tar.gz version",
+		"collections/ns-old-1.0.0.tar.gz dependency",
+		"collections/renamed.tar.gz duplicate",
+		"collections/renamed.tar.gz filename",
+		"roles/geer-java-2.0.0.tar.gz filename",
+		"roles/geer-link-1.0.0.tar.gz symlink",
+		"roles/geer-nginx-v1.tar.gz version",
+		"roles/geer-tasks-1.0.0.tar.gz metadata",
+		"roles/nameless.tar.gz metadata",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("issues\n got %v\nwant %v", got, expected)
+	}
+	if report.Artifacts != len(artifacts)+2 || report.Errors != len(expected) || report.Warnings != 0 {
+		t.Errorf("expected %d artifacts and %d errors, got %d and %d (%d warnings)", len(artifacts)+2, len(expected), report.Artifacts, report.Errors, report.Warnings)
+	}
+}
@@ -0,0 +1,436 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blang/semver/v4"
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// galaxy-sync leaves these next to role artifacts, named like the artifact
+var pruneSidecarSuffixes = []string{".bad", ".lock"}
+
+// PruneOptions are the retention policies, a version is kept when any of them keeps it
+type PruneOptions struct {
+	// keep the latest N versions of every collection and role, 0 to not use this policy
+	KeepLatest int
+	// keep versions whose artifact was synced or published after this, zero to not use this policy
+	KeepSince time.Time
+	// keep what these requirements files (or lockfiles, requirements with pinned versions) install
+	KeepRequirements []string
+	DryRun           bool
+}
+
+// PrunedVersion is a collection or role version prune removed
+type PrunedVersion struct {
+	Type      string `json:"type"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	// relative to the repo, more than one when the same version is in several files
+	Paths []string `json:"paths"`
+}
+
+// PruneResult is what lax repo prune removed, or would remove with --dry-run
+type PruneResult struct {
+	Repo   string          `json:"repo"`
+	DryRun bool            `json:"dry_run"`
+	Kept   int             `json:"kept"`
+	Pruned []PrunedVersion `json:"pruned"`
+	// every file removed, relative to the repo: the artifacts, their sidecars and symlinks left dangling
+	Removed []string `json:"removed"`
+}
+
+// pruneVersion is one collection or role version found on disk
+type pruneVersion struct {
+	Type       string
+	Namespace  string
+	Name       string
+	Version    string
+	Paths      []string
+	Modified   time.Time
+	Collection CollectionManifest
+	Role       types.RoleMeta
+}
+
+func (v *pruneVersion) key() string {
+	return v.Type + " " + v.Namespace + "." + v.Name + " " + v.Version
+}
+
+// Prune applies the retention policies in kwargs to the repo in args[0]
+func Prune(kwargs *types.CmdKwargs, args []string) error {
+	if len(args) != 1 {
+		return laxerrors.New(laxerrors.ErrUsage, "prune takes one repo directory")
+	}
+	opts := PruneOptions{KeepLatest: kwargs.KeepLatest, KeepRequirements: kwargs.KeepRequirements, DryRun: kwargs.DryRun}
+	if kwargs.KeepSince != "" {
+		since, err := parsePruneDate(kwargs.KeepSince)
+		if err != nil {
+			return err
+		}
+		opts.KeepSince = since
+	}
+
+	result, err := PruneRepo(args[0], opts)
+	if err != nil {
+		return err
+	}
+
+	switch kwargs.OutputFormat {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case "", "text":
+		verb := "removed"
+		if result.DryRun {
+			verb = "would remove"
+		}
+		for _, p := range result.Removed {
+			fmt.Fprintf(os.Stdout, "%s %s\n", verb, p)
+		}
+		fmt.Fprintf(os.Stdout, "kept %d versions, %s %d versions and %d files\n", result.Kept, verb, len(result.Pruned), len(result.Removed))
+		return nil
+	default:
+		return laxerrors.New(laxerrors.ErrUsage, "unknown output format %s", kwargs.OutputFormat)
+	}
+}
+
+// parsePruneDate takes a date or a full RFC3339 timestamp
+func parsePruneDate(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, laxerrors.New(laxerrors.ErrUsage, "%q is not a date like 2024-05-01 or 2024-05-01T00:00:00Z", value)
+}
+
+/*
+PruneRepo removes the collection and role versions in repoDir that no
+retention policy keeps, then indexes the repo again. The dependencies of
+every kept version are kept too, as install would resolve them, so a
+prune never leaves a kept version uninstallable. Artifacts whose
+metadata can't be read or that a snapshot symlinks to are never removed,
+and keep latest keeps the versions it can't order. With each removed
+artifact go its galaxy-sync .bad and .lock sidecars and the symlinks to
+it.
+*/
+func PruneRepo(repoDir string, opts PruneOptions) (PruneResult, error) {
+	if opts.KeepLatest <= 0 && opts.KeepSince.IsZero() && len(opts.KeepRequirements) == 0 {
+		return PruneResult{}, laxerrors.New(laxerrors.ErrUsage, "prune needs at least one of --keep-latest, --keep-since or --keep-requirements")
+	}
+	apath, err := utils.GetAbsPath(utils.ExpandUser(repoDir))
+	if err != nil {
+		return PruneResult{}, err
+	}
+	// symlink targets are compared to the artifact paths, so those must be resolved too
+	if resolved, err := filepath.EvalSymlinks(apath); err == nil {
+		apath = resolved
+	}
+	result := PruneResult{Repo: apath, DryRun: opts.DryRun, Pruned: []PrunedVersion{}, Removed: []string{}}
+
+	versions, symlinks, err := pruneScan(apath)
+	if err != nil {
+		return result, err
+	}
+
+	keep, err := pruneKeep(apath, versions, opts)
+	if err != nil {
+		return result, err
+	}
+
+	removed := map[string]bool{}
+	for _, v := range versions {
+		if keep[v.key()] {
+			result.Kept++
+			continue
+		}
+		pruned := PrunedVersion{Type: v.Type, Namespace: v.Namespace, Name: v.Name, Version: v.Version, Paths: []string{}}
+		for _, p := range v.Paths {
+			pruned.Paths = append(pruned.Paths, repoRelPath(apath, p))
+			removed[p] = true
+		}
+		result.Pruned = append(result.Pruned, pruned)
+	}
+
+	// the artifacts, then what was named after them, then the links to them
+	toRemove := []string{}
+	stems := []string{}
+	for _, v := range versions {
+		for _, p := range v.Paths {
+			if removed[p] {
+				toRemove = append(toRemove, p)
+				stems = append(stems, strings.TrimSuffix(p, ".tar.gz"))
+			}
+		}
+	}
+	for _, link := range symlinks {
+		target, err := filepath.EvalSymlinks(link)
+		if err == nil && !removed[target] {
+			continue
+		}
+		// galaxy-sync names the sidecars after the name it downloaded, which the link keeps
+		toRemove = append(toRemove, link)
+		stems = append(stems, strings.TrimSuffix(link, ".tar.gz"))
+	}
+	for _, stem := range stems {
+		for _, suffix := range pruneSidecarSuffixes {
+			if utils.FileExists(stem + suffix) {
+				toRemove = append(toRemove, stem+suffix)
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, p := range toRemove {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		result.Removed = append(result.Removed, repoRelPath(apath, p))
+		if opts.DryRun {
+			continue
+		}
+		logrus.Infof("prune %s", p)
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return result, err
+		}
+	}
+
+	if opts.DryRun {
+		return result, nil
+	}
+	return result, indexRepo(apath)
+}
+
+// pruneScan reads the identity of every artifact in the repo, and lists the symlinks separately
+func pruneScan(apath string) ([]*pruneVersion, []string, error) {
+	byKey := map[string]*pruneVersion{}
+	versions := []*pruneVersion{}
+	symlinks := []string{}
+	found := false
+
+	for _, kind := range []string{"collections", "roles"} {
+		dir := filepath.Join(apath, kind)
+		if !utils.IsDir(dir) {
+			continue
+		}
+		found = true
+		files, err := utils.ListTarGzFiles(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, f := range files {
+			if utils.IsLink(f) {
+				symlinks = append(symlinks, f)
+				continue
+			}
+			v, ok := identifyArtifact(kind, f)
+			if !ok {
+				logrus.Warnf("%s: can't tell what version this is, keeping it", f)
+				continue
+			}
+			if existing, ok := byKey[v.key()]; ok {
+				existing.Paths = append(existing.Paths, f)
+				if v.Modified.After(existing.Modified) {
+					existing.Modified = v.Modified
+				}
+				continue
+			}
+			byKey[v.key()] = v
+			versions = append(versions, v)
+		}
+	}
+	if !found {
+		return nil, nil, laxerrors.New(laxerrors.ErrNotFound, "%s has no collections or roles directory", apath)
+	}
+	return versions, symlinks, nil
+}
+
+// identifyArtifact reads an artifact's namespace, name, version and dependencies like createrepo does
+func identifyArtifact(kind string, f string) (*pruneVersion, bool) {
+	info, err := os.Stat(f)
+	if err != nil {
+		return nil, false
+	}
+	v := &pruneVersion{Paths: []string{f}, Modified: info.ModTime()}
+
+	if kind == "collections" {
+		fmap, err := utils.ExtractJSONFilesFromTarGz(f, []string{"MANIFEST.json"})
+		if err != nil {
+			return nil, false
+		}
+		if err := json.Unmarshal(fmap["MANIFEST.json"], &v.Collection); err != nil {
+			return nil, false
+		}
+		ci := v.Collection.CollectionInfo
+		v.Type, v.Namespace, v.Name, v.Version = "collection", ci.Namespace, ci.Name, ci.Version
+	} else {
+		rmeta, src, err := readRoleMetaFromTarball(f)
+		if err != nil || src.MetaFile == "" {
+			return nil, false
+		}
+		gi := &rmeta.GalaxyInfo
+		if src.BuildInfoFile == "" {
+			if gi.Namespace == "" {
+				gi.Namespace = extractRoleNamespaceFromTarName(f)
+			}
+			if gi.RoleName == "" {
+				gi.RoleName = extractRoleNameFromTarName(f)
+			}
+			if gi.Version == "" {
+				gi.Version = extractRoleVersionFromTarName(f)
+			}
+		}
+		v.Role = rmeta
+		v.Type, v.Namespace, v.Name, v.Version = "role", gi.Namespace, gi.RoleName, gi.Version
+	}
+	return v, v.Namespace != "" && v.Name != "" && v.Version != ""
+}
+
+// pruneKeep applies the policies and returns the keys of the versions to keep
+func pruneKeep(apath string, versions []*pruneVersion, opts PruneOptions) (map[string]bool, error) {
+	keep := map[string]bool{}
+
+	byName := map[string][]*pruneVersion{}
+	for _, v := range versions {
+		fqn := v.Type + " " + v.Namespace + "." + v.Name
+		byName[fqn] = append(byName[fqn], v)
+		if !opts.KeepSince.IsZero() && v.Modified.After(opts.KeepSince) {
+			keep[v.key()] = true
+		}
+	}
+
+	if opts.KeepLatest > 0 {
+		for _, group := range byName {
+			ordered := []*pruneVersion{}
+			for _, v := range group {
+				// galaxy role versions are often v1.2.3, anything else can't be ordered so it stays
+				if _, err := semver.ParseTolerant(v.Version); err != nil {
+					keep[v.key()] = true
+					continue
+				}
+				ordered = append(ordered, v)
+			}
+			sort.Slice(ordered, func(i, j int) bool {
+				vi, _ := semver.ParseTolerant(ordered[i].Version)
+				vj, _ := semver.ParseTolerant(ordered[j].Version)
+				return vj.LT(vi)
+			})
+			for i := 0; i < len(ordered) && i < opts.KeepLatest; i++ {
+				keep[ordered[i].key()] = true
+			}
+		}
+	}
+
+	collections := []CollectionManifest{}
+	roles := []types.RoleMeta{}
+	for _, v := range versions {
+		if v.Type == "collection" {
+			collections = append(collections, v.Collection)
+		} else {
+			roles = append(roles, v.Role)
+		}
+	}
+	reachable := collectionsReachableFrom(collections)
+	reachableRoles := rolesReachableFrom(roles)
+
+	// what installing each requirement would pick from the repo as it is now
+	for _, fn := range opts.KeepRequirements {
+		reqs, err := types.ParseRequirementsFile(fn)
+		if err != nil {
+			return nil, laxerrors.Wrap(laxerrors.ErrUsage, err, "failed to parse %s", fn)
+		}
+		for _, req := range reqs.Collections {
+			spec, ok := requirementSpec(req.Name, req.Version)
+			if !ok {
+				return nil, laxerrors.New(laxerrors.ErrUsage, "%s: %s is not a namespace.name collection", fn, req.Name)
+			}
+			manifests := reachable(spec.Namespace + "." + spec.Name)
+			specs := []utils.InstallSpec{}
+			if _, err := resolveCollectionDeps(spec, &manifests, &specs, nil, nil); err != nil {
+				logrus.Warnf("%s: %s", fn, err)
+			}
+			keepSpecs(keep, "collection", specs)
+		}
+		for _, req := range reqs.Roles {
+			spec, ok := requirementSpec(req.Name, req.Version)
+			if !ok {
+				logrus.Warnf("%s: %s is not a namespace.name role, it can't keep anything", fn, req.Name)
+				continue
+			}
+			manifests := reachableRoles(spec.Namespace + "." + spec.Name)
+			specs := []utils.InstallSpec{}
+			if err := resolveRoleDeps(spec, &manifests, &specs, nil); err != nil {
+				logrus.Warnf("%s: %s", fn, err)
+			}
+			keepSpecs(keep, "role", specs)
+		}
+	}
+
+	// snapshots that fell back to symlinks would break if their targets went
+	snapshotLinks, _ := filepath.Glob(filepath.Join(apath, SnapshotsDir, "*", "*", "*.tar.gz"))
+	shardedLinks, _ := filepath.Glob(filepath.Join(apath, SnapshotsDir, "*", "*", "*", "*.tar.gz"))
+	snapshotLinks = append(snapshotLinks, shardedLinks...)
+	for _, link := range snapshotLinks {
+		if !utils.IsLink(link) {
+			continue
+		}
+		target, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			continue
+		}
+		for _, v := range versions {
+			for _, p := range v.Paths {
+				if p == target {
+					keep[v.key()] = true
+				}
+			}
+		}
+	}
+
+	// and whatever the kept versions need to install
+	for _, v := range versions {
+		if !keep[v.key()] {
+			continue
+		}
+		spec := utils.InstallSpec{Namespace: v.Namespace, Name: v.Name, Version: v.Version}
+		specs := []utils.InstallSpec{}
+		if v.Type == "collection" {
+			manifests := reachable(v.Namespace + "." + v.Name)
+			resolveCollectionDeps(spec, &manifests, &specs, nil, nil)
+			keepSpecs(keep, "collection", specs)
+		} else {
+			manifests := reachableRoles(v.Namespace + "." + v.Name)
+			resolveRoleDeps(spec, &manifests, &specs, nil)
+			keepSpecs(keep, "role", specs)
+		}
+	}
+
+	return keep, nil
+}
+
+// requirementSpec turns a requirements entry into a spec, an unpinned one resolves to the latest version
+func requirementSpec(name string, version string) (utils.InstallSpec, bool) {
+	parts := strings.Split(name, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return utils.InstallSpec{}, false
+	}
+	return utils.InstallSpec{Namespace: parts[0], Name: parts[1], Version: strings.TrimPrefix(version, "==")}, true
+}
+
+func keepSpecs(keep map[string]bool, kind string, specs []utils.InstallSpec) {
+	for _, s := range specs {
+		keep[kind+" "+s.Namespace+"."+s.Name+" "+s.Version] = true
+	}
+}
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/utils"
+)
+
+// writePruneRepo makes a repo that looks like a galaxy-sync mirror
+func writePruneRepo(t *testing.T) string {
+	repo := t.TempDir()
+	collections := filepath.Join(repo, "collections")
+	roles := filepath.Join(repo, "roles")
+	os.MkdirAll(collections, 0755)
+	os.MkdirAll(roles, 0755)
+
+	for _, v := range []string{"1.0.0", "2.0.0", "3.0.0"} {
+		writeTarGz(t, filepath.Join(collections, "ns-base-"+v+".tar.gz"), testCollectionFiles("ns", "base", v))
+	}
+	writeTarGz(t, filepath.Join(collections, "ns-app-1.0.0.tar.gz"), withDependencies(testCollectionFiles("ns", "app", "1.0.0"), map[string]string{"ns.base": "<2.0.0"}))
+	for _, v := range []string{"1.0.0", "2.0.0"} {
+		writeTarGz(t, filepath.Join(roles, "geer-java-"+v+".tar.gz"), map[string]string{"java/meta/main.yml": "galaxy_info:\n  author: geer\n"})
+	}
+	// galaxy-sync renamed geer.jdk to geer.java and left a link and sidecars under the old name
+	os.Symlink(filepath.Join(roles, "geer-java-1.0.0.tar.gz"), filepath.Join(roles, "geer-jdk-1.0.0.tar.gz"))
+	os.WriteFile(filepath.Join(roles, "geer-jdk-1.0.0.lock"), nil, 0644)
+	os.WriteFile(filepath.Join(roles, "geer-java-1.0.0.bad"), []byte("404\n"), 0644)
+	os.Symlink(filepath.Join(roles, "geer-gone-1.0.0.tar.gz"), filepath.Join(roles, "geer-gone-link-1.0.0.tar.gz"))
+
+	// everything but the 3.0.0 base is old
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	files, _ := filepath.Glob(filepath.Join(repo, "*", "*.tar.gz"))
+	for _, f := range files {
+		if filepath.Base(f) != "ns-base-3.0.0.tar.gz" {
+			os.Chtimes(f, old, old)
+		}
+	}
+	return repo
+}
+
+func TestPruneRepo(t *testing.T) {
+	requirements := filepath.Join(t.TempDir(), "requirements.yml")
+	os.WriteFile(requirements, []byte("collections:\n  - name: ns.base\n    version: 2.0.0\nroles:\n  - name: geer.java\n    version: ==1.0.0\n"), 0644)
+
+	tests := []struct {
+		name     string
+		opts     PruneOptions
+		expected []string
+		err      error
+	}{
+		{
+			"keep latest keeps dependencies",
+			PruneOptions{KeepLatest: 1},
+			[]string{
+				"collections/ns-base-2.0.0.tar.gz",
+				"roles/geer-gone-link-1.0.0.tar.gz",
+				"roles/geer-java-1.0.0.bad",
+				"roles/geer-java-1.0.0.tar.gz",
+				"roles/geer-jdk-1.0.0.lock",
+				"roles/geer-jdk-1.0.0.tar.gz",
+			},
+			nil,
+		},
+		{
+			"keep since",
+			PruneOptions{KeepSince: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+			[]string{
+				"collections/ns-app-1.0.0.tar.gz",
+				"collections/ns-base-1.0.0.tar.gz",
+				"collections/ns-base-2.0.0.tar.gz",
+				"roles/geer-gone-link-1.0.0.tar.gz",
+				"roles/geer-java-1.0.0.bad",
+				"roles/geer-java-1.0.0.tar.gz",
+				"roles/geer-java-2.0.0.tar.gz",
+				"roles/geer-jdk-1.0.0.lock",
+				"roles/geer-jdk-1.0.0.tar.gz",
+			},
+			nil,
+		},
+		{
+			"keep requirements",
+			PruneOptions{KeepLatest: 1, KeepRequirements: []string{requirements}},
+			[]string{"roles/geer-gone-link-1.0.0.tar.gz"},
+			nil,
+		},
+		{
+			"dry run",
+			PruneOptions{KeepLatest: 1, KeepRequirements: []string{requirements}, DryRun: true},
+			[]string{"roles/geer-gone-link-1.0.0.tar.gz"},
+			nil,
+		},
+		{
+			"no policy",
+			PruneOptions{},
+			nil,
+			laxerrors.ErrUsage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := writePruneRepo(t)
+			result, err := PruneRepo(repo, tt.opts)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := append([]string{}, result.Removed...)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("removed\n got %v\nwant %v", got, tt.expected)
+			}
+			for _, p := range tt.expected {
+				_, err := os.Lstat(filepath.Join(repo, filepath.FromSlash(p)))
+				if tt.opts.DryRun && err != nil {
+					t.Errorf("dry run removed %s", p)
+				}
+				if !tt.opts.DryRun && err == nil {
+					t.Errorf("%s is still there", p)
+				}
+			}
+			if !tt.opts.DryRun && !utils.IsFile(filepath.Join(repo, "repometa.json")) {
+				t.Error("the repo wasn't indexed again")
+			}
+		})
+	}
+}
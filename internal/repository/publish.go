@@ -0,0 +1,645 @@
+package repository
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/blang/semver/v4"
+	"github.com/jctanner/lax/internal/httpclient"
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// PublishOptions control how an artifact is added to a repo
+type PublishOptions struct {
+	// replace an artifact that is already in the repo
+	Force bool
+	// role metadata rarely says all of these, so they can be given
+	Namespace string
+	Name      string
+	Version   string
+}
+
+// PublishResult says where an artifact ended up
+type PublishResult struct {
+	Type      string `json:"type"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	// relative to the repo root
+	Path   string `json:"path"`
+	Sha256 string `json:"sha256"`
+}
+
+/*
+PublishToDir validates a collection or role artifact, copies it into the
+repo under its canonical <namespace>-<name>-<version>.tar.gz name, where
+the repo's layout puts it, and adds it to the existing index files
+instead of rebuilding them.
+*/
+func PublishToDir(repoDir string, artifact string, opts PublishOptions) (PublishResult, error) {
+	apath, err := utils.GetAbsPath(repoDir)
+	if err != nil {
+		return PublishResult{}, err
+	}
+	if !utils.IsDir(apath) {
+		return PublishResult{}, laxerrors.New(laxerrors.ErrNotFound, "%s is not a directory", apath)
+	}
+	if !utils.IsFile(artifact) {
+		return PublishResult{}, laxerrors.New(laxerrors.ErrNotFound, "%s does not exist", artifact)
+	}
+	layout, err := RepoLayout(apath)
+	if err != nil {
+		return PublishResult{}, err
+	}
+
+	sums, err := tarGzSha256s(artifact)
+	if err != nil {
+		return PublishResult{}, laxerrors.Wrap(laxerrors.ErrUsage, err, "%s is not a tar.gz file", artifact)
+	}
+
+	if _, ok := sums["MANIFEST.json"]; ok {
+		manifest, files, err := validateCollectionArtifact(artifact, sums)
+		if err != nil {
+			return PublishResult{}, err
+		}
+		info := manifest.CollectionInfo
+		result := PublishResult{Type: "collection", Namespace: info.Namespace, Name: info.Name, Version: info.Version, Sha256: manifest.Artifact.Sha256}
+		result.Path = ArtifactRelPath(layout, "collection", info.Namespace, info.Name, info.Version)
+		manifest.Artifact.Path = result.Path
+		placed, err := placeArtifact(apath, artifact, result.Path, opts.Force)
+		if err != nil {
+			return result, err
+		}
+		// an artifact the index doesn't list would make a retry conflict with it
+		if err := addCollectionToIndex(apath, layout, manifest, files); err != nil {
+			placed.rollback()
+			return result, err
+		}
+		placed.keep()
+		return result, nil
+	}
+
+	rmeta, files, err := validateRoleArtifact(artifact, sums, opts)
+	if err != nil {
+		return PublishResult{}, err
+	}
+	info := rmeta.GalaxyInfo
+	result := PublishResult{Type: "role", Namespace: info.Namespace, Name: info.RoleName, Version: info.Version, Sha256: rmeta.Artifact.Sha256}
+	result.Path = ArtifactRelPath(layout, "role", info.Namespace, info.RoleName, info.Version)
+	rmeta.Artifact.Path = result.Path
+	placed, err := placeArtifact(apath, artifact, result.Path, opts.Force)
+	if err != nil {
+		return result, err
+	}
+	if err := addRoleToIndex(apath, layout, rmeta, files); err != nil {
+		placed.rollback()
+		return result, err
+	}
+	placed.keep()
+	return result, nil
+}
+
+// tarGzSha256s hashes every regular file in a tarball by its cleaned name, symlinks get no hash
+func tarGzSha256s(tarGzPath string) (map[string]string, error) {
+	f, err := os.Open(tarGzPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	sums := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return sums, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(path.Clean(header.Name), "./")
+		// symlinks inside a collection are listed in FILES.json with their target's checksum
+		if header.Typeflag == tar.TypeSymlink {
+			sums[name] = ""
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return nil, err
+		}
+		sums[name] = hex.EncodeToString(h.Sum(nil))
+	}
+}
+
+// validateCollectionArtifact checks MANIFEST.json and FILES.json agree with the tarball
+func validateCollectionArtifact(artifact string, sums map[string]string) (CollectionManifest, []CollectionCachedFileInfo, error) {
+	fmap, err := utils.ExtractJSONFilesFromTarGz(artifact, []string{"MANIFEST.json", "FILES.json"})
+	if err != nil {
+		return CollectionManifest{}, nil, laxerrors.Wrap(laxerrors.ErrUsage, err, "%s", artifact)
+	}
+	if _, ok := fmap["FILES.json"]; !ok {
+		return CollectionManifest{}, nil, laxerrors.New(laxerrors.ErrUsage, "%s has a MANIFEST.json but no FILES.json", artifact)
+	}
+
+	var manifest struct {
+		CollectionInfo   CollectionInfo `json:"collection_info"`
+		FileManifestFile struct {
+			Name           string `json:"name"`
+			CheckSumSHA256 string `json:"chksum_sha256"`
+		} `json:"file_manifest_file"`
+	}
+	if err := json.Unmarshal(fmap["MANIFEST.json"], &manifest); err != nil {
+		return CollectionManifest{}, nil, laxerrors.Wrap(laxerrors.ErrUsage, err, "%s has an invalid MANIFEST.json", artifact)
+	}
+	info := manifest.CollectionInfo
+	if info.Namespace == "" || info.Name == "" {
+		return CollectionManifest{}, nil, laxerrors.New(laxerrors.ErrUsage, "%s MANIFEST.json has no namespace or name", artifact)
+	}
+	if _, err := semver.Parse(info.Version); err != nil {
+		return CollectionManifest{}, nil, laxerrors.Wrap(laxerrors.ErrUsage, err, "%s MANIFEST.json has an invalid version %q", artifact, info.Version)
+	}
+	if want := manifest.FileManifestFile.CheckSumSHA256; want != "" && want != sums["FILES.json"] {
+		return CollectionManifest{}, nil, laxerrors.New(laxerrors.ErrChecksumMismatch, "%s FILES.json does not match the checksum in MANIFEST.json", artifact)
+	}
+
+	var filesdata CollectionFilesMeta
+	if err := json.Unmarshal(fmap["FILES.json"], &filesdata); err != nil {
+		return CollectionManifest{}, nil, laxerrors.Wrap(laxerrors.ErrUsage, err, "%s has an invalid FILES.json", artifact)
+	}
+	listed := map[string]bool{"MANIFEST.json": true, "FILES.json": true}
+	for _, f := range filesdata.Files {
+		if f.FType != "file" {
+			continue
+		}
+		name := strings.TrimPrefix(path.Clean(f.Name), "./")
+		listed[name] = true
+		sum, ok := sums[name]
+		if !ok {
+			return CollectionManifest{}, nil, laxerrors.New(laxerrors.ErrUsage, "%s lists %s in FILES.json but it is not in the tarball", artifact, f.Name)
+		}
+		if sum != "" && sum != f.CheckSumSHA256 {
+			return CollectionManifest{}, nil, laxerrors.New(laxerrors.ErrChecksumMismatch, "%s: %s does not match its checksum in FILES.json", artifact, f.Name)
+		}
+	}
+	for name := range sums {
+		if !listed[name] {
+			return CollectionManifest{}, nil, laxerrors.New(laxerrors.ErrUsage, "%s contains %s which is not listed in FILES.json", artifact, name)
+		}
+	}
+
+	entry, files, err := collectionIndexEntry(artifact)
+	if err != nil {
+		return entry, nil, laxerrors.Wrap(laxerrors.ErrUsage, err, "%s", artifact)
+	}
+	return entry, files, nil
+}
+
+// the canonical artifact name, used to fill in what role metadata leaves out
+var canonicalTarName = regexp.MustCompile(`^[^-/]+-[^-/]+-\d+\.\d+\.\d+\.tar\.gz$`)
+
+// validateRoleArtifact checks meta/main.yml parses and works out the role's namespace, name and version
+func validateRoleArtifact(artifact string, sums map[string]string, opts PublishOptions) (types.RoleMeta, []RoleCachedFileInfo, error) {
+	hasMeta := false
+	for name := range sums {
+		if utils.EndsWithMetaMainYAML(name) {
+			hasMeta = true
+		}
+	}
+	if !hasMeta {
+		return types.RoleMeta{}, nil, laxerrors.New(laxerrors.ErrUsage, "%s is neither a collection (no MANIFEST.json) nor a role (no meta/main.yml)", artifact)
+	}
+
+	rmeta, err := GetRoleMetaFromTarball(artifact)
+	if err != nil {
+		return rmeta, nil, laxerrors.Wrap(laxerrors.ErrUsage, err, "%s has an invalid meta/main.yml", artifact)
+	}
+
+	info := &rmeta.GalaxyInfo
+	for _, field := range []struct {
+		value    *string
+		override string
+		fromName func(string) string
+	}{
+		{&info.Namespace, opts.Namespace, extractRoleNamespaceFromTarName},
+		{&info.RoleName, opts.Name, extractRoleNameFromTarName},
+		{&info.Version, opts.Version, extractRoleVersionFromTarName},
+	} {
+		if field.override != "" {
+			*field.value = field.override
+		}
+		if *field.value == "" && canonicalTarName.MatchString(filepath.Base(artifact)) {
+			*field.value = field.fromName(filepath.Base(artifact))
+		}
+	}
+	if info.Namespace == "" || info.RoleName == "" || info.Version == "" {
+		return rmeta, nil, laxerrors.New(laxerrors.ErrUsage, "%s doesn't say its namespace, name and version, use --namespace, --name and --version", artifact)
+	}
+	if _, err := semver.Parse(info.Version); err != nil {
+		return rmeta, nil, laxerrors.Wrap(laxerrors.ErrUsage, err, "%s has an invalid version %q", artifact, info.Version)
+	}
+
+	return roleIndexEntry(artifact, rmeta)
+}
+
+/*
+placeArtifact copies the artifact into the repo, via a .part file so it
+never appears half written. An artifact it replaces is kept next to it
+until keep or rollback says whether the index took the new one.
+*/
+func placeArtifact(apath string, artifact string, relPath string, force bool) (*placedArtifact, error) {
+	dest := filepath.Join(apath, filepath.FromSlash(relPath))
+	if utils.FileExists(dest) && !force {
+		return nil, laxerrors.New(laxerrors.ErrConflict, "%s is already in the repo, use --force to replace it", relPath)
+	}
+	if err := utils.MakeDirs(filepath.Dir(dest)); err != nil {
+		return nil, err
+	}
+	if err := utils.CopyFile(artifact, dest+utils.PartSuffix); err != nil {
+		return nil, err
+	}
+	placed := &placedArtifact{dest: dest}
+	if utils.FileExists(dest) {
+		placed.previous = dest + ".previous" + utils.PartSuffix
+		os.Remove(placed.previous)
+		if err := os.Link(dest, placed.previous); err != nil {
+			if err := utils.CopyFile(dest, placed.previous); err != nil {
+				os.Remove(dest + utils.PartSuffix)
+				return nil, err
+			}
+		}
+	}
+	logrus.Infof("publish %s -> %s", artifact, dest)
+	if err := os.Rename(dest+utils.PartSuffix, dest); err != nil {
+		placed.keep()
+		return nil, err
+	}
+	return placed, nil
+}
+
+// placedArtifact is an artifact placeArtifact put in the repo and what it replaced
+type placedArtifact struct {
+	dest     string
+	previous string
+}
+
+// keep drops the replaced artifact, the index has the new one
+func (p *placedArtifact) keep() {
+	if p.previous != "" {
+		os.Remove(p.previous)
+	}
+}
+
+// rollback puts back what was there before, the index couldn't take the new artifact
+func (p *placedArtifact) rollback() {
+	if p.previous != "" {
+		if err := os.Rename(p.previous, p.dest); err != nil {
+			logrus.Errorf("can't put back %s: %s", p.dest, err)
+		}
+		return
+	}
+	if err := os.Remove(p.dest); err != nil {
+		logrus.Errorf("can't remove %s: %s", p.dest, err)
+	}
+}
+
+/*
+addCollectionToIndex replaces or adds one collection in the existing
+index files. They are all written under .part names and only renamed
+into place once every write worked, so a failure leaves the index as it
+was. A repo whose repometa.json names an index file that is gone is
+indexed from scratch, starting from an empty list would drop every
+other version.
+*/
+func addCollectionToIndex(apath string, layout int, manifest CollectionManifest, files []CollectionCachedFileInfo) error {
+	if !utils.IsFile(filepath.Join(apath, "repometa.json")) {
+		return indexRepo(apath)
+	}
+	repoMeta, err := readRepoMeta(apath)
+	if err != nil {
+		return err
+	}
+	// the existing index is wherever repometa.json says, a promote names it after its content
+	manifestsPath := indexFilePath(apath, repoMeta.CollectionManifests, "collection_manifests.tar.gz")
+	filesPath := indexFilePath(apath, repoMeta.CollectionFiles, "collection_files.tar.gz")
+	if !utils.IsFile(manifestsPath) || !utils.IsFile(filesPath) {
+		logrus.Warnf("the collection index of %s is incomplete, indexing the whole repo", apath)
+		return indexRepo(apath)
+	}
+	info := manifest.CollectionInfo
+
+	existingManifests, err := ExtractCollectionManifestsFromTarGz(manifestsPath)
+	if err != nil {
+		return err
+	}
+	manifests := []CollectionManifest{}
+	for _, m := range existingManifests {
+		if m.CollectionInfo.Namespace != info.Namespace || m.CollectionInfo.Name != info.Name || m.CollectionInfo.Version != info.Version {
+			manifests = append(manifests, m)
+		}
+	}
+	manifests = append(manifests, manifest)
+
+	existingFiles, err := loadCachedCollectionFiles(filesPath)
+	if err != nil {
+		return err
+	}
+	allFiles := []CollectionCachedFileInfo{}
+	for _, f := range existingFiles {
+		if f.Namespace != info.Namespace || f.Name != info.Name || f.Version != info.Version {
+			allFiles = append(allFiles, f)
+		}
+	}
+	allFiles = append(allFiles, files...)
+
+	staged := &stagedIndex{}
+	if err := createCollectionManifestsTarGz(manifests, staged.path(filepath.Join(apath, "collection_manifests.tar.gz"))); err != nil {
+		staged.discard()
+		return err
+	}
+	if err := saveCachedCollectionFilesToGzippedFile(allFiles, staged.path(filepath.Join(apath, "collection_files.tar.gz")), 1000000); err != nil {
+		staged.discard()
+		return err
+	}
+	if err := staged.commit(); err != nil {
+		return err
+	}
+
+	// the role index stays what repometa.json named
+	now := time.Now().UTC().Format(time.RFC3339)
+	repoMeta.Date, repoMeta.Layout = now, layout
+	repoMeta.CollectionManifests = RepoMetaFile{Date: now, Filename: "collection_manifests.tar.gz"}
+	repoMeta.CollectionFiles = RepoMetaFile{Date: now, Filename: "collection_files.tar.gz"}
+	return saveRepoMeta(apath, repoMeta)
+}
+
+// addRoleToIndex replaces or adds one role in the existing index files, the way addCollectionToIndex does
+func addRoleToIndex(apath string, layout int, rmeta types.RoleMeta, files []RoleCachedFileInfo) error {
+	if !utils.IsFile(filepath.Join(apath, "repometa.json")) {
+		return indexRepo(apath)
+	}
+	repoMeta, err := readRepoMeta(apath)
+	if err != nil {
+		return err
+	}
+	manifestsPath := indexFilePath(apath, repoMeta.RoleManifests, "role_manifests.tar.gz")
+	filesPath := indexFilePath(apath, repoMeta.RoleFiles, "role_files.tar.gz")
+	if !utils.IsFile(manifestsPath) || !utils.IsFile(filesPath) {
+		logrus.Warnf("the role index of %s is incomplete, indexing the whole repo", apath)
+		return indexRepo(apath)
+	}
+	info := rmeta.GalaxyInfo
+
+	existingManifests, err := ExtractRoleManifestsFromTarGz(manifestsPath)
+	if err != nil {
+		return err
+	}
+	manifests := []types.RoleMeta{}
+	for _, m := range existingManifests {
+		if m.GalaxyInfo.Namespace != info.Namespace || m.GalaxyInfo.RoleName != info.RoleName || m.GalaxyInfo.Version != info.Version {
+			manifests = append(manifests, m)
+		}
+	}
+	manifests = append(manifests, rmeta)
+
+	existingFiles, err := loadCachedRoleFiles(filesPath)
+	if err != nil {
+		return err
+	}
+	allFiles := []RoleCachedFileInfo{}
+	for _, f := range existingFiles {
+		if f.Namespace != info.Namespace || f.Name != info.RoleName || f.Version != info.Version {
+			allFiles = append(allFiles, f)
+		}
+	}
+	allFiles = append(allFiles, files...)
+
+	staged := &stagedIndex{}
+	if err := createRoleMetaTarGz(manifests, staged.path(filepath.Join(apath, "role_manifests.tar.gz"))); err != nil {
+		staged.discard()
+		return err
+	}
+	if err := saveCachedRoleFilesToGzippedFile(allFiles, staged.path(filepath.Join(apath, "role_files.tar.gz")), 1000000); err != nil {
+		staged.discard()
+		return err
+	}
+	if err := staged.commit(); err != nil {
+		return err
+	}
+
+	// the collection index stays what repometa.json named
+	now := time.Now().UTC().Format(time.RFC3339)
+	repoMeta.Date, repoMeta.Layout = now, layout
+	repoMeta.RoleManifests = RepoMetaFile{Date: now, Filename: "role_manifests.tar.gz"}
+	repoMeta.RoleFiles = RepoMetaFile{Date: now, Filename: "role_files.tar.gz"}
+	return saveRepoMeta(apath, repoMeta)
+}
+
+// stagedIndex is a set of index files written under .part names
+type stagedIndex struct {
+	paths []string
+}
+
+// path is the .part name to write the index file at p to
+func (s *stagedIndex) path(p string) string {
+	s.paths = append(s.paths, p)
+	return p + utils.PartSuffix
+}
+
+// discard removes the .part files, a write failed
+func (s *stagedIndex) discard() {
+	for _, p := range s.paths {
+		os.Remove(p + utils.PartSuffix)
+	}
+}
+
+// commit renames every .part file into place
+func (s *stagedIndex) commit() error {
+	for _, p := range s.paths {
+		if err := os.Rename(p+utils.PartSuffix, p); err != nil {
+			s.discard()
+			return err
+		}
+	}
+	return nil
+}
+
+// indexFilePath is where repometa.json says an index file is, or where createrepo puts it when it doesn't say
+func indexFilePath(apath string, file RepoMetaFile, name string) string {
+	if file.Filename != "" {
+		return filepath.Join(apath, file.Filename)
+	}
+	return filepath.Join(apath, name)
+}
+
+// indexRepo builds the index from scratch for a repo that doesn't have one yet
+func indexRepo(apath string) error {
+	layout, err := RepoLayout(apath)
+	if err != nil {
+		return err
+	}
+	if _, err := processCollections(apath, filepath.Join(apath, "collections")); err != nil {
+		return err
+	}
+	if _, err := processRoles(apath, filepath.Join(apath, "roles")); err != nil {
+		return err
+	}
+	return writeRepoMeta(apath, layout)
+}
+
+// UploadPath is where lax serve accepts artifacts from lax publish
+const UploadPath = "/upload"
+
+/*
+Publish adds an artifact to the repo given with --repo. A directory is
+updated in place, a url is treated as a lax serve instance and the
+artifact is uploaded to it.
+*/
+func Publish(kwargs *types.CmdKwargs, args []string) error {
+	if len(args) != 1 {
+		return laxerrors.New(laxerrors.ErrUsage, "publish takes exactly one artifact")
+	}
+	if kwargs.Server == "" {
+		return laxerrors.New(laxerrors.ErrUsage, "--repo is required")
+	}
+	opts := PublishOptions{
+		Force:     kwargs.Force,
+		Namespace: kwargs.Namespace,
+		Name:      kwargs.Name,
+		Version:   kwargs.Version,
+	}
+
+	var result PublishResult
+	var err error
+	if utils.IsURL(kwargs.Server) {
+		result, err = upload(kwargs.Server, args[0], opts)
+	} else {
+		result, err = PublishToDir(utils.ExpandUser(kwargs.Server), args[0], opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch kwargs.OutputFormat {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case "", "text":
+		fmt.Fprintf(os.Stdout, "published %s %s.%s %s as %s\n", result.Type, result.Namespace, result.Name, result.Version, result.Path)
+	default:
+		return laxerrors.New(laxerrors.ErrUsage, "unknown output format %s", kwargs.OutputFormat)
+	}
+	return nil
+}
+
+// upload posts an artifact to a lax serve instance's upload endpoint
+func upload(repoURL string, artifact string, opts PublishOptions) (PublishResult, error) {
+	info, err := os.Stat(artifact)
+	if err != nil {
+		return PublishResult{}, laxerrors.Wrap(laxerrors.ErrNotFound, err, "%s does not exist", artifact)
+	}
+
+	// keep user:password@ out of the logs, the shared client sends it instead
+	baseURL, cred := httpclient.SplitUserinfo(repoURL)
+	if cred != nil {
+		httpclient.Default().AddCredential(*cred)
+	}
+	query := url.Values{}
+	if opts.Force {
+		query.Set("force", "true")
+	}
+	for key, value := range map[string]string{"namespace": opts.Namespace, "name": opts.Name, "version": opts.Version} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	uploadURL := strings.TrimSuffix(baseURL, "/") + UploadPath
+	if len(query) > 0 {
+		uploadURL += "?" + query.Encode()
+	}
+
+	body, err := os.Open(artifact)
+	if err != nil {
+		return PublishResult{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, uploadURL, body)
+	if err != nil {
+		body.Close()
+		return PublishResult{}, laxerrors.Wrap(laxerrors.ErrUsage, err, "invalid repo url %s", baseURL)
+	}
+	// no GetBody, so the client doesn't retry: a server that stored the
+	// artifact and then failed would answer a resend with a conflict
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("X-Lax-Filename", filepath.Base(artifact))
+
+	logrus.Infof("uploading %s to %s", artifact, uploadURL)
+	resp, err := httpclient.Default().Do(req)
+	if err != nil {
+		return PublishResult{}, laxerrors.Wrap(laxerrors.ErrDownloadFailed, err, "upload to %s failed", baseURL)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return PublishResult{}, laxerrors.Wrap(laxerrors.ErrDownloadFailed, err, "upload to %s failed", baseURL)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		message := strings.TrimSpace(string(data))
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &failure) == nil && failure.Error != "" {
+			message = failure.Error
+		}
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+			message = "the server does not accept uploads, start lax serve with --upload-token-file"
+		}
+		return PublishResult{}, laxerrors.New(uploadErrorKind(resp.StatusCode), "upload to %s returned %s: %s", baseURL, resp.Status, message)
+	}
+
+	var result PublishResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return result, laxerrors.Wrap(laxerrors.ErrDownloadFailed, err, "unexpected response from %s", baseURL)
+	}
+	return result, nil
+}
+
+// uploadErrorKind maps an upload response back to the error lax serve started from
+func uploadErrorKind(status int) error {
+	switch status {
+	case http.StatusConflict:
+		return laxerrors.ErrConflict
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusNotFound, http.StatusMethodNotAllowed:
+		return laxerrors.ErrUsage
+	case http.StatusUnprocessableEntity:
+		return laxerrors.ErrChecksumMismatch
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return laxerrors.ErrAuthFailed
+	}
+	return laxerrors.ErrDownloadFailed
+}
@@ -0,0 +1,250 @@
+package repository
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jctanner/lax/internal/httpclient"
+	"github.com/jctanner/lax/internal/laxerrors"
+)
+
+// writeTarGz writes files (name -> content) into a tarball
+func writeTarGz(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	f.Close()
+}
+
+func sha256String(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// testCollectionFiles is the content of a minimal but consistent collection artifact
+func testCollectionFiles(namespace string, name string, version string) map[string]string {
+	readme := "# " + name
+	filesJSON, _ := json.Marshal(map[string]interface{}{
+		"files": []map[string]interface{}{
+			{"name": ".", "ftype": "dir"},
+			{"name": "README.md", "ftype": "file", "chksum_type": "sha256", "chksum_sha256": sha256String(readme)},
+		},
+		"format": 1,
+	})
+	manifestJSON, _ := json.Marshal(map[string]interface{}{
+		"collection_info": map[string]interface{}{
+			"namespace": namespace, "name": name, "version": version,
+			"dependencies": map[string]string{},
+		},
+		"file_manifest_file": map[string]interface{}{
+			"name": "FILES.json", "ftype": "file", "chksum_type": "sha256",
+			"chksum_sha256": sha256String(string(filesJSON)),
+		},
+		"format": 1,
+	})
+	return map[string]string{
+		"MANIFEST.json": string(manifestJSON),
+		"FILES.json":    string(filesJSON),
+		"README.md":     readme,
+	}
+}
+
+func TestPublishToDir(t *testing.T) {
+	src := t.TempDir()
+	collection := func(version string, change func(map[string]string)) string {
+		files := testCollectionFiles("ns", "name", version)
+		if change != nil {
+			change(files)
+		}
+		path := filepath.Join(src, fmt.Sprintf("build-%s-%d.tar.gz", version, len(files)))
+		writeTarGz(t, path, files)
+		return path
+	}
+	role := func(filename string, meta string) string {
+		path := filepath.Join(src, filename)
+		writeTarGz(t, path, map[string]string{
+			"myrole/meta/main.yml":  meta,
+			"myrole/tasks/main.yml": "- debug: msg=hi\n",
+		})
+		return path
+	}
+
+	tests := []struct {
+		name     string
+		artifact string
+		opts     PublishOptions
+		expected string
+		err      error
+	}{
+		{"collection", collection("1.0.0", nil), PublishOptions{}, "collections/ns-name-1.0.0.tar.gz", nil},
+		{"second version", collection("1.1.0", nil), PublishOptions{}, "collections/ns-name-1.1.0.tar.gz", nil},
+		{"existing version", collection("1.0.0", nil), PublishOptions{}, "", laxerrors.ErrConflict},
+		{"existing version with force", collection("1.0.0", nil), PublishOptions{Force: true}, "collections/ns-name-1.0.0.tar.gz", nil},
+		{"tampered file", collection("2.0.0", func(f map[string]string) { f["README.md"] = "changed" }), PublishOptions{}, "", laxerrors.ErrChecksumMismatch},
+		{"unlisted file", collection("2.0.0", func(f map[string]string) { f["extra.py"] = "" }), PublishOptions{}, "", laxerrors.ErrUsage},
+		{"missing FILES.json", collection("2.0.0", func(f map[string]string) { delete(f, "FILES.json") }), PublishOptions{}, "", laxerrors.ErrUsage},
+		{"role named from metadata", role("docker.tar.gz", "galaxy_info:\n  namespace: geer\n  role_name: docker\n  version: 1.0.0\n"), PublishOptions{}, "roles/geer-docker-1.0.0.tar.gz", nil},
+		{"role named from tarball", role("geer-java-2.0.0.tar.gz", "galaxy_info:\n  author: geer\n"), PublishOptions{}, "roles/geer-java-2.0.0.tar.gz", nil},
+		{"role named from options", role("nginx.tar.gz", "galaxy_info:\n  author: geer\n"), PublishOptions{Namespace: "geer", Name: "nginx", Version: "3.0.0"}, "roles/geer-nginx-3.0.0.tar.gz", nil},
+		{"role without a name", role("unnamed.tar.gz", "galaxy_info:\n  author: geer\n"), PublishOptions{}, "", laxerrors.ErrUsage},
+	}
+
+	repo := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := PublishToDir(repo, tt.artifact, tt.opts)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Path != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, result.Path)
+			}
+			if _, err := os.Stat(filepath.Join(repo, result.Path)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	// every publish after the first only added to the index
+	manifests, err := ExtractCollectionManifestsFromTarGz(filepath.Join(repo, "collection_manifests.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 2 {
+		t.Errorf("expected 2 collection versions in the index, got %d", len(manifests))
+	}
+	roles, err := ExtractRoleManifestsFromTarGz(filepath.Join(repo, "role_manifests.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 3 {
+		t.Errorf("expected 3 roles in the index, got %d", len(roles))
+	}
+	files, err := loadCachedCollectionFiles(filepath.Join(repo, "collection_files.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 {
+		t.Errorf("expected the 2 FILES.json entries of each collection version, got %d", len(files))
+	}
+}
+
+func TestPublishToDirIndexFails(t *testing.T) {
+	repo := t.TempDir()
+	first := filepath.Join(t.TempDir(), "first.tar.gz")
+	writeTarGz(t, first, testCollectionFiles("ns", "name", "1.0.0"))
+	if _, err := PublishToDir(repo, first, PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	artifactPath := filepath.Join(repo, "collections", "ns-name-1.0.0.tar.gz")
+	original, err := os.ReadFile(artifactPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexed := func() int {
+		manifests, err := ExtractCollectionManifestsFromTarGz(filepath.Join(repo, "collection_manifests.tar.gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(manifests)
+	}
+
+	// the second index file can't be written, the first one can
+	blocked := filepath.Join(repo, "collection_files.tar.gz.part")
+	if err := os.MkdirAll(filepath.Join(blocked, "in-the-way"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	second := filepath.Join(t.TempDir(), "second.tar.gz")
+	writeTarGz(t, second, testCollectionFiles("ns", "name", "1.1.0"))
+	if _, err := PublishToDir(repo, second, PublishOptions{}); err == nil {
+		t.Fatal("expected the publish to fail")
+	}
+	if _, err := os.Stat(filepath.Join(repo, "collections", "ns-name-1.1.0.tar.gz")); err == nil {
+		t.Error("the unindexed artifact was left in the repo")
+	}
+	if n := indexed(); n != 1 {
+		t.Errorf("expected the index to be left as it was, it lists %d versions", n)
+	}
+
+	replacement := filepath.Join(t.TempDir(), "replacement.tar.gz")
+	writeTarGz(t, replacement, withDependencies(testCollectionFiles("ns", "name", "1.0.0"), map[string]string{"ns.other": "*"}))
+	if _, err := PublishToDir(repo, replacement, PublishOptions{Force: true}); err == nil {
+		t.Fatal("expected the publish to fail")
+	}
+	got, err := os.ReadFile(artifactPath)
+	if err != nil || string(got) != string(original) {
+		t.Errorf("the replaced artifact wasn't put back: %v", err)
+	}
+
+	// an index file repometa.json names going missing means indexing the whole repo
+	if err := os.RemoveAll(blocked); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(repo, "collection_manifests.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PublishToDir(repo, second, PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := indexed(); n != 2 {
+		t.Errorf("expected 1.0.0 to still be indexed next to 1.1.0, the index lists %d versions", n)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(repo, "*", "*.part")); len(leftovers) > 0 {
+		t.Errorf("left behind %v", leftovers)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(repo, "*.part")); len(leftovers) > 0 {
+		t.Errorf("left behind %v", leftovers)
+	}
+}
+
+func TestUploadIsNotRetried(t *testing.T) {
+	defer httpclient.Configure(httpclient.DefaultConfig())
+	config := httpclient.DefaultConfig()
+	config.BackoffBase = time.Millisecond
+	if err := httpclient.Configure(config); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "stored it, then broke", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	artifact := filepath.Join(t.TempDir(), "ns-base-1.0.0.tar.gz")
+	writeTarGz(t, artifact, testCollectionFiles("ns", "base", "1.0.0"))
+	if _, err := upload(server.URL, artifact, PublishOptions{}); err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+	if attempts != 1 {
+		t.Errorf("the upload was sent %d times", attempts)
+	}
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"fmt"
 	"io"
+	"path"
 	"sort"
 	"strings"
 
@@ -312,6 +313,62 @@ func saveCachedRoleFilesToGzippedFile(manifests []RoleCachedFileInfo, filePath s
 	return nil
 }
 
+// loadCachedCollectionFiles reads back the gob chunks written by saveCachedCollectionFilesToGzippedFile
+func loadCachedCollectionFiles(filePath string) ([]CollectionCachedFileInfo, error) {
+	inFile, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer inFile.Close()
+
+	gzipReader, err := gzip.NewReader(inFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	defer gzipReader.Close()
+
+	files := []CollectionCachedFileInfo{}
+	decoder := gob.NewDecoder(gzipReader)
+	for {
+		var chunk []CollectionCachedFileInfo
+		if err := decoder.Decode(&chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode chunk: %w", err)
+		}
+		files = append(files, chunk...)
+	}
+	return files, nil
+}
+
+// loadCachedRoleFiles reads back the gob chunks written by saveCachedRoleFilesToGzippedFile
+func loadCachedRoleFiles(filePath string) ([]RoleCachedFileInfo, error) {
+	inFile, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer inFile.Close()
+
+	gzipReader, err := gzip.NewReader(inFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	defer gzipReader.Close()
+
+	files := []RoleCachedFileInfo{}
+	decoder := gob.NewDecoder(gzipReader)
+	for {
+		var chunk []RoleCachedFileInfo
+		if err := decoder.Decode(&chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode chunk: %w", err)
+		}
+		files = append(files, chunk...)
+	}
+	return files, nil
+}
+
 func SortManifestsByVersion(manifests []CollectionManifest) ([]CollectionManifest, error) {
 	// Define a custom type for sorting
 	type semverManifest struct {
@@ -374,6 +431,31 @@ func SortRoleManifestsByVersion(manifests []types.RoleMeta) ([]types.RoleMeta, e
 	return sortedManifests, nil
 }
 
+// SortManifestsNewestFirst orders manifests by descending version, falling
+// back to the index order if any of the versions are not valid semver
+func SortManifestsNewestFirst(manifests []CollectionManifest) []CollectionManifest {
+	sorted, err := SortManifestsByVersion(manifests)
+	if err != nil {
+		return manifests
+	}
+	for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+		sorted[i], sorted[j] = sorted[j], sorted[i]
+	}
+	return sorted
+}
+
+// SortRoleManifestsNewestFirst is SortManifestsNewestFirst for roles
+func SortRoleManifestsNewestFirst(manifests []types.RoleMeta) []types.RoleMeta {
+	sorted, err := SortRoleManifestsByVersion(manifests)
+	if err != nil {
+		return manifests
+	}
+	for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+		sorted[i], sorted[j] = sorted[j], sorted[i]
+	}
+	return sorted
+}
+
 func SortInstallSpecs(specs *[]utils.InstallSpec) {
 	sort.Slice(*specs, func(i, j int) bool {
 		if (*specs)[i].Namespace != (*specs)[j].Namespace {
@@ -386,42 +468,60 @@ func SortInstallSpecs(specs *[]utils.InstallSpec) {
 	})
 }
 
-/*
-func displayLinedYaml(rawyaml string) {
-	fmt.Printf("===========================\n")
-	lines := strings.Split(rawyaml, "\n")
-	for ix, line := range lines {
-		fmt.Printf("%d:%s\n", ix+1, line)
-	}
-}
-*/
-
 func displayLinedYaml(text string) {
-	fmt.Printf("===========================\n")
+	if !logrus.IsLevelEnabled(logrus.DebugLevel) {
+		return
+	}
 	lines := strings.Split(text, "\n")
 	for i, line := range lines {
-		fmt.Printf("%3d: %q\n", i+1, line)
+		logrus.Debugf("%3d: %q", i+1, line)
 	}
 }
 
 func GetRoleMetaFromTarball(f string) (types.RoleMeta, error) {
+	meta, _, err := readRoleMetaFromTarball(f)
+	return meta, err
+}
+
+// roleMetaSource says which files of a role tarball its meta came from and how cleanly it parsed
+type roleMetaSource struct {
+	MetaFile      string
+	BuildInfoFile string
+	// set when meta/main.yml only parsed after FixRoleMetaMainYaml
+	ParseErr error
+}
+
+func readRoleMetaFromTarball(f string) (types.RoleMeta, roleMetaSource, error) {
 
 	var meta types.RoleMeta
+	var src roleMetaSource
 
 	tarFileNames, _ := utils.ListFilenamesInTarGz(f)
 
+	// roles built by lax say who they are, and which meta/main.yml is theirs
+	buildInfoFile := ""
+	for _, tfn := range tarFileNames {
+		if strings.Count(tfn, "/") == 2 && strings.HasSuffix(tfn, "/"+types.RoleBuildInfoFile) {
+			buildInfoFile = tfn
+			break
+		}
+	}
+
 	metaFile := ""
 	for _, tfn := range tarFileNames {
-		if utils.EndsWithMetaMainYAML(tfn) {
+		if utils.EndsWithMetaMainYAML(tfn) && (buildInfoFile == "" || path.Dir(tfn) == path.Dir(buildInfoFile)) {
 			metaFile = tfn
 			break
 		}
 	}
 
-	fmap, err := utils.ExtractFilesFromTarGz(f, []string{metaFile})
+	src.MetaFile = metaFile
+	src.BuildInfoFile = buildInfoFile
+
+	fmap, err := utils.ExtractFilesFromTarGz(f, []string{metaFile, buildInfoFile})
 	if err != nil {
-		fmt.Printf("ERROR extracting %s\n", err)
-		return meta, err
+		logrus.Errorf("error extracting %s", err)
+		return meta, src, err
 	}
 
 	//fmt.Printf("raw:\n%s\n", fmap[metaFile])
@@ -429,7 +529,6 @@ func GetRoleMetaFromTarball(f string) (types.RoleMeta, error) {
 	err = yaml.Unmarshal(fmap[metaFile], &meta)
 
 	if err != nil {
-		fmt.Printf("ERROR_0 %s %s %s\n", f, metaFile, err)
 		rawstring := string(fmap[metaFile])
 		/*
 			lines := strings.Split(rawstring, "\n")
@@ -443,22 +542,44 @@ func GetRoleMetaFromTarball(f string) (types.RoleMeta, error) {
 
 	if err != nil {
 
-		fmt.Printf("FIXING YAML IN MEMORY...\n")
+		logrus.Debugf("fixing %s %s in memory", f, metaFile)
 		rawstring := string(fmap[metaFile])
 		fixed := utils.FixRoleMetaMainYaml(rawstring)
 		displayLinedYaml(fixed)
 		//panic("how does it look?")
 
+		src.ParseErr = err
 		var meta2 types.RoleMeta
 		err = yaml.Unmarshal([]byte(fixed), &meta2)
-		if err == nil {
-			return meta2, nil
-		} else {
-			return meta2, err
+		if err != nil {
+			return meta2, src, err
 		}
+		meta = meta2
 	}
 
-	return meta, nil
+	if buildInfoFile != "" {
+		applyRoleBuildInfo(&meta, fmap[buildInfoFile])
+	}
+
+	return meta, src, nil
+}
+
+// applyRoleBuildInfo replaces the namespace, name and version with the ones lax role build recorded
+func applyRoleBuildInfo(meta *types.RoleMeta, data []byte) {
+	var info types.RoleBuildInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		logrus.Warnf("ignoring invalid %s: %s", types.RoleBuildInfoFile, err)
+		return
+	}
+	if info.Namespace != "" {
+		meta.GalaxyInfo.Namespace = info.Namespace
+	}
+	if info.Name != "" {
+		meta.GalaxyInfo.RoleName = info.Name
+	}
+	if info.Version != "" {
+		meta.GalaxyInfo.Version = info.Version
+	}
 }
 
 /*
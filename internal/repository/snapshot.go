@@ -0,0 +1,421 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// SnapshotsDir is where lax repo snapshot puts snapshots, relative to the repo root
+const SnapshotsDir = "snapshots"
+
+// labels become a path under the repo, so no separators and no dotfiles
+var snapshotLabelPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// SnapshotResult says what a snapshot or promotion contains
+type SnapshotResult struct {
+	Path        string `json:"path"`
+	Collections int    `json:"collections"`
+	Roles       int    `json:"roles"`
+	// artifacts a snapshot hardlinked, or a promotion added
+	Linked int `json:"linked"`
+}
+
+// Snapshot freezes the index of the repo in args[0] under snapshots/<--name>
+func Snapshot(kwargs *types.CmdKwargs, args []string) error {
+	if len(args) != 1 {
+		return laxerrors.New(laxerrors.ErrUsage, "snapshot takes one repo directory")
+	}
+	result, err := SnapshotRepo(args[0], kwargs.Name)
+	if err != nil {
+		return err
+	}
+	return printSnapshotResult(kwargs.OutputFormat, result, "Created snapshot of %d collections and %d roles at %s\n", result.Collections, result.Roles, result.Path)
+}
+
+// Promote publishes the snapshot in --from to the repo in --to
+func Promote(kwargs *types.CmdKwargs, args []string) error {
+	if len(args) != 0 {
+		return laxerrors.New(laxerrors.ErrUsage, "promote takes --from and --to, not arguments")
+	}
+	if kwargs.From == "" || kwargs.DestDir == "" {
+		return laxerrors.New(laxerrors.ErrUsage, "promote needs --from and --to")
+	}
+	result, err := PromoteSnapshot(kwargs.From, kwargs.DestDir, kwargs.Force)
+	if err != nil {
+		return err
+	}
+	return printSnapshotResult(kwargs.OutputFormat, result, "Promoted %d collections and %d roles to %s, %d artifacts were new\n", result.Collections, result.Roles, result.Path, result.Linked)
+}
+
+// printSnapshotResult prints a snapshot or promotion as json, or as the text format
+func printSnapshotResult(outputFormat string, result SnapshotResult, format string, args ...interface{}) error {
+	switch outputFormat {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case "", "text":
+		fmt.Fprintf(os.Stdout, format, args...)
+		return nil
+	default:
+		return laxerrors.New(laxerrors.ErrUsage, "unknown output format %s", outputFormat)
+	}
+}
+
+/*
+SnapshotRepo copies repometa.json and the index files it names into
+snapshots/<label>/ of repoDir. The artifacts the index lists are
+hardlinked next to them, or symlinked when the filesystem can't
+hardlink, so a snapshot costs almost no space. The snapshot is a repo
+in its own right and clients can use <base>/snapshots/<label> as their
+repo. It is built under a dotfile and renamed into place, so a half
+written snapshot is never served.
+*/
+func SnapshotRepo(repoDir string, label string) (SnapshotResult, error) {
+	if !snapshotLabelPattern.MatchString(label) {
+		return SnapshotResult{}, laxerrors.New(laxerrors.ErrUsage, "%q is not a valid snapshot name, use letters, digits, '.', '_' and '-'", label)
+	}
+	apath, err := utils.GetAbsPath(utils.ExpandUser(repoDir))
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+	repoMeta, err := readRepoMeta(apath)
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+
+	dest := filepath.Join(apath, SnapshotsDir, label)
+	if utils.FileExists(dest) {
+		return SnapshotResult{}, laxerrors.New(laxerrors.ErrConflict, "snapshot %s already exists", label)
+	}
+	partDir := filepath.Join(apath, SnapshotsDir, "."+label+utils.PartSuffix)
+	if err := os.RemoveAll(partDir); err != nil {
+		return SnapshotResult{}, err
+	}
+	if err := utils.MakeDirs(partDir); err != nil {
+		return SnapshotResult{}, err
+	}
+
+	result, err := snapshotInto(apath, partDir, repoMeta)
+	if err != nil {
+		os.RemoveAll(partDir)
+		return result, err
+	}
+	if err := os.Rename(partDir, dest); err != nil {
+		os.RemoveAll(partDir)
+		return result, err
+	}
+	result.Path = dest
+	return result, nil
+}
+
+func snapshotInto(apath string, partDir string, repoMeta RepoMeta) (SnapshotResult, error) {
+	result := SnapshotResult{}
+	for _, fn := range append(repoMetaIndexFiles(repoMeta), "repometa.json") {
+		if err := utils.CopyFile(filepath.Join(apath, fn), filepath.Join(partDir, fn)); err != nil {
+			return result, err
+		}
+	}
+
+	artifacts, collections, roles, err := indexedArtifacts(apath, repoMeta)
+	if err != nil {
+		return result, err
+	}
+	result.Collections, result.Roles = collections, roles
+
+	for _, relPath := range artifacts {
+		dst := filepath.Join(partDir, filepath.FromSlash(relPath))
+		// a snapshot of a snapshot links what the symlinks point at
+		src, err := filepath.EvalSymlinks(filepath.Join(apath, filepath.FromSlash(relPath)))
+		if err != nil || !utils.IsFile(src) {
+			logrus.Warnf("%s is in the index but not in the repo, it can't be installed from the snapshot either", relPath)
+			continue
+		}
+		if err := utils.MakeDirs(filepath.Dir(dst)); err != nil {
+			return result, err
+		}
+		if err := os.Link(src, dst); err == nil {
+			result.Linked++
+			continue
+		}
+		// the part dir sits at the same depth as the snapshot it is renamed to
+		target, err := filepath.Rel(filepath.Dir(dst), src)
+		if err != nil {
+			return result, err
+		}
+		logrus.Debugf("can't hardlink %s, symlinking it to %s", relPath, target)
+		if err := os.Symlink(target, dst); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+/*
+PromoteSnapshot publishes the repo in snapshotDir, usually a snapshot,
+to repoDir. Clients never see a state that doesn't install: the
+artifacts go in first, each under a part name that is renamed into
+place, then the index files under names of their own that nothing
+serves yet. Renaming a repometa.json that names them into place is the
+one switch, so a client gets either the old index or the new one and
+never a mix. The index files of the repometa.json before are kept for
+clients still reading them. Artifacts repoDir has that the snapshot
+doesn't are left where they are for the same reason. An artifact
+repoDir already has with different content is a conflict unless force
+is set.
+*/
+func PromoteSnapshot(snapshotDir string, repoDir string, force bool) (SnapshotResult, error) {
+	from, err := utils.GetAbsPath(utils.ExpandUser(snapshotDir))
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+	to, err := utils.GetAbsPath(utils.ExpandUser(repoDir))
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+	repoMeta, err := readRepoMeta(from)
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+	if err := utils.MakeDirs(to); err != nil {
+		return SnapshotResult{}, err
+	}
+
+	artifacts, collections, roles, err := indexedArtifacts(from, repoMeta)
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+	result := SnapshotResult{Path: to, Collections: collections, Roles: roles}
+
+	// check everything before changing anything
+	toPlace := []string{}
+	for _, relPath := range artifacts {
+		src := filepath.Join(from, filepath.FromSlash(relPath))
+		dst := filepath.Join(to, filepath.FromSlash(relPath))
+		if !utils.IsFile(src) {
+			logrus.Warnf("%s is in the index but not in %s, it can't be installed from %s either", relPath, from, to)
+			continue
+		}
+		if !utils.IsFile(dst) {
+			toPlace = append(toPlace, relPath)
+			continue
+		}
+		same, err := sameContent(src, dst)
+		if err != nil {
+			return result, err
+		}
+		if !same {
+			if !force {
+				return result, laxerrors.New(laxerrors.ErrConflict, "%s is already in %s with different content, use --force to replace it", relPath, to)
+			}
+			toPlace = append(toPlace, relPath)
+		}
+	}
+
+	for _, relPath := range toPlace {
+		src := filepath.Join(from, filepath.FromSlash(relPath))
+		dst := filepath.Join(to, filepath.FromSlash(relPath))
+		logrus.Infof("promote %s", relPath)
+		if err := linkOrCopy(src, dst); err != nil {
+			return result, err
+		}
+		result.Linked++
+	}
+
+	// the artifacts are where the snapshot's index says, so the repo has its layout now
+	now := time.Now().UTC().Format(time.RFC3339)
+	promoted := RepoMeta{Date: now, Layout: repoMeta.Layout}
+	indexFiles := []struct {
+		from string
+		kind string
+		to   *RepoMetaFile
+	}{
+		{repoMeta.CollectionManifests.Filename, "collection_manifests", &promoted.CollectionManifests},
+		{repoMeta.CollectionFiles.Filename, "collection_files", &promoted.CollectionFiles},
+		{repoMeta.RoleManifests.Filename, "role_manifests", &promoted.RoleManifests},
+		{repoMeta.RoleFiles.Filename, "role_files", &promoted.RoleFiles},
+	}
+	for _, index := range indexFiles {
+		src := filepath.Join(from, index.from)
+		if index.from == "" || !utils.IsFile(src) {
+			continue
+		}
+		name, err := promotedIndexName(index.kind, src)
+		if err != nil {
+			return result, err
+		}
+		dst := filepath.Join(to, name)
+		if err := utils.CopyFile(src, dst+utils.PartSuffix); err != nil {
+			return result, err
+		}
+		if err := os.Rename(dst+utils.PartSuffix, dst); err != nil {
+			return result, err
+		}
+		*index.to = RepoMetaFile{Date: now, Filename: name}
+	}
+
+	previous, err := readRepoMeta(to)
+	if err != nil {
+		previous = RepoMeta{}
+	}
+	if err := saveRepoMeta(to, promoted); err != nil {
+		return result, err
+	}
+	removeStaleIndexFiles(to, promoted, previous)
+	return result, nil
+}
+
+// promoted index files are named after their content, e.g. collection_manifests-0123456789ab.tar.gz
+var promotedIndexPattern = regexp.MustCompile(`^(collection|role)_(manifests|files)-[0-9a-f]{12}\.tar\.gz$`)
+
+// promotedIndexName is the name a promoted index file gets, a name no other index uses unless it has the same content
+func promotedIndexName(kind string, src string) (string, error) {
+	sum, _, err := utils.Sha256File(src)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%s.tar.gz", kind, sum[:12]), nil
+}
+
+// removeStaleIndexFiles removes the promoted index files neither the current nor the previous repometa.json names
+func removeStaleIndexFiles(apath string, current RepoMeta, previous RepoMeta) {
+	keep := map[string]bool{}
+	for _, fn := range append(repoMetaIndexFiles(current), repoMetaIndexFiles(previous)...) {
+		keep[fn] = true
+	}
+	entries, err := os.ReadDir(apath)
+	if err != nil {
+		logrus.Warnf("can't list %s: %s", apath, err)
+		return
+	}
+	for _, entry := range entries {
+		if keep[entry.Name()] || !promotedIndexPattern.MatchString(entry.Name()) {
+			continue
+		}
+		logrus.Debugf("remove stale index file %s", entry.Name())
+		if err := os.Remove(filepath.Join(apath, entry.Name())); err != nil {
+			logrus.Warnf("can't remove %s: %s", entry.Name(), err)
+		}
+	}
+}
+
+// readRepoMeta parses the repometa.json of a repo directory
+func readRepoMeta(apath string) (RepoMeta, error) {
+	var repoMeta RepoMeta
+	data, err := os.ReadFile(filepath.Join(apath, "repometa.json"))
+	if err != nil {
+		return repoMeta, laxerrors.Wrap(laxerrors.ErrNotFound, err, "%s is not a lax repo", apath)
+	}
+	if err := json.Unmarshal(data, &repoMeta); err != nil {
+		return repoMeta, fmt.Errorf("failed to unmarshal %s: %w", filepath.Join(apath, "repometa.json"), err)
+	}
+	return repoMeta, nil
+}
+
+// repoMetaIndexFiles lists the index files repometa.json names that exist, a repo without roles or collections won't have all of them
+func repoMetaIndexFiles(repoMeta RepoMeta) []string {
+	files := []string{}
+	for _, fn := range []string{
+		repoMeta.CollectionManifests.Filename,
+		repoMeta.CollectionFiles.Filename,
+		repoMeta.RoleManifests.Filename,
+		repoMeta.RoleFiles.Filename,
+	} {
+		if fn != "" {
+			files = append(files, fn)
+		}
+	}
+	return files
+}
+
+// indexedArtifacts lists the artifacts the index of a repo dir lists, where clients will download them from
+func indexedArtifacts(apath string, repoMeta RepoMeta) ([]string, int, int, error) {
+	artifacts := []string{}
+	seen := map[string]bool{}
+	add := func(relPath string) {
+		if !seen[relPath] {
+			seen[relPath] = true
+			artifacts = append(artifacts, relPath)
+		}
+	}
+	collections, roles := 0, 0
+	if fn := repoMeta.CollectionManifests.Filename; fn != "" && utils.IsFile(filepath.Join(apath, fn)) {
+		manifests, err := ExtractCollectionManifestsFromTarGz(filepath.Join(apath, fn))
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		for _, m := range manifests {
+			info := m.CollectionInfo
+			add(indexedRelPath(m.Artifact, "collection", info.Namespace, info.Name, info.Version))
+		}
+		collections = len(manifests)
+	}
+	if fn := repoMeta.RoleManifests.Filename; fn != "" && utils.IsFile(filepath.Join(apath, fn)) {
+		manifests, err := ExtractRoleManifestsFromTarGz(filepath.Join(apath, fn))
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		for _, m := range manifests {
+			info := m.GalaxyInfo
+			add(indexedRelPath(m.Artifact, "role", info.Namespace, info.RoleName, info.Version))
+		}
+		roles = len(manifests)
+	}
+	return artifacts, collections, roles, nil
+}
+
+// sameContent compares two files, cheaply when they are hardlinks of each other
+func sameContent(a string, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if os.SameFile(infoA, infoB) {
+		return true, nil
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+	sumA, _, err := utils.Sha256File(a)
+	if err != nil {
+		return false, err
+	}
+	sumB, _, err := utils.Sha256File(b)
+	if err != nil {
+		return false, err
+	}
+	return sumA == sumB, nil
+}
+
+// linkOrCopy hardlinks src to dst, copying when they are on different filesystems, via a part file either way
+func linkOrCopy(src string, dst string) error {
+	// a snapshot's symlink is relative to the snapshot, link what it points at
+	src, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	if err := utils.MakeDirs(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	part := dst + utils.PartSuffix
+	os.Remove(part)
+	if err := os.Link(src, part); err != nil {
+		if err := utils.CopyFile(src, part); err != nil {
+			return err
+		}
+	}
+	return os.Rename(part, dst)
+}
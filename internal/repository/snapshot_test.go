@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+)
+
+func TestSnapshotAndPromote(t *testing.T) {
+	dev := t.TempDir()
+	os.MkdirAll(filepath.Join(dev, "collections"), 0755)
+	os.MkdirAll(filepath.Join(dev, "roles"), 0755)
+	writeTarGz(t, filepath.Join(dev, "collections", "ns-base-1.0.0.tar.gz"), testCollectionFiles("ns", "base", "1.0.0"))
+	writeTarGz(t, filepath.Join(dev, "roles", "geer-java-1.0.0.tar.gz"), map[string]string{"java/meta/main.yml": "galaxy_info:\n  author: geer\n"})
+	if err := indexRepo(dev); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SnapshotRepo(dev, "../escape"); !errors.Is(err, laxerrors.ErrUsage) {
+		t.Errorf("expected a usage error for a bad name, got %v", err)
+	}
+	result, err := SnapshotRepo(dev, "2024-05-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Collections != 1 || result.Roles != 1 || result.Linked != 2 {
+		t.Errorf("unexpected snapshot %+v", result)
+	}
+	snapshot := filepath.Join(dev, SnapshotsDir, "2024-05-01")
+	if _, err := SnapshotRepo(dev, "2024-05-01"); !errors.Is(err, laxerrors.ErrConflict) {
+		t.Errorf("expected a conflict for an existing snapshot, got %v", err)
+	}
+
+	// the dev repo moves on, the snapshot doesn't
+	writeTarGz(t, filepath.Join(dev, "collections", "ns-base-2.0.0.tar.gz"), testCollectionFiles("ns", "base", "2.0.0"))
+	if err := indexRepo(dev); err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := ExtractCollectionManifestsFromTarGz(filepath.Join(snapshot, "collection_manifests.tar.gz"))
+	if err != nil || len(manifests) != 1 {
+		t.Fatalf("expected the snapshot to still have 1 collection, got %d (%v)", len(manifests), err)
+	}
+
+	prod := filepath.Join(t.TempDir(), "prod")
+	result, err = PromoteSnapshot(snapshot, prod, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Linked != 2 {
+		t.Errorf("expected 2 artifacts promoted, got %+v", result)
+	}
+	promoted, err := readRepoMeta(prod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range append(repoMetaIndexFiles(promoted), "collections/ns-base-1.0.0.tar.gz", "roles/geer-java-1.0.0.tar.gz") {
+		if _, err := os.Stat(filepath.Join(prod, filepath.FromSlash(f))); err != nil {
+			t.Errorf("%s wasn't promoted: %v", f, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(prod, "collections", "ns-base-2.0.0.tar.gz")); err == nil {
+		t.Error("an artifact that isn't in the snapshot was promoted")
+	}
+
+	// promoting again has nothing to do
+	if result, err = PromoteSnapshot(snapshot, prod, false); err != nil || result.Linked != 0 {
+		t.Errorf("expected nothing new the second time, got %+v (%v)", result, err)
+	}
+
+	// the same version with different content
+	conflicting := filepath.Join(prod, "collections", "ns-base-1.0.0.tar.gz")
+	os.Remove(conflicting)
+	writeTarGz(t, conflicting, withDependencies(testCollectionFiles("ns", "base", "1.0.0"), map[string]string{"ns.other": "*"}))
+	if _, err := PromoteSnapshot(snapshot, prod, false); !errors.Is(err, laxerrors.ErrConflict) {
+		t.Errorf("expected a conflict, got %v", err)
+	}
+	if _, err := PromoteSnapshot(snapshot, prod, true); err != nil {
+		t.Errorf("expected force to replace it, got %v", err)
+	}
+
+	// a client that read the repometa.json before a promote still gets the index it named
+	before, err := readRepoMeta(prod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldIndex := map[string][]byte{}
+	for _, fn := range repoMetaIndexFiles(before) {
+		oldIndex[fn], _ = os.ReadFile(filepath.Join(prod, fn))
+	}
+	if _, err := SnapshotRepo(dev, "2024-06-01"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PromoteSnapshot(filepath.Join(dev, SnapshotsDir, "2024-06-01"), prod, true); err != nil {
+		t.Fatal(err)
+	}
+	for fn, data := range oldIndex {
+		if current, err := os.ReadFile(filepath.Join(prod, fn)); err != nil || string(current) != string(data) {
+			t.Errorf("the promote changed %s under a client of the old index (%v)", fn, err)
+		}
+	}
+	after, err := readRepoMeta(prod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.CollectionManifests.Filename == before.CollectionManifests.Filename || !promotedIndexPattern.MatchString(after.CollectionManifests.Filename) {
+		t.Errorf("expected the promoted index under a new name, got %s", after.CollectionManifests.Filename)
+	}
+	manifests, err = ExtractCollectionManifestsFromTarGz(filepath.Join(prod, after.CollectionManifests.Filename))
+	if err != nil || len(manifests) != 2 {
+		t.Errorf("expected the promoted index to have 2 collections, got %d (%v)", len(manifests), err)
+	}
+
+	// publishing after a promote adds to the promoted index
+	role := filepath.Join(t.TempDir(), "geer-nginx-1.0.0.tar.gz")
+	writeTarGz(t, role, map[string]string{"nginx/meta/main.yml": "galaxy_info:\n  author: geer\n"})
+	if _, err := PublishToDir(prod, role, PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	client := &FileRepoClient{BasePath: prod}
+	if err := client.FetchRepoMeta(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if collections, err := client.GetCollectionManifests(); err != nil || len(collections) != 2 {
+		t.Errorf("expected the 2 promoted collections to stay indexed, got %d (%v)", len(collections), err)
+	}
+	if roles, err := client.GetRoleManifests(); err != nil || len(roles) != 2 {
+		t.Errorf("expected the promoted role and the published one, got %d (%v)", len(roles), err)
+	}
+}
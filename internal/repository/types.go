@@ -1,5 +1,7 @@
 package repository
 
+import "github.com/jctanner/lax/internal/types"
+
 /***************************************************************
 GLOBAL
 ***************************************************************/
@@ -10,6 +12,8 @@ type RepoMeta struct {
 	CollectionFiles     RepoMetaFile `json:"collection_files"`
 	RoleManifests       RepoMetaFile `json:"role_manifests"`
 	RoleFiles           RepoMetaFile `json:"role_files"`
+	// how the artifacts are laid out, see LayoutFlat and LayoutSharded
+	Layout int `json:"layout,omitempty"`
 }
 
 type RepoMetaFile struct {
@@ -23,13 +27,25 @@ COLLECTIONS
 
 type CollectionManifest struct {
 	CollectionInfo CollectionInfo `json:"collection_info"`
+
+	// set by createrepo, not part of the MANIFEST.json in the tarball
+	Artifact types.ArtifactInfo `json:"artifact"`
 }
 
 type CollectionInfo struct {
-	Namespace    string            `json:"namespace"`
-	Name         string            `json:"name"`
-	Version      string            `json:"version"`
-	Dependencies map[string]string `json:"dependencies"`
+	Namespace     string            `json:"namespace"`
+	Name          string            `json:"name"`
+	Version       string            `json:"version"`
+	Authors       []string          `json:"authors"`
+	Description   string            `json:"description"`
+	License       []string          `json:"license"`
+	LicenseFile   string            `json:"license_file"`
+	Tags          []string          `json:"tags"`
+	Repository    string            `json:"repository"`
+	Documentation string            `json:"documentation"`
+	Homepage      string            `json:"homepage"`
+	Issues        string            `json:"issues"`
+	Dependencies  map[string]string `json:"dependencies"`
 }
 
 type CollectionFilesMeta struct {
@@ -0,0 +1,183 @@
+package roles
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/blang/semver/v4"
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// the RoleBuildInfo format lax role build writes
+const buildInfoFormat = 1
+
+// BuildOptions override what meta/main.yml says about a role
+type BuildOptions struct {
+	Namespace string
+	Name      string
+	Version   string
+	Force     bool
+}
+
+// role namespaces and names end up in <ns>-<name>-<version>.tar.gz and <ns>.<name>, so no dashes or dots
+var roleNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Build builds the role in args[0] (or the current directory) into --output-path
+func Build(kwargs *types.CmdKwargs, args []string) error {
+	srcDir := "."
+	if len(args) > 1 {
+		return laxerrors.New(laxerrors.ErrUsage, "build takes at most one role directory")
+	}
+	if len(args) == 1 {
+		srcDir = args[0]
+	}
+	outputDir := kwargs.DestDir
+	if outputDir == "" {
+		outputDir = "."
+	}
+
+	artifact, err := BuildRole(srcDir, outputDir, BuildOptions{
+		Namespace: kwargs.Namespace,
+		Name:      kwargs.Name,
+		Version:   kwargs.Version,
+		Force:     kwargs.Force,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Created role at %s\n", artifact)
+	return nil
+}
+
+// ReadRoleBuildInfo works out a role's namespace, name and version from opts, meta/main.yml and the directory name
+func ReadRoleBuildInfo(srcDir string, opts BuildOptions) (types.RoleBuildInfo, error) {
+	info := types.RoleBuildInfo{Format: buildInfoFormat}
+
+	var data []byte
+	var err error
+	var filename string
+	for _, filename = range []string{"meta/main.yml", "meta/main.yaml"} {
+		data, err = os.ReadFile(filepath.Join(srcDir, filepath.FromSlash(filename)))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return info, laxerrors.New(laxerrors.ErrNotFound, "%s has no meta/main.yml", srcDir)
+	}
+	var meta types.RoleMeta
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return info, laxerrors.Wrap(laxerrors.ErrUsage, err, "failed to parse %s", filename)
+	}
+
+	info.Namespace = firstNonEmpty(opts.Namespace, meta.GalaxyInfo.Namespace)
+	info.Name = firstNonEmpty(opts.Name, meta.GalaxyInfo.RoleName, strings.TrimPrefix(filepath.Base(srcDir), "ansible-role-"))
+	info.Version = firstNonEmpty(opts.Version, meta.GalaxyInfo.Version)
+
+	if info.Namespace == "" {
+		return info, laxerrors.New(laxerrors.ErrUsage, "%s has no galaxy_info.namespace, use --namespace", filename)
+	}
+	if info.Version == "" {
+		return info, laxerrors.New(laxerrors.ErrUsage, "%s has no galaxy_info.version, use --version", filename)
+	}
+	for _, part := range []string{info.Namespace, info.Name} {
+		if !roleNamePattern.MatchString(part) {
+			return info, laxerrors.New(laxerrors.ErrUsage, "%s.%s is not a valid role name, only letters, digits and underscores are allowed", info.Namespace, info.Name)
+		}
+	}
+	if _, err := semver.Parse(info.Version); err != nil {
+		return info, laxerrors.Wrap(laxerrors.ErrUsage, err, "invalid version %q", info.Version)
+	}
+	return info, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+/*
+BuildRole writes <namespace>-<name>-<version>.tar.gz for the role in
+srcDir to outputDir. Everything is under a single <namespace>.<name>/
+directory and meta/lax_role.json records the namespace, name and version,
+so createrepo and publish don't have to guess them. Like collection
+builds, the entries are sorted and share one mtime, so the same tree
+always builds to the same bytes.
+*/
+func BuildRole(srcDir string, outputDir string, opts BuildOptions) (string, error) {
+	root, err := utils.GetAbsPath(srcDir)
+	if err != nil {
+		return "", err
+	}
+	if !utils.IsDir(root) {
+		return "", laxerrors.New(laxerrors.ErrNotFound, "%s is not a directory", srcDir)
+	}
+	if utils.IsLink(filepath.Join(root, "meta")) {
+		return "", laxerrors.New(laxerrors.ErrUsage, "%s/meta is a symlink, lax can't add %s to it", srcDir, types.RoleBuildInfoFile)
+	}
+	info, err := ReadRoleBuildInfo(root, opts)
+	if err != nil {
+		return "", err
+	}
+	infoJSON, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	ignoredDirs := map[string]bool{"CVS": true, ".bzr": true, ".hg": true, ".git": true, ".svn": true, "__pycache__": true, ".tox": true}
+	previousBuilds := fmt.Sprintf("%s-%s-*.tar.gz", info.Namespace, info.Name)
+	files, err := utils.ArchiveEntriesFromDir(root, func(rel string, fi os.FileInfo) bool {
+		base := path.Base(rel)
+		if fi.IsDir() {
+			return ignoredDirs[base]
+		}
+		if rel == types.RoleBuildInfoFile || strings.HasSuffix(base, ".pyc") || strings.HasSuffix(base, ".retry") {
+			return true
+		}
+		matched, _ := path.Match(previousBuilds, rel)
+		return matched
+	})
+	if err != nil {
+		return "", err
+	}
+
+	top := fmt.Sprintf("%s.%s", info.Namespace, info.Name)
+	entries := []utils.ArchiveEntry{{Name: top, Dir: true}}
+	for _, f := range files {
+		f.Name = path.Join(top, f.Name)
+		entries = append(entries, f)
+		if f.Name == path.Join(top, "meta") {
+			entries = append(entries, utils.ArchiveEntry{Name: path.Join(top, types.RoleBuildInfoFile), Data: append(infoJSON, '\n')})
+		}
+	}
+
+	outDir, err := utils.GetAbsPath(utils.ExpandUser(outputDir))
+	if err != nil {
+		return "", err
+	}
+	if err := utils.MakeDirs(outDir); err != nil {
+		return "", err
+	}
+	artifact := filepath.Join(outDir, fmt.Sprintf("%s-%s-%s.tar.gz", info.Namespace, info.Name, info.Version))
+	if utils.FileExists(artifact) && !opts.Force {
+		return "", laxerrors.New(laxerrors.ErrConflict, "%s already exists, use --force to replace it", artifact)
+	}
+
+	if err := utils.WriteTarGz(artifact+utils.PartSuffix, entries, utils.SourceDateEpoch()); err != nil {
+		os.Remove(artifact + utils.PartSuffix)
+		return "", err
+	}
+	return artifact, os.Rename(artifact+utils.PartSuffix, artifact)
+}
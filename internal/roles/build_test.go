@@ -0,0 +1,153 @@
+package roles
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/repository"
+	"github.com/jctanner/lax/internal/testutil"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+)
+
+func TestBuildRole(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "ansible-role-web")
+	testutil.WriteTree(t, src, map[string]string{
+		"meta/main.yml":            "galaxy_info:\n  author: me\n  namespace: acme\n  version: 1.2.0\n",
+		"tasks/main.yml":           "- debug: msg=hi\n",
+		"files/helper.pyc":         "",
+		"site.retry":               "",
+		".git/HEAD":                "",
+		"acme-web-1.1.0.tar.gz":    "",
+		"meta/lax_role.json":       "{\"name\": \"stale\"}",
+		"templates/web.conf.j2":    "",
+		"files/docs/acme.tar.gz":   "",
+		"tests/inventory":          "localhost",
+		"defaults/main/vars.yml":   "",
+		"vars/main.yml":            "",
+		"handlers/main.yml":        "",
+		"library/custom_module.py": "",
+	})
+	out := t.TempDir()
+
+	artifact, err := BuildRole(src, out, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(artifact) != "acme-web-1.2.0.tar.gz" {
+		t.Errorf("unexpected artifact name %s", artifact)
+	}
+
+	names, err := utils.ListFilenamesInTarGz(artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"acme.web/", "acme.web/defaults/", "acme.web/defaults/main/", "acme.web/defaults/main/vars.yml",
+		"acme.web/files/", "acme.web/files/docs/", "acme.web/files/docs/acme.tar.gz",
+		"acme.web/handlers/", "acme.web/handlers/main.yml", "acme.web/library/", "acme.web/library/custom_module.py",
+		"acme.web/meta/", "acme.web/meta/lax_role.json", "acme.web/meta/main.yml",
+		"acme.web/tasks/", "acme.web/tasks/main.yml", "acme.web/templates/", "acme.web/templates/web.conf.j2",
+		"acme.web/tests/", "acme.web/tests/inventory", "acme.web/vars/", "acme.web/vars/main.yml",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("files\n got %v\nwant %v", names, expected)
+	}
+
+	// the same tree builds to the same bytes
+	first, _ := os.ReadFile(artifact)
+	if _, err := BuildRole(src, out, BuildOptions{}); !errors.Is(err, laxerrors.ErrConflict) {
+		t.Errorf("expected a conflict without force, got %v", err)
+	}
+	if _, err := BuildRole(src, out, BuildOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := os.ReadFile(artifact)
+	if !bytes.Equal(first, second) {
+		t.Error("two builds of the same tree differ")
+	}
+
+	// the embedded metadata is read back whatever the file is called
+	renamed := filepath.Join(t.TempDir(), "renamed.tar.gz")
+	if err := utils.CopyFile(artifact, renamed); err != nil {
+		t.Fatal(err)
+	}
+	rmeta, err := repository.GetRoleMetaFromTarball(renamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rmeta.GalaxyInfo; got.Namespace != "acme" || got.RoleName != "web" || got.Version != "1.2.0" {
+		t.Errorf("expected acme.web 1.2.0 from the artifact, got %s.%s %s", got.Namespace, got.RoleName, got.Version)
+	}
+	result, err := repository.PublishToDir(t.TempDir(), renamed, repository.PublishOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Namespace != "acme" || result.Name != "web" || result.Version != "1.2.0" {
+		t.Errorf("expected to publish acme.web 1.2.0, got %+v", result)
+	}
+}
+
+func TestReadRoleBuildInfo(t *testing.T) {
+	tests := []struct {
+		name     string
+		meta     string
+		opts     BuildOptions
+		expected string
+		errMsg   string
+	}{
+		{"from meta", "galaxy_info:\n  namespace: acme\n  role_name: db\n  version: 2.0.0\n", BuildOptions{}, "acme.db 2.0.0", ""},
+		{"dir name", "galaxy_info:\n  namespace: acme\n  version: 2.0.0\n", BuildOptions{}, "acme.web 2.0.0", ""},
+		{"flags win", "galaxy_info:\n  namespace: acme\n  version: 2.0.0\n", BuildOptions{Namespace: "other", Name: "app", Version: "3.0.0"}, "other.app 3.0.0", ""},
+		{"no namespace", "galaxy_info:\n  version: 2.0.0\n", BuildOptions{}, "", "use --namespace"},
+		{"no version", "galaxy_info:\n  namespace: acme\n", BuildOptions{}, "", "use --version"},
+		{"bad version", "galaxy_info:\n  namespace: acme\n  version: v2\n", BuildOptions{}, "", "invalid version"},
+		{"bad name", "galaxy_info:\n  namespace: acme\n  version: 2.0.0\n", BuildOptions{Name: "my-role"}, "", "not a valid role name"},
+		{"bad yaml", "galaxy_info: [\n", BuildOptions{}, "", "failed to parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "ansible-role-web")
+			testutil.WriteTree(t, dir, map[string]string{"meta/main.yml": tt.meta})
+			info, err := ReadRoleBuildInfo(dir, tt.opts)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := info.Namespace + "." + info.Name + " " + info.Version; got != tt.expected {
+					t.Errorf("got %s, want %s", got, tt.expected)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) || !errors.Is(err, laxerrors.ErrUsage) {
+				t.Errorf("expected a usage error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestInit(t *testing.T) {
+	initPath := t.TempDir()
+	kwargs := &types.CmdKwargs{DestDir: initPath}
+	if err := Init(kwargs, []string{"acme.web"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Init(kwargs, []string{"acme.web"}); !errors.Is(err, laxerrors.ErrConflict) {
+		t.Errorf("expected a conflict initializing twice, got %v", err)
+	}
+
+	// the skeleton's meta/main.yml has everything lax role build needs
+	artifact, err := BuildRole(filepath.Join(initPath, "acme.web"), t.TempDir(), BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(artifact) != "acme-web-1.0.0.tar.gz" {
+		t.Errorf("unexpected artifact name %s", artifact)
+	}
+}
@@ -0,0 +1,110 @@
+package roles
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/packagemanager"
+	"github.com/jctanner/lax/internal/repository"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+)
+
+// Info prints everything the repo index knows about a role
+func Info(kwargs *types.CmdKwargs, args []string) error {
+
+	ispec, err := utils.SpecFromArgs(kwargs.Namespace, kwargs.Name, kwargs.Version, args)
+	if err != nil {
+		return err
+	}
+	namespace := ispec.Namespace
+	name := ispec.Name
+	version := ispec.Version
+
+	repoClient, err := repository.GetRepoClient(kwargs.Server, kwargs.CacheDir)
+	if err != nil {
+		return err
+	}
+
+	pkgMgr, err := packagemanager.GetPackageManager(kwargs.CacheDir, kwargs.DestDir)
+	if err != nil {
+		return err
+	}
+	if err := pkgMgr.SyncRepoMeta(repoClient); err != nil {
+		return err
+	}
+
+	manifests, err := repoClient.GetRoleManifests()
+	if err != nil {
+		return err
+	}
+
+	// every version of the role in the repo
+	allVersions := repository.RoleSpecToManifestCandidates(
+		utils.InstallSpec{Namespace: namespace, Name: name},
+		&manifests,
+	)
+	if len(allVersions) == 0 {
+		return laxerrors.New(laxerrors.ErrNotFound, "%s.%s was not found in the repository", namespace, name)
+	}
+	allVersions = repository.SortRoleManifestsNewestFirst(allVersions)
+
+	// the version to show details for
+	selected := allVersions[0]
+	if version != "" {
+		candidates := repository.RoleSpecToManifestCandidates(
+			utils.InstallSpec{Namespace: namespace, Name: name, Version: version},
+			&allVersions,
+		)
+		if len(candidates) == 0 {
+			return laxerrors.New(laxerrors.ErrNotFound, "%s.%s has no version matching %s", namespace, name, version)
+		}
+		selected = repository.SortRoleManifestsNewestFirst(candidates)[0]
+	}
+
+	installed, err := pkgMgr.GetInstalledRoleVersion(namespace, name)
+	if err != nil {
+		return err
+	}
+
+	gi := selected.GalaxyInfo
+	utils.PrintInfoField("Name", fmt.Sprintf("%s.%s", gi.Namespace, gi.RoleName))
+	utils.PrintInfoField("Version", gi.Version)
+	utils.PrintInfoField("Description", gi.Description)
+	utils.PrintInfoField("Authors", strings.Join(gi.Author.Value, ", "))
+	utils.PrintInfoField("Company", gi.Company)
+	utils.PrintInfoField("License", strings.Join(gi.License, ", "))
+	utils.PrintInfoField("Issues", gi.IssueTrackerUrl)
+	utils.PrintInfoField("Branch", gi.GithubBranch)
+	tags := []string{}
+	for _, gt := range gi.GalaxyTags {
+		tags = append(tags, gt...)
+	}
+	utils.PrintInfoField("Tags", strings.Join(tags, ", "))
+	utils.PrintInfoField("Min Ansible", gi.MinAnsibleVersion)
+	if installed != "" {
+		utils.PrintInfoField("Installed", fmt.Sprintf("%s (%s)", installed, pkgMgr.GetInstalledRolePath(namespace, name)))
+	} else {
+		utils.PrintInfoField("Installed", "no")
+	}
+
+	fmt.Printf("Platforms:\n")
+	for _, p := range gi.Platforms {
+		fmt.Printf("  %s: %s\n", p.Name, strings.Join(p.Versions, ", "))
+	}
+
+	fmt.Printf("Versions:\n")
+	for _, m := range allVersions {
+		fmt.Printf("  %s\n", m.GalaxyInfo.Version)
+		for _, d := range m.GalaxyInfo.Dependencies {
+			if d.Version != "" {
+				fmt.Printf("    - %s %s\n", d.Name, d.Version)
+			} else {
+				fmt.Printf("    - %s\n", d.Name)
+			}
+		}
+	}
+
+	return nil
+}
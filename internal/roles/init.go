@@ -0,0 +1,47 @@
+package roles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/skeleton"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+)
+
+// Init creates <init-path>/<namespace>.<name> from the built in skeleton or --role-skeleton
+func Init(kwargs *types.CmdKwargs, args []string) error {
+	if len(args) != 1 {
+		return laxerrors.New(laxerrors.ErrUsage, "init takes one namespace.name")
+	}
+	parts := strings.Split(args[0], ".")
+	if len(parts) != 2 || !roleNamePattern.MatchString(parts[0]) || !roleNamePattern.MatchString(parts[1]) {
+		return laxerrors.New(laxerrors.ErrUsage, "%s is not a valid role name, it should be namespace.name", args[0])
+	}
+	initPath := kwargs.DestDir
+	if initPath == "" {
+		initPath = "."
+	}
+	initPath, err := utils.GetAbsPath(utils.ExpandUser(initPath))
+	if err != nil {
+		return err
+	}
+
+	skel := skeleton.Builtin("role")
+	if kwargs.Skeleton != "" {
+		if skel, err = skeleton.FromDir(kwargs.Skeleton); err != nil {
+			return err
+		}
+	}
+
+	// the directory is named like ansible-galaxy role init names it, lax role build reads the name from meta/main.yml
+	dest := filepath.Join(initPath, args[0])
+	if _, err := skeleton.Render(skel, dest, skeleton.DefaultVars("role", parts[0], parts[1]), kwargs.Force); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Created role %s at %s\n", args[0], dest)
+	return nil
+}
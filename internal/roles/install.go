@@ -1,9 +1,10 @@
 package roles
 
 import (
-	"fmt"
-	"time"
+	"errors"
+	"os"
 
+	"github.com/jctanner/lax/internal/laxerrors"
 	"github.com/jctanner/lax/internal/packagemanager"
 	"github.com/jctanner/lax/internal/repository"
 	"github.com/jctanner/lax/internal/types"
@@ -19,7 +20,7 @@ func Install(kwargs *types.CmdKwargs, args []string) error {
 	server := kwargs.Server
 	requirements_file := kwargs.RequirementsFile
 	if requirements_file != "" {
-		panic("role install with requirements file not yet implemented!!!")
+		return laxerrors.New(laxerrors.ErrUsage, "role install with a requirements file is not implemented yet")
 	}
 	namespace := kwargs.Namespace
 	name := kwargs.Name
@@ -29,40 +30,29 @@ func Install(kwargs *types.CmdKwargs, args []string) error {
 		cachedir, dest, server, namespace, name, version)
 
 	// does dest have a repodata.json file, read it in?
-	repoClient, _ := repository.GetRepoClient(server, cachedir)
-	logrus.Debugf("created repo client: %s", repoClient)
-	if repoClient == nil {
-		return fmt.Errorf("no suitable repostiory found")
+	repoClient, err := repository.GetRepoClient(server, cachedir)
+	if err != nil {
+		return err
 	}
+	logrus.Debugf("created repo client: %s", repoClient)
 
 	// Make the local package manager client
-	pkgMgr, err := packagemanager.GetPackageManager(cachedir, dest)
-	logrus.Debugf("created package manager: %s", pkgMgr)
+	// a dry run leaves the cache as it is, the index is fetched somewhere temporary if it has to be
+	getPackageManager := packagemanager.GetPackageManager
+	if kwargs.DryRun {
+		getPackageManager = packagemanager.GetReadOnlyPackageManager
+	}
+	pkgMgr, err := getPackageManager(cachedir, dest)
+	logrus.Debugf("created package manager: %v", pkgMgr)
 	if err != nil {
 		return err
 	}
 
-	// Is the package manager's meta older? Re-download if so ...
-	if !pkgMgr.HasRepoMeta() {
-		logrus.Debugf("no repo meta found on disk, fetching ...")
-		repoClient.FetchRepoMeta(pkgMgr.CachePath)
-	} else {
-		// Is it up to date?
-		pDate := pkgMgr.RepoMeta.Date
-		logrus.Debugf("package manager meta date: %s", pDate)
-		rDate, _ := repoClient.GetRepoMetaDate()
-		logrus.Debugf("repo client meta date: %s\n", rDate)
-
-		d1, _ := time.Parse(time.RFC3339, pDate)
-		d2, _ := time.Parse(time.RFC3339, rDate)
-
-		if d1.Before(d2) {
-			logrus.Debugf("updating local meta cache")
-			repoClient.FetchRepoMeta(pkgMgr.CachePath)
-		} else {
-			logrus.Debugf("not updating local meta cache")
-		}
+	defer pkgMgr.Close()
 
+	// Is the package manager's meta older? Re-download if so ...
+	if err := pkgMgr.SyncRepoMeta(repoClient); err != nil {
+		return err
 	}
 
 	// split the last argument into namespace/name/etc
@@ -87,33 +77,47 @@ func Install(kwargs *types.CmdKwargs, args []string) error {
 		Name:      name,
 		Version:   version,
 	}
+	if ispec.Namespace == "" || ispec.Name == "" {
+		return laxerrors.New(laxerrors.ErrUsage, "a namespace.name is required")
+	}
 
 	logrus.Infof("initial spec: %s.%s==%s", ispec.Namespace, ispec.Name, ispec.Version)
 
-	specs, err := repoClient.ResolveRoleDeps(ispec)
-
+	manifests, err := repoClient.GetRoleManifests()
 	if err != nil {
-		logrus.Errorf("error solving dep tree %s", err)
 		return err
 	}
 
-	logrus.Infof("-----------------------------------------------------")
-	for _, spec := range specs {
-		logrus.Infof("install: %s.%s==%s\n", spec.Namespace, spec.Name, spec.Version)
+	// keep installed versions that still satisfy the request, like ansible-galaxy
+	prefer := pkgMgr.GetInstalledRoles()
+	if kwargs.ForceWithDeps {
+		prefer = map[string]string{}
+	} else if kwargs.Force {
+		delete(prefer, ispec.Namespace+"."+ispec.Name)
 	}
 
-	logrus.Infof("-----------------------------------------------------")
-	for _, spec := range specs {
-		logrus.Infof("installing: %s.%s==%s\n", spec.Namespace, spec.Name, spec.Version)
-
-		// get a local cache file from the repo to install ...
-		fn := repoClient.GetCacheRoleFileLocationForInstallSpec(spec)
-		logrus.Debugf("install %s from %s", spec, fn)
-
-		// extract it to the right place ...
-		pkgMgr.InstallRoleFromPath(spec.Namespace, spec.Name, spec.Version, fn)
+	specs, err := repository.ResolveRoleDepsRetrying(ispec, &manifests, prefer)
+	if errors.Is(err, laxerrors.ErrConflict) && len(prefer) > 0 {
+		// an installed version may be what conflicts, so try again without them
+		logrus.Debugf("%s, resolving again without the installed versions", err)
+		specs, err = repository.ResolveRoleDepsRetrying(ispec, &manifests, nil)
+	}
+	if err != nil {
+		return err
+	}
 
+	txn := pkgMgr.PlanRoleTransaction(specs, manifests)
+	if kwargs.ForceWithDeps {
+		txn.ForceReinstall("", "")
+	} else if kwargs.Force {
+		txn.ForceReinstall(ispec.Namespace, ispec.Name)
+	}
+	if err := txn.Write(os.Stdout, kwargs.OutputFormat); err != nil {
+		return err
+	}
+	if kwargs.DryRun {
+		return nil
 	}
 
-	return nil
+	return pkgMgr.ApplyTransaction(&txn, repoClient, kwargs.DownloadConcurrency)
 }
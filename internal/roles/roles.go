@@ -3,16 +3,17 @@ package roles
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sync"
 
-	"github.com/go-resty/resty/v2"
+	"github.com/jctanner/lax/internal/httpclient"
+	"github.com/sirupsen/logrus"
 )
 
 func SyncRoles(server string, dest string) error {
-	client := resty.New()
 	var wg sync.WaitGroup
 	pageCh := make(chan int, 100) // Buffered channel to limit the number of concurrent requests
 	errCh := make(chan error, 1)  // Channel to capture errors
@@ -24,17 +25,17 @@ func SyncRoles(server string, dest string) error {
 
 	// Fetch the first page to get the total count
 	firstPageURL := fmt.Sprintf("%s/api/v1/roles/?page=1", server)
-	resp, err := client.R().Get(firstPageURL)
+	body, status, err := getPage(firstPageURL)
 	if err != nil {
 		return fmt.Errorf("failed to fetch the first page: %v", err)
 	}
 
-	if resp.StatusCode() != 200 {
-		return fmt.Errorf("failed to fetch the first page: status code %d", resp.StatusCode())
+	if status != 200 {
+		return fmt.Errorf("failed to fetch the first page: status code %d", status)
 	}
 
 	var firstPageData map[string]interface{}
-	if err := json.Unmarshal(resp.Body(), &firstPageData); err != nil {
+	if err := json.Unmarshal(body, &firstPageData); err != nil {
 		return fmt.Errorf("failed to parse the first page response: %v", err)
 	}
 
@@ -48,7 +49,7 @@ func SyncRoles(server string, dest string) error {
 		totalPages++
 	}
 
-	fmt.Printf("Total number of pages to fetch: %d\n", totalPages)
+	logrus.Infof("Total number of pages to fetch: %d", totalPages)
 
 	// Worker function to process pages
 	worker := func() {
@@ -58,37 +59,37 @@ func SyncRoles(server string, dest string) error {
 
 			// Skip downloading if the file already exists
 			if _, err := os.Stat(fileName); err == nil {
-				fmt.Printf("File for page %d already exists, skipping download.\n", page)
+				logrus.Debugf("File for page %d already exists, skipping download.", page)
 				continue
 			}
 
-			fmt.Printf("Starting download for page %d.\n", page)
+			logrus.Debugf("Starting download for page %d.", page)
 			url := fmt.Sprintf("%s/api/v1/roles/?page=%d", server, page)
-			resp, err := client.R().Get(url)
+			body, status, err := getPage(url)
 			if err != nil {
 				errCh <- fmt.Errorf("failed to fetch page %d: %v", page, err)
 				return
 			}
 
-			if resp.StatusCode() != 200 {
-				errCh <- fmt.Errorf("failed to fetch page %d: status code %d", page, resp.StatusCode())
+			if status != 200 {
+				errCh <- fmt.Errorf("failed to fetch page %d: status code %d", page, status)
 				return
 			}
 
-			if len(resp.Body()) == 0 {
+			if len(body) == 0 {
 				// If the response body is empty, assume we've reached the last page
 				close(pageCh)
 				return
 			}
 
 			// Write the response body to a file
-			err = ioutil.WriteFile(fileName, resp.Body(), 0644)
+			err = ioutil.WriteFile(fileName, body, 0644)
 			if err != nil {
 				errCh <- fmt.Errorf("failed to write page %d to file: %v", page, err)
 				return
 			}
 
-			fmt.Printf("Successfully fetched and saved page %d\n", page)
+			logrus.Debugf("Successfully fetched and saved page %d", page)
 		}
 	}
 
@@ -105,7 +106,7 @@ func SyncRoles(server string, dest string) error {
 			select {
 			case err := <-errCh:
 				close(pageCh)
-				fmt.Println("Error:", err)
+				logrus.Errorf("%s", err)
 				return
 			default:
 				pageCh <- page
@@ -125,3 +126,14 @@ func SyncRoles(server string, dest string) error {
 
 	return nil
 }
+
+// getPage reads a whole api page with the shared http client
+func getPage(url string) ([]byte, int, error) {
+	resp, err := httpclient.Get(url)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return body, resp.StatusCode, err
+}
@@ -0,0 +1,363 @@
+package serve
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jctanner/lax/internal/repository"
+	"github.com/sirupsen/logrus"
+)
+
+// GalaxyAPIPrefix is where --galaxy-api mounts the v3 endpoints
+const GalaxyAPIPrefix = "/api/"
+
+// the v3 pagination default, ansible-galaxy asks for limit=100 itself
+const defaultPageLimit = 100
+
+/*
+galaxyAPI answers the read-only subset of the Galaxy v3 api that
+ansible-galaxy uses to install collections, from the repo's collection
+index. The index is reloaded whenever repometa.json changes or serve
+itself has rewritten it, so a rewrite within the mtime resolution of the
+last load is not missed.
+*/
+type galaxyAPI struct {
+	repo *repoHandler
+
+	mu     sync.Mutex
+	loaded indexKey
+	date   string
+	// namespace.name -> manifests sorted by version, oldest first
+	collections map[string][]repository.CollectionManifest
+	names       []string
+	// download filename -> path in the repo, which depends on its layout
+	artifacts map[string]string
+}
+
+// indexKey identifies the repometa.json a load read
+type indexKey struct {
+	modTime    time.Time
+	size       int64
+	generation uint64
+}
+
+func (g *galaxyAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+GalaxyAPIPrefix+"{$}", g.root)
+	mux.HandleFunc("GET "+GalaxyAPIPrefix+"v3/collections/{$}", g.listCollections)
+	mux.HandleFunc("GET "+GalaxyAPIPrefix+"v3/collections/{namespace}/{name}/{$}", g.collection)
+	mux.HandleFunc("GET "+GalaxyAPIPrefix+"v3/collections/{namespace}/{name}/versions/{$}", g.listVersions)
+	mux.HandleFunc("GET "+GalaxyAPIPrefix+"v3/collections/{namespace}/{name}/versions/{version}/{$}", g.version)
+	mux.HandleFunc("GET "+GalaxyAPIPrefix+"v3/artifacts/collections/{filename}", g.artifact)
+}
+
+// load rereads the collection index if it has changed since the last request
+func (g *galaxyAPI) load() error {
+	g.repo.indexLock.RLock()
+	defer g.repo.indexLock.RUnlock()
+
+	metaPath := filepath.Join(g.repo.dir, "repometa.json")
+	info, err := os.Stat(metaPath)
+	if err != nil {
+		return err
+	}
+
+	key := indexKey{modTime: info.ModTime(), size: info.Size(), generation: g.repo.generation}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if key.modTime.Equal(g.loaded.modTime) && key.size == g.loaded.size && key.generation == g.loaded.generation {
+		return nil
+	}
+
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return err
+	}
+	var meta repository.RepoMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return err
+	}
+	manifests, err := repository.ExtractCollectionManifestsFromTarGz(filepath.Join(g.repo.dir, meta.CollectionManifests.Filename))
+	if err != nil {
+		return err
+	}
+
+	byName := map[string][]repository.CollectionManifest{}
+	artifacts := map[string]string{}
+	for _, m := range manifests {
+		fqn := m.CollectionInfo.Namespace + "." + m.CollectionInfo.Name
+		byName[fqn] = append(byName[fqn], m)
+		if m.Artifact.Path != "" {
+			artifacts[artifactFilename(m)] = m.Artifact.Path
+		}
+	}
+	names := make([]string, 0, len(byName))
+	for fqn, ms := range byName {
+		sorted, err := repository.SortManifestsByVersion(ms)
+		if err != nil {
+			return err
+		}
+		byName[fqn] = sorted
+		names = append(names, fqn)
+	}
+	sort.Strings(names)
+
+	g.collections = byName
+	g.names = names
+	g.artifacts = artifacts
+	g.date = meta.Date
+	g.loaded = key
+	logrus.Debugf("galaxy api loaded %d collections from %s", len(names), metaPath)
+	return nil
+}
+
+// lookup returns the versions of a collection, or writes a 404 or 503
+func (g *galaxyAPI) lookup(w http.ResponseWriter, r *http.Request) ([]repository.CollectionManifest, bool) {
+	if err := g.load(); err != nil {
+		logrus.Errorf("galaxy api can't read the repo index: %s", err)
+		galaxyError(w, http.StatusServiceUnavailable, "the repository index is not available")
+		return nil, false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	versions, ok := g.collections[r.PathValue("namespace")+"."+r.PathValue("name")]
+	if !ok {
+		galaxyError(w, http.StatusNotFound, "Not found.")
+		return nil, false
+	}
+	return versions, true
+}
+
+func (g *galaxyAPI) root(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"available_versions": map[string]string{"v3": "v3/"},
+		"current_version":    "v3",
+		"description":        "lax galaxy api (read only)",
+	})
+}
+
+func (g *galaxyAPI) listCollections(w http.ResponseWriter, r *http.Request) {
+	if err := g.load(); err != nil {
+		logrus.Errorf("galaxy api can't read the repo index: %s", err)
+		galaxyError(w, http.StatusServiceUnavailable, "the repository index is not available")
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	data := []interface{}{}
+	offset, limit := pageParams(r)
+	for _, fqn := range page(g.names, offset, limit) {
+		data = append(data, g.summary(g.collections[fqn]))
+	}
+	writeJSON(w, http.StatusOK, paginated(r, len(g.names), offset, limit, data))
+}
+
+func (g *galaxyAPI) collection(w http.ResponseWriter, r *http.Request) {
+	versions, ok := g.lookup(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, g.summary(versions))
+}
+
+func (g *galaxyAPI) listVersions(w http.ResponseWriter, r *http.Request) {
+	versions, ok := g.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	// newest first, like galaxy
+	newest := make([]repository.CollectionManifest, len(versions))
+	for i, m := range versions {
+		newest[len(versions)-1-i] = m
+	}
+
+	data := []interface{}{}
+	offset, limit := pageParams(r)
+	for _, m := range page(newest, offset, limit) {
+		data = append(data, map[string]interface{}{
+			"version":          m.CollectionInfo.Version,
+			"href":             versionHref(m),
+			"created_at":       g.date,
+			"updated_at":       g.date,
+			"requires_ansible": nil,
+		})
+	}
+	writeJSON(w, http.StatusOK, paginated(r, len(newest), offset, limit, data))
+}
+
+func (g *galaxyAPI) version(w http.ResponseWriter, r *http.Request) {
+	versions, ok := g.lookup(w, r)
+	if !ok {
+		return
+	}
+	for _, m := range versions {
+		if m.CollectionInfo.Version != r.PathValue("version") {
+			continue
+		}
+		info := m.CollectionInfo
+		filename := artifactFilename(m)
+		dependencies := info.Dependencies
+		if dependencies == nil {
+			dependencies = map[string]string{}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"href":       versionHref(m),
+			"namespace":  map[string]string{"name": info.Namespace},
+			"collection": map[string]string{"name": info.Name, "href": collectionHref(info.Namespace, info.Name)},
+			"version":    info.Version,
+			"download_url": fmt.Sprintf("%s%sv3/artifacts/collections/%s",
+				baseURL(r), GalaxyAPIPrefix, filename),
+			"artifact": map[string]interface{}{
+				"filename": filename,
+				"sha256":   m.Artifact.Sha256,
+				"size":     m.Artifact.Size,
+			},
+			"metadata": map[string]interface{}{
+				"dependencies":  dependencies,
+				"authors":       info.Authors,
+				"description":   info.Description,
+				"license":       info.License,
+				"tags":          info.Tags,
+				"repository":    info.Repository,
+				"documentation": info.Documentation,
+				"homepage":      info.Homepage,
+				"issues":        info.Issues,
+			},
+			"signatures":       []interface{}{},
+			"requires_ansible": nil,
+			"created_at":       g.date,
+			"updated_at":       g.date,
+		})
+		return
+	}
+	galaxyError(w, http.StatusNotFound, "Not found.")
+}
+
+// artifact hands the download to the file server so it gets Range and ETag support
+func (g *galaxyAPI) artifact(w http.ResponseWriter, r *http.Request) {
+	filename := r.PathValue("filename")
+	relPath := "collections/" + filename
+	if err := g.load(); err == nil {
+		g.mu.Lock()
+		if p, ok := g.artifacts[filename]; ok {
+			relPath = p
+		}
+		g.mu.Unlock()
+	}
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = "/" + relPath
+	g.repo.ServeHTTP(w, r2)
+}
+
+func (g *galaxyAPI) summary(versions []repository.CollectionManifest) map[string]interface{} {
+	highest := versions[len(versions)-1]
+	ns, name := highest.CollectionInfo.Namespace, highest.CollectionInfo.Name
+	return map[string]interface{}{
+		"href":         collectionHref(ns, name),
+		"namespace":    ns,
+		"name":         name,
+		"deprecated":   false,
+		"versions_url": collectionHref(ns, name) + "versions/",
+		"highest_version": map[string]string{
+			"href":    versionHref(highest),
+			"version": highest.CollectionInfo.Version,
+		},
+		"created_at": g.date,
+		"updated_at": g.date,
+	}
+}
+
+func collectionHref(namespace string, name string) string {
+	return fmt.Sprintf("%sv3/collections/%s/%s/", GalaxyAPIPrefix, namespace, name)
+}
+
+func versionHref(m repository.CollectionManifest) string {
+	return fmt.Sprintf("%sversions/%s/", collectionHref(m.CollectionInfo.Namespace, m.CollectionInfo.Name), m.CollectionInfo.Version)
+}
+
+// artifactFilename is the name createrepo and galaxy-sync store collections under
+func artifactFilename(m repository.CollectionManifest) string {
+	return fmt.Sprintf("%s-%s-%s.tar.gz", m.CollectionInfo.Namespace, m.CollectionInfo.Name, m.CollectionInfo.Version)
+}
+
+// baseURL is how the client reached us, so download_url works through a proxy too
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+func pageParams(r *http.Request) (int, int) {
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageLimit
+	}
+	return max(offset, 0), min(limit, 1000)
+}
+
+func page[T any](items []T, offset int, limit int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	return items[offset:min(offset+limit, len(items))]
+}
+
+// paginated wraps a page in v3's meta/links/data, ansible-galaxy follows links.next
+func paginated(r *http.Request, count int, offset int, limit int, data []interface{}) map[string]interface{} {
+	link := func(offset int) interface{} {
+		return fmt.Sprintf("%s?limit=%d&offset=%d", r.URL.Path, limit, offset)
+	}
+	lastOffset := 0
+	if count > 0 {
+		lastOffset = (count - 1) / limit * limit
+	}
+	links := map[string]interface{}{
+		"first":    link(0),
+		"previous": nil,
+		"next":     nil,
+		"last":     link(lastOffset),
+	}
+	if offset > 0 {
+		links["previous"] = link(max(offset-limit, 0))
+	}
+	if offset+limit < count {
+		links["next"] = link(offset + limit)
+	}
+	return map[string]interface{}{
+		"meta":  map[string]int{"count": count},
+		"links": links,
+		"data":  data,
+	}
+}
+
+func galaxyError(w http.ResponseWriter, status int, title string) {
+	writeJSON(w, status, map[string]interface{}{
+		"errors": []map[string]string{{
+			"status": strconv.Itoa(status),
+			"code":   strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"),
+			"title":  title,
+		}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,155 @@
+package serve
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jctanner/lax/internal/repository"
+)
+
+// writeTestRepo writes just enough of an index for the galaxy api to load
+func writeTestRepo(t *testing.T, dir string, manifests []repository.CollectionManifest) {
+	f, err := os.Create(filepath.Join(dir, "collection_manifests.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for i, m := range manifests {
+		data, _ := json.Marshal(m)
+		tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("manifest_%d.json", i), Mode: 0600, Size: int64(len(data))})
+		tw.Write(data)
+	}
+	tw.Close()
+	gz.Close()
+	f.Close()
+
+	meta := repository.RepoMeta{
+		Date:                "2024-06-01T12:00:00Z",
+		CollectionManifests: repository.RepoMetaFile{Filename: "collection_manifests.tar.gz"},
+	}
+	data, _ := json.Marshal(meta)
+	os.WriteFile(filepath.Join(dir, "repometa.json"), data, 0644)
+}
+
+func TestGalaxyAPI(t *testing.T) {
+	dir := t.TempDir()
+	var manifests []repository.CollectionManifest
+	for _, v := range []string{"1.0.0", "1.10.0", "1.2.0"} {
+		m := repository.CollectionManifest{}
+		m.CollectionInfo.Namespace = "ns"
+		m.CollectionInfo.Name = "name"
+		m.CollectionInfo.Version = v
+		m.CollectionInfo.Dependencies = map[string]string{"ns.other": ">=1.0.0"}
+		m.Artifact.Sha256 = "abc" + v
+		manifests = append(manifests, m)
+	}
+	writeTestRepo(t, dir, manifests)
+	os.MkdirAll(filepath.Join(dir, "collections"), 0755)
+	os.WriteFile(filepath.Join(dir, "collections", "ns-name-1.10.0.tar.gz"), []byte("artifact"), 0644)
+
+	repo := &repoHandler{dir: dir}
+	mux := http.NewServeMux()
+	mux.Handle("/", repo)
+	(&galaxyAPI{repo: repo}).register(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		path     string
+		status   int
+		expected map[string]interface{}
+	}{
+		{"/api/", 200, map[string]interface{}{"current_version": "v3"}},
+		{"/api/v3/collections/", 200, map[string]interface{}{"meta": map[string]interface{}{"count": 1.0}}},
+		{"/api/v3/collections/ns/name/", 200, map[string]interface{}{"highest_version": map[string]interface{}{"href": "/api/v3/collections/ns/name/versions/1.10.0/", "version": "1.10.0"}}},
+		{"/api/v3/collections/ns/name/versions/?limit=2", 200, map[string]interface{}{
+			"meta": map[string]interface{}{"count": 3.0},
+			"links": map[string]interface{}{
+				"first":    "/api/v3/collections/ns/name/versions/?limit=2&offset=0",
+				"previous": nil,
+				"next":     "/api/v3/collections/ns/name/versions/?limit=2&offset=2",
+				"last":     "/api/v3/collections/ns/name/versions/?limit=2&offset=2",
+			},
+		}},
+		{"/api/v3/collections/ns/name/versions/1.10.0/", 200, map[string]interface{}{
+			"download_url": server.URL + "/api/v3/artifacts/collections/ns-name-1.10.0.tar.gz",
+			"artifact":     map[string]interface{}{"filename": "ns-name-1.10.0.tar.gz", "sha256": "abc1.10.0", "size": 0.0},
+		}},
+		{"/api/v3/collections/ns/name/versions/9.9.9/", 404, nil},
+		{"/api/v3/collections/ns/missing/versions/", 404, nil},
+		{"/api/v3/artifacts/collections/ns-name-1.10.0.tar.gz", 200, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.expected == nil {
+				return
+			}
+			var body map[string]interface{}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range tt.expected {
+				if value == nil {
+					continue
+				}
+				if fmt.Sprint(body[key]) != fmt.Sprint(value) {
+					t.Errorf("%s = %v, want %v", key, body[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestGalaxyAPIReloadsWithinMtimeResolution(t *testing.T) {
+	dir := t.TempDir()
+	manifest := func(version string) repository.CollectionManifest {
+		m := repository.CollectionManifest{}
+		m.CollectionInfo.Namespace = "ns"
+		m.CollectionInfo.Name = "name"
+		m.CollectionInfo.Version = version
+		return m
+	}
+	metaPath := filepath.Join(dir, "repometa.json")
+	writeTestRepo(t, dir, []repository.CollectionManifest{manifest("1.0.0")})
+	info, err := os.Stat(metaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &repoHandler{dir: dir}
+	g := &galaxyAPI{repo: repo}
+	if err := g.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// an upload in the same second leaves repometa.json looking unchanged
+	writeTestRepo(t, dir, []repository.CollectionManifest{manifest("1.0.0"), manifest("1.1.0")})
+	os.Chtimes(metaPath, info.ModTime(), info.ModTime())
+	repo.indexLock.Lock()
+	repo.generation++
+	repo.indexLock.Unlock()
+
+	if err := g.load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(g.collections["ns.name"]); got != 2 {
+		t.Errorf("load() after a rewrite kept %d versions, want 2", got)
+	}
+}
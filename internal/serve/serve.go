@@ -0,0 +1,372 @@
+package serve
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/repository"
+	"github.com/jctanner/lax/internal/types"
+	"github.com/jctanner/lax/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// HealthPath reports whether the repo has a readable repometa.json
+const HealthPath = "/healthz"
+
+/*
+Serve publishes a lax repo directory over http until it gets SIGINT or
+SIGTERM. Files are served with ETag, Last-Modified and Range support so
+HttpRepoClient can resume downloads, and the json index is gzipped for
+clients that ask. Dotfiles and .part files are never served. With
+--galaxy-api the collections are also published as a read-only Galaxy v3
+api for ansible-galaxy, and with an upload token lax publish can add
+artifacts.
+*/
+func Serve(kwargs *types.CmdKwargs) error {
+	dir, err := utils.GetAbsPath(utils.ExpandUser(kwargs.DestDir))
+	if err != nil {
+		return err
+	}
+	if !utils.IsDir(dir) {
+		return laxerrors.New(laxerrors.ErrNotFound, "%s is not a directory", dir)
+	}
+
+	repo := &repoHandler{dir: dir}
+	if !utils.IsFile(filepath.Join(dir, "repometa.json")) {
+		logrus.Warnf("%s has no repometa.json, run createrepo or use --watch", dir)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(HealthPath, repo.health)
+	mux.Handle("/", repo)
+	uploadToken, err := readUploadToken(kwargs.UploadTokenFile)
+	if err != nil {
+		return err
+	}
+	if uploadToken != "" {
+		mux.Handle("POST "+repository.UploadPath, &uploadHandler{repo: repo, token: uploadToken})
+		logrus.Infof("uploads enabled on %s", repository.UploadPath)
+	}
+	if kwargs.GalaxyAPI {
+		(&galaxyAPI{repo: repo}).register(mux)
+		logrus.Infof("galaxy v3 api enabled under %s", GalaxyAPIPrefix)
+	}
+	server := &http.Server{
+		Addr:              kwargs.Listen,
+		Handler:           accessLog(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	listener, err := net.Listen("tcp", kwargs.Listen)
+	if err != nil {
+		return laxerrors.Wrap(laxerrors.ErrUsage, err, "can't listen on %s", kwargs.Listen)
+	}
+	logrus.Infof("serving %s on http://%s", dir, listener.Addr())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if kwargs.Watch {
+		go repo.watch(ctx, kwargs.WatchInterval)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	logrus.Infof("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+/*
+repoHandler serves files from dir. The index files at the top of the
+repo are rewritten in place by createrepo, so --watch holds indexLock
+while it reindexes and requests for them wait. Artifacts are never
+rewritten and aren't held up.
+*/
+type repoHandler struct {
+	dir       string
+	indexLock sync.RWMutex
+	// bumped under the write lock whenever serve itself rewrites the index
+	generation uint64
+}
+
+func (h *repoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	if !servable(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !strings.Contains(strings.TrimPrefix(name, "/"), "/") {
+		h.indexLock.RLock()
+		defer h.indexLock.RUnlock()
+	}
+
+	f, err := os.Open(filepath.Join(h.dir, filepath.FromSlash(name)))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	contentType := contentTypeFor(name)
+	w.Header().Set("Content-Type", contentType)
+	etag := fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+
+	// ranges are always of the identity encoding, only gzip whole responses
+	if compressible(contentType) && r.Header.Get("Range") == "" && acceptsGzip(r) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+`-gzip"`)
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		http.ServeContent(gw, r, name, info.ModTime(), f)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// servable hides dotfiles (like galaxy-sync's .cache) and partial downloads
+func servable(name string) bool {
+	if strings.HasSuffix(name, utils.PartSuffix) {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+func contentTypeFor(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "application/gzip"
+	case strings.HasSuffix(name, ".json"):
+		return "application/json"
+	case strings.HasSuffix(name, ".yml"), strings.HasSuffix(name, ".yaml"):
+		return "application/yaml"
+	}
+	return "application/octet-stream"
+}
+
+func compressible(contentType string) bool {
+	return contentType == "application/json" || contentType == "application/yaml"
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter compresses a full 200 response, anything else is passed through
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if status == http.StatusOK {
+		g.Header().Del("Content-Length")
+		g.Header().Set("Content-Encoding", "gzip")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if g.gz == nil {
+		return g.ResponseWriter.Write(b)
+	}
+	return g.gz.Write(b)
+}
+
+func (g *gzipResponseWriter) Close() error {
+	if g.gz == nil {
+		return nil
+	}
+	return g.gz.Close()
+}
+
+// health answers 200 with the repo date, or 503 when there is no usable index
+func (h *repoHandler) health(w http.ResponseWriter, r *http.Request) {
+	h.indexLock.RLock()
+	data, err := os.ReadFile(filepath.Join(h.dir, "repometa.json"))
+	h.indexLock.RUnlock()
+
+	status := struct {
+		Status string `json:"status"`
+		Date   string `json:"date,omitempty"`
+		Error  string `json:"error,omitempty"`
+	}{Status: "ok"}
+
+	var meta repository.RepoMeta
+	if err == nil {
+		err = json.Unmarshal(data, &meta)
+	}
+	code := http.StatusOK
+	if err != nil {
+		code = http.StatusServiceUnavailable
+		status.Status = "unavailable"
+		status.Error = "repometa.json is missing or invalid"
+	}
+	status.Date = meta.Date
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+/*
+watch polls the artifact dirs and reruns createrepo once a change has
+settled, i.e. two polls in a row saw the same files. That keeps it from
+indexing an artifact that is still being copied in.
+*/
+func (h *repoHandler) watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	logrus.Infof("watching %s for new artifacts every %s", h.dir, interval)
+
+	// a repo without an index gets one straight away
+	previous := h.artifactSignature()
+	indexed := ""
+	if utils.IsFile(filepath.Join(h.dir, "repometa.json")) {
+		indexed = previous
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		current := h.artifactSignature()
+		if current != indexed && current == previous {
+			logrus.Infof("artifacts in %s changed, reindexing", h.dir)
+			if err := h.reindex(); err != nil {
+				logrus.Errorf("reindex failed: %s", err)
+			} else {
+				indexed = current
+			}
+		}
+		previous = current
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *repoHandler) reindex() error {
+	h.indexLock.Lock()
+	defer h.indexLock.Unlock()
+	h.generation++
+	return repository.CreateRepo(&types.CmdKwargs{DestDir: h.dir})
+}
+
+// artifactSignature lists every artifact with its size and mtime
+func (h *repoHandler) artifactSignature() string {
+	var sb strings.Builder
+	for _, sub := range []string{"collections", "roles"} {
+		files, err := utils.ListTarGzFiles(filepath.Join(h.dir, sub))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			logrus.Debugf("%s", err)
+		}
+		for _, f := range files {
+			if info, err := os.Stat(f); err == nil {
+				fmt.Fprintf(&sb, "%s %d %d\n", f, info.Size(), info.ModTime().UnixNano())
+			}
+		}
+	}
+	return sb.String()
+}
+
+// statusRecorder remembers what was sent for the access log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps io.Copy from bypassing the byte count
+func (s *statusRecorder) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{s}, r)
+}
+
+// accessLog logs one line per request, as structured fields with --log-format json
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		logrus.WithFields(logrus.Fields{
+			"remote":   r.RemoteAddr,
+			"method":   r.Method,
+			"path":     r.URL.RequestURI(),
+			"status":   rec.status,
+			"bytes":    rec.bytes,
+			"duration": time.Since(start).Round(time.Microsecond).String(),
+			"agent":    r.UserAgent(),
+		}).Info("access")
+	})
+}
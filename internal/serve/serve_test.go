@@ -0,0 +1,80 @@
+package serve
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepoHandler(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "collections"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".cache"), 0755)
+	os.WriteFile(filepath.Join(dir, "repometa.json"), []byte(`{"date": "2024-06-01T12:00:00Z"}`), 0644)
+	os.WriteFile(filepath.Join(dir, "collections", "ns-name-1.0.0.tar.gz"), []byte("0123456789"), 0644)
+	os.WriteFile(filepath.Join(dir, "collections", "ns-name-2.0.0.tar.gz.part"), []byte("01234"), 0644)
+	os.WriteFile(filepath.Join(dir, ".cache", "secret.json"), []byte("{}"), 0644)
+
+	repo := &repoHandler{dir: dir}
+	mux := http.NewServeMux()
+	mux.HandleFunc(HealthPath, repo.health)
+	mux.Handle("/", repo)
+
+	tests := []struct {
+		name        string
+		path        string
+		header      map[string]string
+		status      int
+		contentType string
+		encoding    string
+	}{
+		{"index", "/repometa.json", nil, 200, "application/json", ""},
+		{"gzipped index", "/repometa.json", map[string]string{"Accept-Encoding": "gzip"}, 200, "application/json", "gzip"},
+		{"artifact", "/collections/ns-name-1.0.0.tar.gz", map[string]string{"Accept-Encoding": "gzip"}, 200, "application/gzip", ""},
+		{"range", "/collections/ns-name-1.0.0.tar.gz", map[string]string{"Range": "bytes=5-"}, 206, "application/gzip", ""},
+		{"partial download", "/collections/ns-name-2.0.0.tar.gz.part", nil, 404, "", ""},
+		{"dotfile", "/.cache/secret.json", nil, 404, "", ""},
+		{"escape", "/../../etc/passwd", nil, 404, "", ""},
+		{"directory", "/collections/", nil, 404, "", ""},
+		{"health", HealthPath, nil, 200, "application/json", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			// straight to the handler, the mux would redirect the escape attempt first
+			rec := httptest.NewRecorder()
+			if tt.path == HealthPath {
+				mux.ServeHTTP(rec, req)
+			} else {
+				repo.ServeHTTP(rec, req)
+			}
+
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d", rec.Code, tt.status)
+			}
+			if tt.contentType != "" && rec.Header().Get("Content-Type") != tt.contentType {
+				t.Errorf("content type %q, want %q", rec.Header().Get("Content-Type"), tt.contentType)
+			}
+			if rec.Header().Get("Content-Encoding") != tt.encoding {
+				t.Errorf("content encoding %q, want %q", rec.Header().Get("Content-Encoding"), tt.encoding)
+			}
+			if tt.status == 200 && tt.path != HealthPath && rec.Header().Get("ETag") == "" {
+				t.Errorf("missing ETag")
+			}
+		})
+	}
+
+	// without an index the repo is unhealthy
+	os.Remove(filepath.Join(dir, "repometa.json"))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, HealthPath, nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("health is %d without repometa.json", rec.Code)
+	}
+}
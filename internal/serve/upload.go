@@ -0,0 +1,140 @@
+package serve
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/repository"
+	"github.com/jctanner/lax/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// UploadTokenEnvVar is read for the upload token when --upload-token-file isn't given
+const UploadTokenEnvVar = "LAX_UPLOAD_TOKEN"
+
+// maxUploadSize is larger than anything galaxy accepts
+const maxUploadSize = 256 << 20
+
+// readUploadToken returns the token uploads must present, or "" when uploads are off
+func readUploadToken(tokenFile string) (string, error) {
+	if tokenFile != "" {
+		data, err := os.ReadFile(utils.ExpandUser(tokenFile))
+		if err != nil {
+			return "", laxerrors.Wrap(laxerrors.ErrUsage, err, "failed to read the upload token file")
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", laxerrors.New(laxerrors.ErrUsage, "%s is empty", tokenFile)
+		}
+		return token, nil
+	}
+	return strings.TrimSpace(os.Getenv(UploadTokenEnvVar)), nil
+}
+
+/*
+uploadHandler takes artifacts from lax publish. The token can be sent as
+a bearer token or as the password of basic auth, so a credentials file
+or netrc entry for the server works as well as a token. The artifact is
+published under the write side of indexLock, like a --watch reindex.
+*/
+type uploadHandler struct {
+	repo  *repoHandler
+	token string
+}
+
+func (u *uploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !u.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="lax"`)
+		uploadError(w, http.StatusUnauthorized, "a valid upload token is required")
+		return
+	}
+
+	// a dot dir isn't served or picked up by --watch while it's written
+	staging, err := os.MkdirTemp(u.repo.dir, ".upload-")
+	if err != nil {
+		logrus.Errorf("can't store an upload: %s", err)
+		uploadError(w, http.StatusInternalServerError, "can't store the upload")
+		return
+	}
+	defer os.RemoveAll(staging)
+
+	// roles without full metadata fall back to the name they were uploaded as
+	name := path.Base("/" + r.Header.Get("X-Lax-Filename"))
+	if name == "/" || strings.HasPrefix(name, ".") || strings.Contains(name, `\`) {
+		name = "artifact.tar.gz"
+	}
+	artifact := filepath.Join(staging, name)
+
+	f, err := os.Create(artifact)
+	if err != nil {
+		logrus.Errorf("can't store an upload: %s", err)
+		uploadError(w, http.StatusInternalServerError, "can't store the upload")
+		return
+	}
+	_, err = io.Copy(f, http.MaxBytesReader(w, r.Body, maxUploadSize))
+	f.Close()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			uploadError(w, http.StatusRequestEntityTooLarge, "the artifact is too large")
+			return
+		}
+		uploadError(w, http.StatusBadRequest, "failed to read the upload")
+		return
+	}
+
+	query := r.URL.Query()
+	opts := repository.PublishOptions{
+		Force:     query.Get("force") == "true",
+		Namespace: query.Get("namespace"),
+		Name:      query.Get("name"),
+		Version:   query.Get("version"),
+	}
+
+	u.repo.indexLock.Lock()
+	result, err := repository.PublishToDir(u.repo.dir, artifact, opts)
+	u.repo.generation++
+	u.repo.indexLock.Unlock()
+	if err != nil {
+		uploadError(w, uploadStatus(err), strings.ReplaceAll(err.Error(), staging+string(filepath.Separator), ""))
+		return
+	}
+	logrus.Infof("published %s %s.%s %s", result.Type, result.Namespace, result.Name, result.Version)
+	writeJSON(w, http.StatusCreated, result)
+}
+
+// authorized accepts the token as a bearer token or a basic auth password
+func (u *uploadHandler) authorized(r *http.Request) bool {
+	presented := ""
+	if _, password, ok := r.BasicAuth(); ok {
+		presented = password
+	} else if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		presented = strings.TrimSpace(token)
+	}
+	return presented != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(u.token)) == 1
+}
+
+// uploadStatus is the http status lax publish turns back into the same error kind
+func uploadStatus(err error) int {
+	switch {
+	case errors.Is(err, laxerrors.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, laxerrors.ErrChecksumMismatch):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, laxerrors.ErrUsage), errors.Is(err, laxerrors.ErrNotFound):
+		return http.StatusBadRequest
+	}
+	logrus.Errorf("publishing an upload failed: %s", err)
+	return http.StatusInternalServerError
+}
+
+func uploadError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
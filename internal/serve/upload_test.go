@@ -0,0 +1,70 @@
+package serve
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpload(t *testing.T) {
+	var role bytes.Buffer
+	gz := gzip.NewWriter(&role)
+	tw := tar.NewWriter(gz)
+	meta := "galaxy_info:\n  author: geer\n"
+	tw.WriteHeader(&tar.Header{Name: "docker/meta/main.yml", Mode: 0644, Size: int64(len(meta)), Typeflag: tar.TypeReg})
+	tw.Write([]byte(meta))
+	tw.Close()
+	gz.Close()
+
+	dir := t.TempDir()
+	upload := &uploadHandler{repo: &repoHandler{dir: dir}, token: "s3cret"}
+
+	tests := []struct {
+		name     string
+		query    string
+		filename string
+		auth     func(*http.Request)
+		body     []byte
+		status   int
+	}{
+		{"no token", "", "geer-docker-1.0.0.tar.gz", func(r *http.Request) {}, role.Bytes(), 401},
+		{"wrong token", "", "geer-docker-1.0.0.tar.gz", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, role.Bytes(), 401},
+		{"bearer token", "", "geer-docker-1.0.0.tar.gz", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, role.Bytes(), 201},
+		{"existing version", "", "geer-docker-1.0.0.tar.gz", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, role.Bytes(), 409},
+		{"basic auth with force", "?force=true", "geer-docker-1.0.0.tar.gz", func(r *http.Request) { r.SetBasicAuth("me", "s3cret") }, role.Bytes(), 201},
+		{"named by query", "?namespace=geer&name=java&version=2.0.0", "build.tar.gz", func(r *http.Request) { r.SetBasicAuth("me", "s3cret") }, role.Bytes(), 201},
+		{"unnamed role", "", "build.tar.gz", func(r *http.Request) { r.SetBasicAuth("me", "s3cret") }, role.Bytes(), 400},
+		{"not a tarball", "", "geer-docker-1.1.0.tar.gz", func(r *http.Request) { r.SetBasicAuth("me", "s3cret") }, []byte("junk"), 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/upload"+tt.query, bytes.NewReader(tt.body))
+			req.Header.Set("X-Lax-Filename", tt.filename)
+			tt.auth(req)
+			rec := httptest.NewRecorder()
+			upload.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+
+	for _, name := range []string{"geer-docker-1.0.0.tar.gz", "geer-java-2.0.0.tar.gz"} {
+		if _, err := os.Stat(filepath.Join(dir, "roles", name)); err != nil {
+			t.Error(err)
+		}
+	}
+	// nothing is left behind in the staging dirs
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.Name()[0] == '.' {
+			t.Errorf("%s was left in the repo", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package skeleton
+
+import (
+	"bytes"
+	"embed"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// TemplateSuffix marks the skeleton files that are rendered, the suffix is dropped from the name
+const TemplateSuffix = ".tmpl"
+
+// keepFile holds an otherwise empty directory in a skeleton and is not copied, like ansible-galaxy's
+const keepFile = ".git_keep"
+
+//go:embed all:skeletons
+var builtin embed.FS
+
+/*
+Vars are what a skeleton's .tmpl files can use, as {{ .Namespace }},
+{{ .Name }} and so on. Only .tmpl files are rendered, so role templates
+and other files with {{ }} in them are copied as they are.
+*/
+type Vars struct {
+	Namespace         string
+	Name              string
+	Version           string
+	Author            string
+	Description       string
+	Company           string
+	License           string
+	MinAnsibleVersion string
+}
+
+// DefaultVars are the placeholders ansible-galaxy fills a new role or collection with
+func DefaultVars(kind string, namespace string, name string) Vars {
+	return Vars{
+		Namespace:         namespace,
+		Name:              name,
+		Version:           "1.0.0",
+		Author:            "your name <example@domain.com>",
+		Description:       "your " + kind + " description",
+		Company:           "your company (optional)",
+		License:           "GPL-2.0-or-later",
+		MinAnsibleVersion: "2.15",
+	}
+}
+
+// Builtin is the skeleton lax ships for kind, which is "collection" or "role"
+func Builtin(kind string) fs.FS {
+	sub, err := fs.Sub(builtin, path.Join("skeletons", kind))
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// FromDir is a custom skeleton directory, as given to --role-skeleton or --collection-skeleton
+func FromDir(dir string) (fs.FS, error) {
+	abs, err := utils.GetAbsPath(utils.ExpandUser(dir))
+	if err != nil {
+		return nil, err
+	}
+	if !utils.IsDir(abs) {
+		return nil, laxerrors.New(laxerrors.ErrNotFound, "skeleton %s is not a directory", dir)
+	}
+	return os.DirFS(abs), nil
+}
+
+/*
+Render copies skel to dest, rendering the .tmpl files with vars. dest
+must not exist yet, or be empty, unless force is set, in which case
+files from the skeleton replace the ones already there and everything
+else is left alone. It returns the files it wrote, relative to dest.
+*/
+func Render(skel fs.FS, dest string, vars Vars, force bool) ([]string, error) {
+	existed := utils.FileExists(dest)
+	if existed && !force {
+		entries, err := os.ReadDir(dest)
+		if err != nil || len(entries) > 0 {
+			return nil, laxerrors.New(laxerrors.ErrConflict, "%s already exists, use --force to write over it", dest)
+		}
+	}
+
+	written := []string{}
+	err := fs.WalkDir(skel, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return fs.SkipDir
+			}
+			return utils.MakeDirs(filepath.Join(dest, filepath.FromSlash(p)))
+		}
+		if d.Name() == keepFile {
+			return nil
+		}
+
+		data, err := fs.ReadFile(skel, p)
+		if err != nil {
+			return err
+		}
+		name := p
+		if strings.HasSuffix(name, TemplateSuffix) {
+			name = strings.TrimSuffix(name, TemplateSuffix)
+			data, err = renderTemplate(p, data, vars)
+			if err != nil {
+				return err
+			}
+		}
+
+		mode := os.FileMode(0644)
+		if info, err := d.Info(); err == nil && info.Mode()&0100 != 0 {
+			mode = 0755
+		}
+		logrus.Debugf("writing %s", filepath.Join(dest, filepath.FromSlash(name)))
+		if err := os.WriteFile(filepath.Join(dest, filepath.FromSlash(name)), data, mode); err != nil {
+			return err
+		}
+		written = append(written, name)
+		return nil
+	})
+	// don't leave half a skeleton behind
+	if err != nil && !existed {
+		os.RemoveAll(dest)
+	}
+	return written, err
+}
+
+func renderTemplate(name string, data []byte, vars Vars) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, laxerrors.Wrap(laxerrors.ErrUsage, err, "skeleton file %s is not a valid template", name)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return nil, laxerrors.Wrap(laxerrors.ErrUsage, err, "failed to render skeleton file %s", name)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,113 @@
+package skeleton
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/jctanner/lax/internal/utils"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		skel     fstest.MapFS
+		existing bool
+		force    bool
+		expected map[string]string
+		err      error
+	}{
+		{
+			"templates and plain files",
+			fstest.MapFS{
+				"meta/main.yml.tmpl":    {Data: []byte("role_name: {{ .Name }}\nnamespace: {{ .Namespace }}\n")},
+				"templates/app.conf.j2": {Data: []byte("port={{ port }}\n")},
+				"files/.git_keep":       {Data: nil},
+				".git/HEAD":             {Data: []byte("ref: main")},
+				"scripts/run.sh":        {Data: []byte("#!/bin/sh\n"), Mode: 0755},
+			},
+			false,
+			false,
+			map[string]string{
+				"meta/main.yml":         "role_name: web\nnamespace: acme\n",
+				"templates/app.conf.j2": "port={{ port }}\n",
+				"scripts/run.sh":        "#!/bin/sh\n",
+			},
+			nil,
+		},
+		{
+			"existing dir",
+			fstest.MapFS{"README.md": {Data: []byte("new")}},
+			true,
+			false,
+			nil,
+			laxerrors.ErrConflict,
+		},
+		{
+			"existing dir with force",
+			fstest.MapFS{"README.md": {Data: []byte("new")}},
+			true,
+			true,
+			map[string]string{"README.md": "new", "keep.txt": "mine"},
+			nil,
+		},
+		{
+			"unknown variable",
+			fstest.MapFS{"a.txt": {Data: []byte("a")}, "b.txt.tmpl": {Data: []byte("{{ .Nope }}")}},
+			false,
+			false,
+			nil,
+			laxerrors.ErrUsage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "acme.web")
+			if tt.existing {
+				os.MkdirAll(dest, 0755)
+				os.WriteFile(filepath.Join(dest, "keep.txt"), []byte("mine"), 0644)
+				os.WriteFile(filepath.Join(dest, "README.md"), []byte("old"), 0644)
+			}
+
+			_, err := Render(tt.skel, dest, DefaultVars("role", "acme", "web"), tt.force)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				if !tt.existing {
+					if _, err := os.Stat(dest); !os.IsNotExist(err) {
+						t.Error("a failed render left the directory behind")
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]string{}
+			filepath.Walk(dest, func(p string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					rel, _ := filepath.Rel(dest, p)
+					data, _ := os.ReadFile(p)
+					got[filepath.ToSlash(rel)] = string(data)
+				}
+				return nil
+			})
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("files\n got %v\nwant %v", got, tt.expected)
+			}
+			if _, ok := tt.skel["files/.git_keep"]; ok && !utils.IsDir(filepath.Join(dest, "files")) {
+				t.Error("the empty files dir wasn't created")
+			}
+			if info, err := os.Stat(filepath.Join(dest, "scripts", "run.sh")); err == nil && info.Mode()&0100 == 0 {
+				t.Error("run.sh lost its executable bit")
+			}
+		})
+	}
+}
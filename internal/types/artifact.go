@@ -0,0 +1,10 @@
+package types
+
+// ArtifactInfo describes the tarball an index entry was built from
+type ArtifactInfo struct {
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	// where the tarball is, relative to the repo root. indexes written
+	// before repo layouts don't say, their artifacts are laid out flat
+	Path string `json:"path,omitempty"`
+}
@@ -1,10 +1,13 @@
 package types
 
+import "time"
+
 type CmdKwargs struct {
 	Server              string
 	ApiPrefix           string
 	AuthUrl             string
 	Token               string
+	TokenFile           string
 	DestDir             string
 	CacheDir            string
 	CollectionsOnly     bool
@@ -17,4 +20,35 @@ type CmdKwargs struct {
 	RequirementsFile    string
 	DownloadConcurrency int
 	Verbose             bool
+	Quiet               bool
+	LogFormat           string
+	LogFile             string
+	ConnectTimeout      time.Duration
+	ResponseTimeout     time.Duration
+	Retries             int
+	Proxy               string
+	NoProxy             string
+	CACert              string
+	ClientCert          string
+	ClientKey           string
+	Insecure            bool
+	CredentialsFile     string
+	NetrcFile           string
+	NetrcDefault        bool
+	OutputFormat        string
+	ShowTree            bool
+	DryRun              bool
+	Force               bool
+	ForceWithDeps       bool
+	Listen              string
+	Watch               bool
+	GalaxyAPI           bool
+	WatchInterval       time.Duration
+	UploadTokenFile     string
+	Skeleton            string
+	From                string
+	KeepLatest          int
+	KeepSince           string
+	KeepRequirements    []string
+	Layout              string
 }
@@ -1,4 +1,4 @@
-package galaxy_sync
+package types
 
 import (
 	"os"
@@ -25,7 +25,8 @@ type RoleRequirement struct {
 	Version string `yaml:"version,omitempty"`
 }
 
-func parseRequirements(filename string) (*Requirements, error) {
+// ParseRequirementsFile reads a requirements.yml, a pinned one works as a lockfile
+func ParseRequirementsFile(filename string) (*Requirements, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
@@ -12,6 +12,24 @@ type rawYAML struct {
 
 type RoleMeta struct {
 	GalaxyInfo GalaxyInfo `yaml:"galaxy_info"`
+
+	// set by createrepo, never read from meta/main.yml
+	Artifact ArtifactInfo `yaml:"-" json:"artifact"`
+}
+
+// RoleBuildInfoFile is where lax role build records a role's identity, relative to the role's top dir
+const RoleBuildInfoFile = "meta/lax_role.json"
+
+/*
+RoleBuildInfo is the content of RoleBuildInfoFile. Roles built by lax
+carry it so the repo tools read the namespace, name and version instead
+of guessing them from meta/main.yml and the tarball's filename.
+*/
+type RoleBuildInfo struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Format    int    `json:"format"`
 }
 
 type GalaxyInfo struct {
@@ -25,8 +43,11 @@ type GalaxyInfo struct {
 	Version string `yaml:"version"`
 
 	Description       string           `yaml:"description"`
+	Company           string           `yaml:"company"`
 	License           RoleLicense      `yaml:"license"`
-	MinAnsibleVersion string           `json:"min_ansible_version"`
+	IssueTrackerUrl   string           `yaml:"issue_tracker_url"`
+	GithubBranch      string           `yaml:"github_branch"`
+	MinAnsibleVersion string           `yaml:"min_ansible_version" json:"min_ansible_version"`
 	Platforms         []RolePlatform   `yaml:"platforms"`
 	GalaxyTags        []GalaxyTags     `yaml:"galaxy_tags"`
 	Dependencies      []RoleDependency `yaml:"dependencies"`
@@ -166,7 +187,6 @@ func (gt *GalaxyTags) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 	var raw rawYAML
 	if err := unmarshal(&raw); err != nil {
-		fmt.Printf("ERROR %s\n", err)
 		return err
 	}
 
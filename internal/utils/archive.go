@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ArchiveEntry is one file, directory or symlink written by WriteTarGz
+type ArchiveEntry struct {
+	// slash separated path inside the archive
+	Name string
+	Dir  bool
+	// the target, relative to the link, for a symlink
+	Link string
+	Exec bool
+	// of the file content, empty for directories
+	Sha256 string
+	// the content comes from Data if it's set and from Source otherwise
+	Data   []byte
+	Source string
+}
+
+/*
+NewArchiveEntry describes the path rel under root. A symlink that points
+inside root is kept as a symlink, one that points outside is copied if
+it's a file and left out (ok is false) if it's a directory, which is how
+ansible-galaxy builds treat them.
+*/
+func NewArchiveEntry(root string, rel string, info os.FileInfo) (entry ArchiveEntry, ok bool, err error) {
+	abs := filepath.Join(root, filepath.FromSlash(rel))
+	entry = ArchiveEntry{Name: rel, Dir: info.IsDir(), Exec: info.Mode()&0100 != 0, Source: abs}
+
+	if IsLink(abs) {
+		target, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return entry, false, err
+		}
+		if isInside(root, target) {
+			link, err := filepath.Rel(filepath.Dir(abs), target)
+			if err != nil {
+				return entry, false, err
+			}
+			entry.Link = filepath.ToSlash(link)
+		} else if entry.Dir {
+			logrus.Warnf("skipping %s, it is a symlink to a directory outside %s", abs, root)
+			return entry, false, nil
+		}
+	}
+
+	if !entry.Dir {
+		entry.Sha256, _, err = Sha256File(abs)
+		if err != nil {
+			return entry, false, err
+		}
+	}
+	return entry, true, nil
+}
+
+func isInside(root string, target string) bool {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		realRoot = root
+	}
+	rel, err := filepath.Rel(realRoot, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+/*
+ArchiveEntriesFromDir walks root in sorted order, each directory before
+its contents, leaving out anything skip returns true for. Symlinked
+directories are listed but not walked.
+*/
+func ArchiveEntriesFromDir(root string, skip func(rel string, info os.FileInfo) bool) ([]ArchiveEntry, error) {
+	var entries []ArchiveEntry
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		dirEntries, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(dir)))
+		if err != nil {
+			return err
+		}
+		for _, e := range dirEntries {
+			rel := path.Join(dir, e.Name())
+			abs := filepath.Join(root, filepath.FromSlash(rel))
+			info, err := os.Stat(abs)
+			if err != nil {
+				logrus.Warnf("skipping %s: %s", abs, err)
+				continue
+			}
+			if skip(rel, info) {
+				logrus.Debugf("skipping %s", abs)
+				continue
+			}
+			entry, ok, err := NewArchiveEntry(root, rel, info)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			entries = append(entries, entry)
+			if entry.Dir && entry.Link == "" {
+				if err := walk(rel); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return entries, walk("")
+}
+
+// SourceDateEpoch is the mtime for reproducible archives, $SOURCE_DATE_EPOCH or the unix epoch
+func SourceDateEpoch() time.Time {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		if seconds, err := strconv.ParseInt(epoch, 10, 64); err == nil {
+			return time.Unix(seconds, 0)
+		}
+		logrus.Warnf("ignoring SOURCE_DATE_EPOCH=%s, it is not a number of seconds", epoch)
+	}
+	return time.Unix(0, 0)
+}
+
+/*
+WriteTarGz writes entries in the order given. Every entry gets the same
+mtime, root ownership and 0644 or 0755 permissions, so the same entries
+always make the same bytes.
+*/
+func WriteTarGz(dest string, entries []ArchiveEntry, mtime time.Time) error {
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	for _, e := range entries {
+		h := &tar.Header{Name: e.Name, Mode: 0644, ModTime: mtime, Typeflag: tar.TypeReg}
+		switch {
+		case e.Link != "":
+			h.Typeflag = tar.TypeSymlink
+			h.Linkname = e.Link
+		case e.Dir:
+			h.Typeflag = tar.TypeDir
+			h.Name += "/"
+			h.Mode = 0755
+		case e.Exec:
+			h.Mode = 0755
+		}
+		if err := writeArchiveEntry(tw, h, e); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func writeArchiveEntry(tw *tar.Writer, h *tar.Header, e ArchiveEntry) error {
+	if h.Typeflag != tar.TypeReg {
+		return tw.WriteHeader(h)
+	}
+	if e.Data != nil || e.Source == "" {
+		h.Size = int64(len(e.Data))
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		_, err := tw.Write(e.Data)
+		return err
+	}
+
+	src, err := os.Open(e.Source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	h.Size = info.Size()
+	if err := tw.WriteHeader(h); err != nil {
+		return err
+	}
+	// a file that changes while it's copied would no longer match its checksum
+	if n, err := io.Copy(tw, src); err != nil || n != h.Size {
+		return fmt.Errorf("%s changed while it was being archived", e.Source)
+	}
+	return nil
+}
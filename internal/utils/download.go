@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jctanner/lax/internal/httpclient"
+	"github.com/jctanner/lax/internal/laxerrors"
+	"github.com/sirupsen/logrus"
+)
+
+// PartSuffix is appended to a file while it is being downloaded
+const PartSuffix = ".part"
+
+// DownloadOptions describe what a download should look like when it's done
+type DownloadOptions struct {
+	// extra request headers, e.g. Authorization
+	Header http.Header
+	// the expected size in bytes, 0 if unknown
+	Size int64
+	// the expected sha256 hex digest, empty if unknown
+	Sha256 string
+	// always start over, for files like indexes whose parts can't be checked
+	NoResume bool
+}
+
+// validatorPath is where the ETag or Last-Modified a .part file was started from is kept
+func validatorPath(partPath string) string {
+	return strings.TrimSuffix(partPath, PartSuffix) + ".validator" + PartSuffix
+}
+
+/*
+DownloadToPath fetches a url into filePath without ever leaving a partial
+file at filePath. The body is streamed into filePath + ".part", resuming
+an earlier partial download with a Range request when the server
+supports it, and is only renamed into place once the size and digest
+(when known) check out. A resumed request carries the ETag or
+Last-Modified the download started from as If-Range, so a file that
+changed on the server is fetched whole instead of spliced onto the old
+bytes. A failed transfer keeps the .part file so the next attempt can
+resume it; a bad one is removed.
+*/
+func DownloadToPath(urlStr string, filePath string, opts DownloadOptions) error {
+	partPath := filePath + PartSuffix
+	if opts.NoResume {
+		os.Remove(partPath)
+	}
+	defer func() {
+		// the validator only means something next to the .part file it came with
+		if !FileExists(partPath) {
+			os.Remove(validatorPath(partPath))
+		}
+	}()
+
+	for attempt := 0; ; attempt++ {
+		resumed, err := fetchPart(urlStr, partPath, opts)
+		if err == errRestartDownload && attempt == 0 {
+			logrus.Debugf("%s can't resume %s, starting over", urlStr, partPath)
+			os.Remove(partPath)
+			continue
+		}
+		if err == errRestartDownload {
+			return laxerrors.New(laxerrors.ErrDownloadFailed, "%s rejected the request for %s", urlStr, partPath)
+		}
+		if err != nil {
+			// keep the .part file for the next attempt to resume
+			return err
+		}
+
+		if err := verifyPart(partPath, opts); err != nil {
+			// the resumed bytes may be what's wrong, so try once more from scratch
+			if resumed && attempt == 0 {
+				logrus.Debugf("restarting the download of %s: %s", urlStr, err)
+				continue
+			}
+			return err
+		}
+		return os.Rename(partPath, filePath)
+	}
+}
+
+var errRestartDownload = errors.New("restart download")
+
+// fetchPart downloads into partPath and reports whether it picked up an earlier partial file
+func fetchPart(urlStr string, partPath string, opts DownloadOptions) (bool, error) {
+	var offset int64
+	if fi, err := os.Stat(partPath); err == nil {
+		offset = fi.Size()
+	}
+	validator, _ := os.ReadFile(validatorPath(partPath))
+	if offset > 0 && len(validator) == 0 && opts.Sha256 == "" {
+		// nothing says the bytes on disk are from the file the server has now
+		logrus.Debugf("can't tell whether %s is still the same file, starting over", partPath)
+		offset = 0
+	}
+	if opts.Size > 0 && offset >= opts.Size {
+		// nothing left to fetch, let the verification decide
+		return true, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	if err != nil {
+		return false, err
+	}
+	for key, values := range opts.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if len(validator) > 0 {
+			req.Header.Set("If-Range", string(validator))
+		}
+		logrus.Debugf("resuming %s at %d bytes", urlStr, offset)
+	}
+
+	resp, err := httpclient.Default().Do(req)
+	if err != nil {
+		return false, laxerrors.Wrap(laxerrors.ErrDownloadFailed, err, "failed to download %s", urlStr)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 && contentRangeStart(resp) == offset:
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusOK:
+		// a fresh download, or the server ignored the range or the file changed, start over
+		flags |= os.O_TRUNC
+		offset = 0
+		if err := saveValidator(partPath, resp); err != nil {
+			return false, err
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		return true, errRestartDownload
+	default:
+		return false, laxerrors.FromHTTPStatus(urlStr, resp)
+	}
+
+	if err := MakeDirs(filepath.Dir(partPath)); err != nil {
+		return false, err
+	}
+	outFile, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return false, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer outFile.Close()
+
+	body := TrackDownload(strings.TrimSuffix(partPath, PartSuffix), resp.ContentLength, resp.Body)
+	if _, err := io.Copy(outFile, body); err != nil {
+		return offset > 0, laxerrors.Wrap(laxerrors.ErrDownloadFailed, err, "failed to save %s", partPath)
+	}
+	return offset > 0, outFile.Close()
+}
+
+// saveValidator remembers what a later resume has to send as If-Range, weak etags can't be used for that
+func saveValidator(partPath string, resp *http.Response) error {
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if validator == "" {
+		os.Remove(validatorPath(partPath))
+		return nil
+	}
+	if err := MakeDirs(filepath.Dir(partPath)); err != nil {
+		return err
+	}
+	return os.WriteFile(validatorPath(partPath), []byte(validator), 0644)
+}
+
+// contentRangeStart reads the first byte position from "bytes start-end/total"
+func contentRangeStart(resp *http.Response) int64 {
+	value := strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	dash := strings.Index(value, "-")
+	if dash < 0 {
+		return -1
+	}
+	start, err := strconv.ParseInt(value[:dash], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
+
+// verifyPart checks a finished .part file, removing it if it's bad
+func verifyPart(partPath string, opts DownloadOptions) error {
+	if opts.Size <= 0 && opts.Sha256 == "" {
+		return nil
+	}
+	digest, size, err := Sha256File(partPath)
+	if err != nil {
+		return err
+	}
+	if opts.Size > 0 && size != opts.Size {
+		os.Remove(partPath)
+		return laxerrors.New(laxerrors.ErrDownloadFailed, "%s is %d bytes, expected %d", partPath, size, opts.Size)
+	}
+	if opts.Sha256 != "" && digest != opts.Sha256 {
+		os.Remove(partPath)
+		return laxerrors.New(laxerrors.ErrChecksumMismatch, "%s has sha256 %s, expected %s", partPath, digest, opts.Sha256)
+	}
+	return nil
+}
+
+/*
+RemoveStalePartFiles deletes .part files under dir that haven't been
+touched for maxAge. Recent ones are left for a later download to resume.
+*/
+func RemoveStalePartFiles(dir string, maxAge time.Duration) {
+	cutoff := time.Now().Add(-maxAge)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, PartSuffix) {
+			return nil
+		}
+		if info.ModTime().Before(cutoff) {
+			logrus.Debugf("removing stale partial download %s", path)
+			os.Remove(path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jctanner/lax/internal/laxerrors"
+)
+
+func TestDownloadToPath(t *testing.T) {
+	content := []byte(strings.Repeat("lax artifact data ", 1000))
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	var ranges []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ranged", func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "ranged", time.Time{}, bytes.NewReader(content))
+	})
+	mux.HandleFunc("/etag", func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, strings.TrimSpace(r.Header.Get("Range")+" "+r.Header.Get("If-Range")))
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "etag", time.Time{}, bytes.NewReader(content))
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Write(content)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		path      string
+		part      []byte
+		validator string
+		opts      DownloadOptions
+		ranges    []string
+		expected  error
+	}{
+		{"fresh", "/ranged", nil, "", DownloadOptions{Size: int64(len(content)), Sha256: digest}, []string{""}, nil},
+		{"resumed", "/ranged", content[:500], "", DownloadOptions{Size: int64(len(content)), Sha256: digest}, []string{"bytes=500-"}, nil},
+		{"range ignored", "/plain", []byte("garbage"), "", DownloadOptions{Sha256: digest}, []string{"bytes=7-"}, nil},
+		{"bad resume starts over", "/ranged", []byte("garbage"), "", DownloadOptions{Sha256: digest}, []string{"bytes=7-", ""}, nil},
+		{"bad digest", "/ranged", nil, "", DownloadOptions{Sha256: strings.Repeat("0", 64)}, []string{""}, laxerrors.ErrChecksumMismatch},
+		{"missing", "/missing", nil, "", DownloadOptions{}, nil, laxerrors.ErrDownloadFailed},
+		{"resumed with if-range", "/etag", content[:500], `"v2"`, DownloadOptions{}, []string{`bytes=500- "v2"`}, nil},
+		{"changed on the server", "/etag", []byte("garbage"), `"v1"`, DownloadOptions{}, []string{`bytes=7- "v1"`}, nil},
+		{"unknown origin starts over", "/etag", []byte("garbage"), "", DownloadOptions{}, []string{""}, nil},
+		{"never resumed", "/etag", content[:500], `"v2"`, DownloadOptions{NoResume: true}, []string{""}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges = nil
+			dest := filepath.Join(t.TempDir(), "ns-name-1.0.0.tar.gz")
+			if tt.part != nil {
+				os.WriteFile(dest+PartSuffix, tt.part, 0644)
+			}
+			if tt.validator != "" {
+				os.WriteFile(validatorPath(dest+PartSuffix), []byte(tt.validator), 0644)
+			}
+
+			err := DownloadToPath(server.URL+tt.path, dest, tt.opts)
+			if tt.expected != nil {
+				if !errors.Is(err, tt.expected) {
+					t.Fatalf("got %v, want %v", err, tt.expected)
+				}
+				if FileExists(dest) {
+					t.Errorf("%s should not exist after a failed download", dest)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, _ := os.ReadFile(dest)
+				if !bytes.Equal(data, content) {
+					t.Errorf("downloaded %d bytes that don't match the %d served", len(data), len(content))
+				}
+			}
+			if (FileExists(dest+PartSuffix) || FileExists(validatorPath(dest+PartSuffix))) && tt.expected == nil {
+				t.Errorf("%s was left behind", dest+PartSuffix)
+			}
+			if strings.Join(ranges, ",") != strings.Join(tt.ranges, ",") {
+				t.Errorf("range headers %q, want %q", ranges, tt.ranges)
+			}
+		})
+	}
+}
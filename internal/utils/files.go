@@ -10,6 +10,8 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 
 	"github.com/sirupsen/logrus"
@@ -37,7 +39,7 @@ func (fs *FileStore) FindByGlob(pattern string) []string {
 	for _, file := range fs.Files {
 		matched, err := filepath.Match(pattern, file.Path)
 		if err != nil {
-			fmt.Println("Error matching pattern:", err)
+			logrus.Errorf("error matching pattern %s: %s", pattern, err)
 			continue
 		}
 		if matched {
@@ -51,7 +53,7 @@ func ExpandUser(path string) string {
 	if strings.HasPrefix(path, "~") {
 		homeDir, err := os.UserHomeDir()
 		if err != nil {
-			fmt.Printf("%s\n", err)
+			logrus.Warnf("can't expand %s: %s", path, err)
 		}
 		path = strings.Replace(path, "~", homeDir, 1)
 	}
@@ -61,7 +63,7 @@ func ExpandUser(path string) string {
 func GetAbsPath(path string) (string, error) {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
-		fmt.Printf("%s\n", err)
+		logrus.Debugf("can't make %s absolute: %s", path, err)
 		return "", err
 	}
 	return absPath, err
@@ -322,6 +324,23 @@ func ExtractFilesFromTarGz(filepath string, filenamesToExtract []string) (map[st
 	return filesContent, nil
 }
 
+// Sha256File returns the hex encoded sha256 digest and size of a file
+func Sha256File(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
 func CopyFile(src string, dst string) error {
 	// Open the source file
 	srcFile, err := os.Open(src)
@@ -367,6 +386,15 @@ func ExtractTarGz(tarGzPath, dest string) error {
 	}
 	defer uncompressedStream.Close()
 
+	// Resolve dest once so every entry is checked against the real directory
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("create directory: %v", err)
+	}
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return fmt.Errorf("resolve destination: %v", err)
+	}
+
 	// Create tar reader
 	tarReader := tar.NewReader(uncompressedStream)
 
@@ -383,6 +411,18 @@ func ExtractTarGz(tarGzPath, dest string) error {
 		// Determine the target file path
 		target := filepath.Join(dest, header.Name)
 
+		// Entries may not land outside dest, including through links the
+		// archive created earlier. A symlink entry replaces its own name, so
+		// only its parent is followed.
+		checked := header.Name
+		if header.Typeflag == tar.TypeSymlink {
+			checked = filepath.Dir(header.Name)
+		}
+		resolved, err := resolveWithin(root, root, checked)
+		if err != nil {
+			return fmt.Errorf("entry %s: %v", header.Name, err)
+		}
+
 		// Ensure the parent directory exists
 		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
 			return fmt.Errorf("create directory: %v", err)
@@ -412,6 +452,18 @@ func ExtractTarGz(tarGzPath, dest string) error {
 			if err := os.Chmod(target, os.FileMode(header.Mode)); err != nil {
 				return fmt.Errorf("set file permissions: %v", err)
 			}
+		case tar.TypeSymlink:
+			// collections may link between their own files, never outside
+			if filepath.IsAbs(header.Linkname) {
+				return fmt.Errorf("symlink %s points outside the archive", header.Name)
+			}
+			if _, err := resolveWithin(root, resolved, header.Linkname); err != nil {
+				return fmt.Errorf("symlink %s: %v", header.Name, err)
+			}
+			os.Remove(target)
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return fmt.Errorf("create symlink: %v", err)
+			}
 		default:
 			return fmt.Errorf("unsupported file type: %v", header.Typeflag)
 		}
@@ -419,6 +471,34 @@ func ExtractTarGz(tarGzPath, dest string) error {
 	return nil
 }
 
+// resolveWithin walks rel from base one component at a time, following any
+// symlinks already on disk the way the kernel would, and fails if the path
+// leaves root. Components that do not exist yet are taken as written.
+func resolveWithin(root, base, rel string) (string, error) {
+	current := base
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+		default:
+			current = filepath.Join(current, part)
+			if info, err := os.Lstat(current); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				real, err := filepath.EvalSymlinks(current)
+				if err != nil {
+					return "", fmt.Errorf("resolve %s: %v", current, err)
+				}
+				current = real
+			}
+		}
+		if current != root && !strings.HasPrefix(current, root+string(filepath.Separator)) {
+			return "", fmt.Errorf("%s points outside the archive", rel)
+		}
+	}
+	return current, nil
+}
+
 // ExtractTarGz extracts a tar.gz file to the specified destination
 func ExtractRoleTarGz(tarGzPath, dest string) error {
 	// Create a temporary directory
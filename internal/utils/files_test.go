@@ -475,6 +475,97 @@ func TestListFilenamesInTarGz(t *testing.T) {
 	}
 }
 
+func TestExtractTarGzSymlinks(t *testing.T) {
+	dir := func(name string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0755}
+	}
+	file := func(name string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len("pwned"))}
+	}
+	link := func(name, target string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: target}
+	}
+
+	tests := []struct {
+		name      string
+		headers   []*tar.Header
+		expectErr bool
+	}{
+		{
+			name:      "Direct Escape",
+			headers:   []*tar.Header{dir("a/"), link("a/x", "../../sibling"), file("a/x/pwned")},
+			expectErr: true,
+		},
+		{
+			name:      "Chained Escape",
+			headers:   []*tar.Header{dir("a/"), link("a/z", "../a"), link("a/x", "z/../../sibling"), file("a/x/pwned")},
+			expectErr: true,
+		},
+		{
+			name:      "Dot Dot File",
+			headers:   []*tar.Header{file("../sibling/pwned")},
+			expectErr: true,
+		},
+		{
+			name:      "Internal Link",
+			headers:   []*tar.Header{dir("a/"), dir("b/"), link("a/x", "../b"), file("a/x/pwned")},
+			expectErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			dest := filepath.Join(base, "dest")
+			sibling := filepath.Join(base, "sibling")
+			if err := os.MkdirAll(sibling, 0755); err != nil {
+				t.Fatal(err)
+			}
+			archive := filepath.Join(base, "test.tar.gz")
+			createTestTarGzHeaders(t, archive, tt.headers)
+
+			err := ExtractTarGz(archive, dest)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("ExtractTarGz() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if IsFile(filepath.Join(sibling, "pwned")) {
+				t.Errorf("ExtractTarGz() wrote outside %s", dest)
+			}
+			if !tt.expectErr && !IsFile(filepath.Join(dest, "b", "pwned")) {
+				t.Errorf("ExtractTarGz() did not write through the internal link")
+			}
+		})
+	}
+}
+
+// Helper function to create a tar.gz file from raw headers, regular files
+// get a fixed body matching their Size
+func createTestTarGzHeaders(t *testing.T, path string, headers []*tar.Header) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	gzWriter := gzip.NewWriter(file)
+	defer gzWriter.Close()
+
+	tarWriter := tar.NewWriter(gzWriter)
+	defer tarWriter.Close()
+
+	for _, header := range headers {
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tarWriter.Write([]byte("pwned")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
 // Helper function to create a tar.gz file
 func createTestTarGz(filepath string, files []struct {
 	Name string
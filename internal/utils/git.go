@@ -3,11 +3,17 @@ package utils
 import (
 	"fmt"
 	"os"
+	"sync"
 
 	"github.com/go-git/go-git/v5" // with go modules disabled
 	"github.com/go-git/go-git/v5/plumbing"
+	gitclient "github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/jctanner/lax/internal/httpclient"
 )
 
+var installGitTransport sync.Once
+
 // CloneRepo clones a Git repository and ensures it's fully cloned
 func CloneRepo(url, path string) error {
 	// Ensure the target directory exists
@@ -17,9 +23,15 @@ func CloneRepo(url, path string) error {
 	}
 
 	// Clone the repository
+	installGitTransport.Do(func() {
+		// clones go through the same proxy and tls settings as everything else
+		transport := githttp.NewClient(httpclient.Default().HTTPClient())
+		gitclient.InstallProtocol("https", transport)
+		gitclient.InstallProtocol("http", transport)
+	})
 	repo, err := git.PlainClone(path, false, &git.CloneOptions{
 		URL:      url,
-		Progress: os.Stdout,
+		Progress: os.Stderr,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to clone repository: %v", err)
@@ -3,9 +3,12 @@ package utils
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"os"
 	"runtime"
 	"strconv"
 
+	"github.com/jctanner/lax/internal/laxerrors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -33,3 +36,38 @@ func (hook *GoroutineIDHook) Fire(entry *logrus.Entry) error {
 	entry.Data["goroutine_id"] = GetGoroutineID()
 	return nil
 }
+
+// ConfigureLogging points the standard logrus logger at stderr (and
+// optionally a log file) with the requested level and format. Results
+// belong on stdout, so nothing here ever writes there.
+func ConfigureLogging(verbose bool, quiet bool, format string, logFile string) error {
+	switch {
+	case verbose:
+		logrus.SetLevel(logrus.DebugLevel)
+	case quiet:
+		logrus.SetLevel(logrus.WarnLevel)
+	default:
+		logrus.SetLevel(logrus.InfoLevel)
+	}
+
+	switch format {
+	case "", "text":
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return laxerrors.New(laxerrors.ErrUsage, "unknown log format %q, expected text or json", format)
+	}
+
+	var out io.Writer = os.Stderr
+	if logFile != "" {
+		f, err := os.OpenFile(ExpandUser(logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		out = io.MultiWriter(os.Stderr, f)
+	}
+	logrus.SetOutput(out)
+
+	return nil
+}
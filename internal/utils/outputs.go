@@ -1,6 +1,9 @@
 package utils
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 func PrettyPrint(v interface{}) (string, error) {
 	b, err := json.MarshalIndent(v, "", "  ")
@@ -9,3 +12,22 @@ func PrettyPrint(v interface{}) (string, error) {
 	}
 	return string(b), nil
 }
+
+// HumanSize formats a byte count using binary units (e.g. 1.5 KiB)
+func HumanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// PrintInfoField prints one aligned "key: value" line of info output
+func PrintInfoField(key string, value string) {
+	fmt.Printf("%-15s %s\n", key+":", value)
+}
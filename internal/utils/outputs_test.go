@@ -77,3 +77,25 @@ func TestPrettyPrint(t *testing.T) {
 		})
 	}
 }
+
+func TestHumanSize(t *testing.T) {
+	tests := []struct {
+		input    int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			result := HumanSize(tt.input)
+			if result != tt.expected {
+				t.Errorf("HumanSize(%d) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
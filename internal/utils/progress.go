@@ -0,0 +1,285 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Progress tracks the bytes and files of a batch of downloads. Only one
+batch is active at a time; the download helpers feed it through
+TrackDownload so callers only have to start and finish it.
+
+On a terminal it redraws a bar on stderr, otherwise (or when logging as
+json) it falls back to a log line every few seconds.
+*/
+type Progress struct {
+	mu        sync.Mutex
+	label     string
+	files     int
+	total     int64
+	filesDone int
+	done      int64
+	active    []*fileProgress
+	start     time.Time
+	now       func() time.Time
+	tty       bool
+	out       io.Writer
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+type fileProgress struct {
+	name string
+	size int64
+	done int64
+}
+
+const (
+	progressBarInterval = 200 * time.Millisecond
+	progressLogInterval = 5 * time.Second
+	progressBarWidth    = 30
+)
+
+var (
+	currentProgressMu sync.Mutex
+	currentProgress   *Progress
+)
+
+// StartProgress begins tracking a batch of downloads. files and total are
+// the expected number of files and bytes, either may be 0 when unknown.
+func StartProgress(label string, files int, total int64) *Progress {
+	p := newProgress(label, files, total)
+	p.tty = stderrIsTerminal()
+	p.out = os.Stderr
+	p.stop = make(chan struct{})
+	p.stopped = make(chan struct{})
+
+	currentProgressMu.Lock()
+	currentProgress = p
+	currentProgressMu.Unlock()
+
+	interval := progressLogInterval
+	if p.tty {
+		interval = progressBarInterval
+	}
+	go p.run(interval)
+	return p
+}
+
+func newProgress(label string, files int, total int64) *Progress {
+	p := &Progress{
+		label: label,
+		files: files,
+		total: total,
+		now:   time.Now,
+	}
+	p.start = p.now()
+	return p
+}
+
+// a bar only makes sense on an interactive stderr that isn't carrying json logs
+func stderrIsTerminal() bool {
+	if _, ok := logrus.StandardLogger().Formatter.(*logrus.JSONFormatter); ok {
+		return false
+	}
+	if !logrus.IsLevelEnabled(logrus.InfoLevel) {
+		return false
+	}
+	fi, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *Progress) run(interval time.Duration) {
+	defer close(p.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if p.tty {
+				fmt.Fprintf(p.out, "\r\033[K%s", p.Line())
+			} else {
+				logrus.Infof("%s", p.Line())
+			}
+		}
+	}
+}
+
+// Finish stops rendering and logs a summary of the batch
+func (p *Progress) Finish() {
+	currentProgressMu.Lock()
+	if currentProgress == p {
+		currentProgress = nil
+	}
+	currentProgressMu.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+		<-p.stopped
+		p.stop = nil
+	}
+	if p.tty {
+		fmt.Fprint(p.out, "\r\033[K")
+	}
+
+	// nothing came over the network, e.g. everything was already cached
+	p.mu.Lock()
+	idle := p.filesDone == 0 && p.done == 0
+	p.mu.Unlock()
+	if !idle {
+		logrus.Infof("%s", p.Summary())
+	}
+}
+
+// TrackDownload wraps a download body so its bytes are counted by the
+// active Progress, if there is one. size may be -1 when unknown.
+func TrackDownload(name string, size int64, r io.Reader) io.Reader {
+	currentProgressMu.Lock()
+	p := currentProgress
+	currentProgressMu.Unlock()
+	if p == nil {
+		return r
+	}
+	return p.track(name, size, r)
+}
+
+func (p *Progress) track(name string, size int64, r io.Reader) io.Reader {
+	f := &fileProgress{name: filepath.Base(name), size: size}
+	p.mu.Lock()
+	p.active = append(p.active, f)
+	p.mu.Unlock()
+	return &progressReader{p: p, f: f, r: r}
+}
+
+func (p *Progress) add(f *fileProgress, n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f.done += n
+	p.done += n
+}
+
+func (p *Progress) complete(f *fileProgress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, a := range p.active {
+		if a == f {
+			p.active = append(p.active[:i], p.active[i+1:]...)
+			p.filesDone++
+			return
+		}
+	}
+}
+
+// fail drops a download that broke off, its bytes are counted again if
+// it's retried
+func (p *Progress) fail(f *fileProgress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, a := range p.active {
+		if a == f {
+			p.active = append(p.active[:i], p.active[i+1:]...)
+			p.done -= f.done
+			f.done = 0
+			return
+		}
+	}
+}
+
+type progressReader struct {
+	p *Progress
+	f *fileProgress
+	r io.Reader
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	if n > 0 {
+		pr.p.add(pr.f, int64(n))
+	}
+	if err == io.EOF {
+		pr.p.complete(pr.f)
+	} else if err != nil {
+		pr.p.fail(pr.f)
+	}
+	return n, err
+}
+
+// rate is the throughput in bytes per second so far
+func (p *Progress) rate() float64 {
+	elapsed := p.now().Sub(p.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(p.done) / elapsed
+}
+
+// eta is the estimated time left, or -1 if it can't be estimated
+func (p *Progress) eta() time.Duration {
+	rate := p.rate()
+	if p.total <= 0 || rate <= 0 || p.done >= p.total {
+		return -1
+	}
+	return time.Duration(float64(p.total-p.done)/rate) * time.Second
+}
+
+// Line renders the current state on one line
+func (p *Progress) Line() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var sb strings.Builder
+	sb.WriteString(p.label)
+	sb.WriteString(" ")
+	if p.total > 0 {
+		pct := float64(p.done) / float64(p.total)
+		if pct > 1 {
+			pct = 1
+		}
+		if p.tty {
+			filled := int(pct * progressBarWidth)
+			sb.WriteString("[" + strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled) + "] ")
+		}
+		fmt.Fprintf(&sb, "%3.0f%% %s/%s", pct*100, HumanSize(p.done), HumanSize(p.total))
+	} else {
+		sb.WriteString(HumanSize(p.done))
+	}
+	if p.files > 0 {
+		fmt.Fprintf(&sb, " %d/%d files", p.filesDone, p.files)
+	} else {
+		fmt.Fprintf(&sb, " %d files", p.filesDone)
+	}
+	fmt.Fprintf(&sb, " %s/s", HumanSize(int64(p.rate())))
+	if eta := p.eta(); eta >= 0 {
+		fmt.Fprintf(&sb, " eta %s", eta)
+	}
+	if len(p.active) > 0 {
+		f := p.active[len(p.active)-1]
+		if f.size > 0 {
+			fmt.Fprintf(&sb, " %s %d%%", f.name, f.done*100/f.size)
+		} else {
+			fmt.Fprintf(&sb, " %s", f.name)
+		}
+	}
+	return sb.String()
+}
+
+// Summary describes the finished batch
+func (p *Progress) Summary() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	elapsed := p.now().Sub(p.start).Round(time.Millisecond)
+	return fmt.Sprintf("%s: %d files, %s in %s (%s/s)", p.label, p.filesDone, HumanSize(p.done), elapsed, HumanSize(int64(p.rate())))
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestProgressCounters(t *testing.T) {
+	p := newProgress("downloads", 2, 2048)
+	start := p.start
+	p.now = func() time.Time { return start.Add(2 * time.Second) }
+
+	r := p.track("/tmp/cache/ns-a-1.0.0.tar.gz", 1024, strings.NewReader(strings.Repeat("x", 1024)))
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	p.track("/tmp/cache/ns-b-1.0.0.tar.gz", 1024, strings.NewReader(""))
+
+	tests := []struct {
+		name     string
+		result   string
+		expected string
+	}{
+		{"line", p.Line(), "downloads  50% 1.0 KiB/2.0 KiB 1/2 files 512 B/s eta 2s ns-b-1.0.0.tar.gz 0%"},
+		{"summary", p.Summary(), "downloads: 1 files, 1.0 KiB in 2s (512 B/s)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.result != tt.expected {
+				t.Errorf("got %q, want %q", tt.result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProgressFailedDownload(t *testing.T) {
+	p := newProgress("downloads", 1, 1024)
+	start := p.start
+	p.now = func() time.Time { return start.Add(2 * time.Second) }
+
+	reset := errors.New("connection reset")
+	body := io.MultiReader(strings.NewReader(strings.Repeat("x", 512)), iotest.ErrReader(reset))
+	r := p.track("/tmp/cache/ns-a-1.0.0.tar.gz", 1024, body)
+	if _, err := io.Copy(io.Discard, r); !errors.Is(err, reset) {
+		t.Fatalf("expected the read error, got %v", err)
+	}
+	if got, want := p.Line(), "downloads   0% 0 B/1.0 KiB 0/1 files 0 B/s"; got != want {
+		t.Errorf("after the failure got %q, want %q", got, want)
+	}
+
+	// the retry is counted from scratch
+	r = p.track("/tmp/cache/ns-a-1.0.0.tar.gz", 1024, strings.NewReader(strings.Repeat("x", 1024)))
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.Line(), "downloads 100% 1.0 KiB/1.0 KiB 1/1 files 512 B/s"; got != want {
+		t.Errorf("after the retry got %q, want %q", got, want)
+	}
+}
+
+func TestTrackDownloadWithoutProgress(t *testing.T) {
+	r := strings.NewReader("data")
+	if TrackDownload("file", 4, r) != io.Reader(r) {
+		t.Errorf("expected the reader to be returned unchanged when nothing is tracking")
+	}
+}
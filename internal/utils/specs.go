@@ -2,6 +2,8 @@ package utils
 
 import (
 	"strings"
+
+	"github.com/jctanner/lax/internal/laxerrors"
 )
 
 type InstallSpec struct {
@@ -67,3 +69,53 @@ func SplitSpec(input string) []string {
 
 	return result
 }
+
+/*
+Split an optional ":<version>" suffix off of a namespace.name spec.
+
+	geerlingguy.mac:4.0.1 -> geerlingguy.mac, 4.0.1
+	geerlingguy.mac -> geerlingguy.mac, ""
+	github.com:geerlingguy.mac -> github.com:geerlingguy.mac, ""
+*/
+func SplitSpecVersion(input string) (string, string) {
+	colonIndex := strings.LastIndex(input, ":")
+	if colonIndex == -1 || colonIndex == len(input)-1 {
+		return input, ""
+	}
+
+	// only treat the suffix as a version if it looks like one
+	version := input[colonIndex+1:]
+	if !strings.ContainsAny(version[:1], "0123456789<>=!*") {
+		return input, ""
+	}
+
+	return input[:colonIndex], version
+}
+
+/*
+SpecFromArgs builds a spec from an optional ns.name[:version] argument,
+falling back to the --namespace, --name and --version values for
+whatever the argument doesn't set.
+*/
+func SpecFromArgs(namespace, name, version string, args []string) (InstallSpec, error) {
+	if len(args) > 0 {
+		fqn, fqnVersion := SplitSpecVersion(args[0])
+		if fqnVersion != "" {
+			version = fqnVersion
+		}
+		spec := SplitSpec(fqn)
+		if len(spec) == 3 {
+			namespace = spec[1]
+			name = spec[2]
+		} else if len(spec) == 2 {
+			namespace = spec[0]
+			name = spec[1]
+		}
+	}
+
+	if namespace == "" || name == "" {
+		return InstallSpec{}, laxerrors.New(laxerrors.ErrUsage, "a namespace.name is required")
+	}
+
+	return InstallSpec{Namespace: namespace, Name: name, Version: version}, nil
+}
@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"errors"
 	"reflect"
 	"testing"
+
+	"github.com/jctanner/lax/internal/laxerrors"
 )
 
 func TestInstallSpecEquals(t *testing.T) {
@@ -134,3 +137,116 @@ func TestSplitSpec(t *testing.T) {
 		})
 	}
 }
+
+func TestSplitSpecVersion(t *testing.T) {
+	tests := []struct {
+		name            string
+		input           string
+		expectedFQN     string
+		expectedVersion string
+	}{
+		{
+			name:            "No version",
+			input:           "geerlingguy.mac",
+			expectedFQN:     "geerlingguy.mac",
+			expectedVersion: "",
+		},
+		{
+			name:            "Exact version",
+			input:           "geerlingguy.mac:4.0.1",
+			expectedFQN:     "geerlingguy.mac",
+			expectedVersion: "4.0.1",
+		},
+		{
+			name:            "Version with operator",
+			input:           "geerlingguy.mac:>=4.0.0",
+			expectedFQN:     "geerlingguy.mac",
+			expectedVersion: ">=4.0.0",
+		},
+		{
+			name:            "Server prefix",
+			input:           "github.com:geerlingguy.mac",
+			expectedFQN:     "github.com:geerlingguy.mac",
+			expectedVersion: "",
+		},
+		{
+			name:            "Trailing colon",
+			input:           "geerlingguy.mac:",
+			expectedFQN:     "geerlingguy.mac:",
+			expectedVersion: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fqn, version := SplitSpecVersion(tt.input)
+			if fqn != tt.expectedFQN || version != tt.expectedVersion {
+				t.Errorf("SplitSpecVersion(%q) = %q, %q, want %q, %q", tt.input, fqn, version, tt.expectedFQN, tt.expectedVersion)
+			}
+		})
+	}
+}
+
+func TestSpecFromArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		flags     InstallSpec
+		args      []string
+		expected  InstallSpec
+		wantUsage bool
+	}{
+		{
+			name:     "Argument",
+			args:     []string{"geerlingguy.mac"},
+			expected: InstallSpec{Namespace: "geerlingguy", Name: "mac"},
+		},
+		{
+			name:     "Argument with version",
+			args:     []string{"geerlingguy.mac:4.0.1"},
+			expected: InstallSpec{Namespace: "geerlingguy", Name: "mac", Version: "4.0.1"},
+		},
+		{
+			name:     "Server prefix",
+			args:     []string{"github.com:geerlingguy.mac"},
+			expected: InstallSpec{Namespace: "geerlingguy", Name: "mac"},
+		},
+		{
+			name:     "Flags",
+			flags:    InstallSpec{Namespace: "geerlingguy", Name: "mac", Version: "4.0.1"},
+			expected: InstallSpec{Namespace: "geerlingguy", Name: "mac", Version: "4.0.1"},
+		},
+		{
+			name:     "Version flag with argument",
+			flags:    InstallSpec{Version: "4.0.1"},
+			args:     []string{"geerlingguy.mac"},
+			expected: InstallSpec{Namespace: "geerlingguy", Name: "mac", Version: "4.0.1"},
+		},
+		{
+			name:      "Missing name",
+			args:      []string{"geerlingguy"},
+			wantUsage: true,
+		},
+		{
+			name:      "Nothing given",
+			wantUsage: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := SpecFromArgs(tt.flags.Namespace, tt.flags.Name, tt.flags.Version, tt.args)
+			if tt.wantUsage {
+				if !errors.Is(err, laxerrors.ErrUsage) {
+					t.Errorf("expected a usage error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(spec, tt.expected) {
+				t.Errorf("got %+v, want %+v", spec, tt.expected)
+			}
+		})
+	}
+}
@@ -3,15 +3,14 @@ package utils
 import (
 	"fmt"
 	"strings"
-	"time"
 
-	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 
+	"github.com/jctanner/lax/internal/httpclient"
 	"github.com/sirupsen/logrus"
 )
 
@@ -45,106 +44,24 @@ func DownloadFile(urlStr string) (string, error) {
 	// Create the full file path
 	filePath := filepath.Join(tmpDir, filename)
 
-	// Make the HTTP GET request
-	resp, err := http.Get(urlStr)
-	if err != nil {
-		return "", fmt.Errorf("failed to download file: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// Check if the request was successful
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to download file: status code %d", resp.StatusCode)
-	}
-
-	// Create the file in the temporary directory
-	outFile, err := os.Create(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to create file: %v", err)
-	}
-	defer outFile.Close()
-
-	// Copy the content from the response to the file
-	_, err = io.Copy(outFile, resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to save file: %v", err)
+	if err := DownloadToPath(urlStr, filePath, DownloadOptions{}); err != nil {
+		return "", err
 	}
 
-	fmt.Printf("File downloaded successfully: %s\n", filePath)
+	logrus.Debugf("File downloaded successfully: %s\n", filePath)
 	return filePath, nil
 }
 
 func DownloadBinaryFileToPathWithBearerToken(urlStr string, token string, filePath string) (string, error) {
 	logrus.Debugf("Downloading w/ token %s -> %s\n", urlStr, filePath)
 
-	/*
-		// Create a new HTTP request
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			logrus.Errorf("Error creating HTTP request: %v", err)
-			return nil, err
-		}
-
-		// Add Authorization header if accessToken is non-empty
-		if c.accessToken != "" {
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.accessToken))
-			logrus.Infof("Added Authorization header with token")
-		}
-
-		// Use the default HTTP client to send the request
-		resp, err = http.DefaultClient.Do(req)
-		if err != nil {
-			logrus.Errorf("Error making HTTP request: %v", err)
-			return nil, err
-		}
-
-		return resp, nil
-	*/
-
-	// Make the HTTP GET request
-	/*
-		resp, err := http.Get(urlStr)
-		if err != nil {
-			return "", fmt.Errorf("failed to download file: %v", err)
-		}
-		defer resp.Body.Close()
-	*/
-
-	req, err := http.NewRequest("GET", urlStr, nil)
-	if err != nil {
-		logrus.Errorf("Error creating HTTP request: %v", err)
-		return "", err
-	}
-
 	// add the token
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-
-	/*
-		// Check if the request was successful
-		if resp.StatusCode != http.StatusOK {
-			return "", fmt.Errorf("failed to download file: status code %d", resp.StatusCode)
-		}
-	*/
+	header := http.Header{}
+	header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		logrus.Errorf("Error making HTTP request: %v", err)
+	if err := DownloadToPath(urlStr, filePath, DownloadOptions{Header: header}); err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-
-	// Create the file
-	outFile, err := os.Create(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to create file: %v", err)
-	}
-	defer outFile.Close()
-
-	// Copy the content from the response to the file
-	_, err = io.Copy(outFile, resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to save file: %v", err)
-	}
 
 	logrus.Debugf("File downloaded successfully: %s\n", filePath)
 	return filePath, nil
@@ -155,29 +72,8 @@ func DownloadBinaryFileToPath(urlStr string, filePath string) (string, error) {
 
 	logrus.Debugf("Downloading %s -> %s\n", urlStr, filePath)
 
-	// Make the HTTP GET request
-	resp, err := http.Get(urlStr)
-	if err != nil {
-		return "", fmt.Errorf("failed to download file: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// Check if the request was successful
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to download file: status code %d", resp.StatusCode)
-	}
-
-	// Create the file
-	outFile, err := os.Create(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to create file: %v", err)
-	}
-	defer outFile.Close()
-
-	// Copy the content from the response to the file
-	_, err = io.Copy(outFile, resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to save file: %v", err)
+	if err := DownloadToPath(urlStr, filePath, DownloadOptions{}); err != nil {
+		return "", err
 	}
 
 	logrus.Debugf("File downloaded successfully: %s\n", filePath)
@@ -185,11 +81,7 @@ func DownloadBinaryFileToPath(urlStr string, filePath string) (string, error) {
 }
 
 func IsURLGood(url string) bool {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	resp, err := client.Head(url)
+	resp, err := httpclient.Default().Head(url)
 	if err != nil {
 		return false
 	}
@@ -8,6 +8,9 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+
+	"github.com/jctanner/lax/internal/httpclient"
+	"github.com/sirupsen/logrus"
 )
 
 func FileExists(filePath string) bool {
@@ -28,9 +31,9 @@ func downloadJSONFile(item DownloadItem, wg *sync.WaitGroup, results chan<- erro
 	defer func() { <-sem }()
 
 	// Download the data
-	fmt.Printf("fetching %s to %s\n", item.URL, item.FilePath)
+	logrus.Debugf("fetching %s to %s", item.URL, item.FilePath)
 
-	resp, err := http.Get(item.URL)
+	resp, err := httpclient.Get(item.URL)
 	if err != nil {
 		results <- fmt.Errorf("error downloading %s: %v", item.URL, err)
 		return
@@ -79,9 +82,9 @@ func downloadBinaryFile(item DownloadItem, wg *sync.WaitGroup, results chan<- er
 	defer func() { <-sem }()
 
 	// Download the data
-	fmt.Printf("fetching %s to %s\n", item.URL, item.FilePath)
+	logrus.Debugf("fetching %s to %s", item.URL, item.FilePath)
 
-	resp, err := http.Get(item.URL)
+	resp, err := httpclient.Get(item.URL)
 	if err != nil {
 		results <- fmt.Errorf("error downloading %s: %v", item.URL, err)
 		return
@@ -134,7 +137,7 @@ func DownloadJSONFilesConcurrently(items []DownloadItem, maxWorkers int) {
 
 	for err := range results {
 		if err != nil {
-			fmt.Println(err)
+			logrus.Errorf("%v", err)
 		}
 	}
 
@@ -157,7 +160,7 @@ func DownloadBinaryFilesConcurrently(items []DownloadItem, maxWorkers int) {
 
 	for err := range results {
 		if err != nil {
-			fmt.Println(err)
+			logrus.Errorf("%v", err)
 		}
 	}
 
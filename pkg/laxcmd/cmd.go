@@ -1,11 +1,15 @@
 package laxcmd
 
 import (
-	"fmt"
+	"errors"
 	"os"
+	"time"
 
 	"github.com/jctanner/lax/internal/galaxy_sync"
+	"github.com/jctanner/lax/internal/httpclient"
+	"github.com/jctanner/lax/internal/laxerrors"
 	"github.com/jctanner/lax/internal/repository"
+	"github.com/jctanner/lax/internal/serve"
 	"github.com/jctanner/lax/internal/types"
 	"github.com/jctanner/lax/internal/utils"
 	"github.com/sirupsen/logrus"
@@ -16,15 +20,49 @@ import (
 	"github.com/jctanner/lax/internal/roles"
 )
 
-func SetLogLevel(kwargs *types.CmdKwargs) {
-	if kwargs.Verbose {
-		logrus.SetLevel(logrus.DebugLevel)
-	} else {
-		logrus.SetLevel(logrus.InfoLevel)
+func SetLogLevel(kwargs *types.CmdKwargs) error {
+	return utils.ConfigureLogging(kwargs.Verbose, kwargs.Quiet, kwargs.LogFormat, kwargs.LogFile)
+}
+
+func ConfigureHttpClient(kwargs *types.CmdKwargs) error {
+	config := httpclient.DefaultConfig()
+	config.ConnectTimeout = kwargs.ConnectTimeout
+	config.ResponseTimeout = kwargs.ResponseTimeout
+	config.Retries = kwargs.Retries
+	config.Proxy = kwargs.Proxy
+	config.NoProxy = kwargs.NoProxy
+	config.CACert = utils.ExpandUser(kwargs.CACert)
+	config.ClientCert = utils.ExpandUser(kwargs.ClientCert)
+	config.ClientKey = utils.ExpandUser(kwargs.ClientKey)
+	config.Insecure = kwargs.Insecure
+
+	// the default credentials file is optional, one named on the command line is not
+	credentialsFile := utils.ExpandUser(kwargs.CredentialsFile)
+	if kwargs.CredentialsFile != "" && (kwargs.CredentialsFile != DefaultCredentialsFile || utils.FileExists(credentialsFile)) {
+		creds, err := httpclient.LoadCredentials(credentialsFile)
+		if err != nil {
+			return laxerrors.Wrap(laxerrors.ErrUsage, err, "--credentials-file")
+		}
+		config.Credentials = creds
 	}
+
+	config.Netrc = utils.ExpandUser(kwargs.NetrcFile)
+	if config.Netrc == "" {
+		config.Netrc = os.Getenv("NETRC")
+	}
+	if config.Netrc == "" && utils.FileExists(utils.ExpandUser("~/.netrc")) {
+		config.Netrc = utils.ExpandUser("~/.netrc")
+	}
+	config.NetrcDefault = kwargs.NetrcDefault
+
+	return httpclient.Configure(config)
 }
 
-func Execute() {
+// DefaultCredentialsFile is read when it exists and --credentials-file isn't given
+const DefaultCredentialsFile = "~/.config/lax/credentials.yml"
+
+// NewRootCmd builds the lax command tree, sharing one set of kwargs between every command
+func NewRootCmd() *cobra.Command {
 
 	kwargs := types.CmdKwargs{}
 
@@ -37,7 +75,41 @@ func Execute() {
 	kwargs.DestDir = defaultDestDir
 	kwargs.CacheDir = defaultCacheDir
 
-	var rootCmd = &cobra.Command{Use: "cli"}
+	var rootCmd = &cobra.Command{
+		Use: "cli",
+		// errors are logged and mapped to an exit code by Execute
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := SetLogLevel(&kwargs); err != nil {
+				return err
+			}
+			if kwargs.Insecure {
+				logrus.Warnf("--insecure is set, server certificates will not be verified")
+			}
+			return ConfigureHttpClient(&kwargs)
+		},
+	}
+	rootCmd.PersistentFlags().BoolVar(&kwargs.Verbose, "verbose", false, "use debug output")
+	rootCmd.PersistentFlags().BoolVarP(&kwargs.Quiet, "quiet", "q", false, "only log warnings and errors")
+	rootCmd.PersistentFlags().StringVar(&kwargs.LogFormat, "log-format", "text", "log format (text or json)")
+	rootCmd.PersistentFlags().StringVar(&kwargs.LogFile, "log-file", "", "also append log messages to this file")
+	httpDefaults := httpclient.DefaultConfig()
+	rootCmd.PersistentFlags().DurationVar(&kwargs.ConnectTimeout, "connect-timeout", httpDefaults.ConnectTimeout, "how long to wait for a connection to a server")
+	rootCmd.PersistentFlags().DurationVar(&kwargs.ResponseTimeout, "timeout", httpDefaults.ResponseTimeout, "how long to wait for a server to respond")
+	rootCmd.PersistentFlags().IntVar(&kwargs.Retries, "retries", httpDefaults.Retries, "how many times to retry failed http requests")
+	rootCmd.PersistentFlags().StringVar(&kwargs.Proxy, "proxy", "", "proxy url for all requests, overrides HTTPS_PROXY and HTTP_PROXY")
+	rootCmd.PersistentFlags().StringVar(&kwargs.NoProxy, "no-proxy", "", "hosts that bypass the proxy, overrides NO_PROXY")
+	rootCmd.PersistentFlags().StringVar(&kwargs.CACert, "ca-cert", "", "PEM bundle of extra certificate authorities to trust")
+	rootCmd.PersistentFlags().StringVar(&kwargs.ClientCert, "client-cert", "", "PEM client certificate for servers that require one")
+	rootCmd.PersistentFlags().StringVar(&kwargs.ClientKey, "client-key", "", "PEM key for --client-cert")
+	rootCmd.PersistentFlags().BoolVar(&kwargs.Insecure, "insecure", false, "do not verify server certificates")
+	rootCmd.PersistentFlags().StringVar(&kwargs.CredentialsFile, "credentials-file", DefaultCredentialsFile, "yaml file of per repo url credentials")
+	rootCmd.PersistentFlags().StringVar(&kwargs.NetrcFile, "netrc-file", "", "netrc file for hosts without credentials (default $NETRC or ~/.netrc)")
+	rootCmd.PersistentFlags().BoolVar(&kwargs.NetrcDefault, "netrc-default", false, "send the netrc default entry to hosts no machine entry matches")
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return laxerrors.Wrap(laxerrors.ErrUsage, err, "%s", cmd.CommandPath())
+	})
 
 	var roleCmd = &cobra.Command{
 		Use:   "role",
@@ -49,89 +121,219 @@ func Execute() {
 		Short: "Manage collections",
 	}
 
-	/*
-	   var repoCmd = &cobra.Command{
-	       Use:   "repo",
-	       Short: "Manage repositories",
-	   }
-	*/
+	var repoCmd = &cobra.Command{
+		Use:   "repo",
+		Short: "Manage repositories",
+	}
+
+	var repoLintCmd = &cobra.Command{
+		Use:   "lint [repo dir]",
+		Short: "Check every artifact in a repository for problems that would break installs",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return repository.Lint(&kwargs, args)
+		},
+	}
+
+	var repoClosureCmd = &cobra.Command{
+		Use:   "closure [repo dir or url]",
+		Short: "Find collection and role versions that can't be installed from a repository alone",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if kwargs.CacheDir == "" {
+				kwargs.CacheDir = defaultCacheDir
+			}
+			return repository.Closure(&kwargs, args)
+		},
+	}
+
+	var repoDiffCmd = &cobra.Command{
+		Use:   "diff <old> <new>",
+		Short: "Show the collection and role versions added, removed or changed between two repositories",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if kwargs.CacheDir == "" {
+				kwargs.CacheDir = defaultCacheDir
+			}
+			return repository.Diff(&kwargs, args)
+		},
+	}
+
+	var repoSnapshotCmd = &cobra.Command{
+		Use:   "snapshot <repo dir>",
+		Short: "Freeze a repository's index under snapshots/<name>",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return repository.Snapshot(&kwargs, args)
+		},
+	}
+
+	var repoPruneCmd = &cobra.Command{
+		Use:   "prune <repo dir>",
+		Short: "Remove the collection and role versions no retention policy keeps",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return repository.Prune(&kwargs, args)
+		},
+	}
+
+	var repoPromoteCmd = &cobra.Command{
+		Use:   "promote",
+		Short: "Publish a snapshot to a repository",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// other commands share this field and register a default, promote must never guess
+			kwargs.DestDir, _ = cmd.Flags().GetString("to")
+			return repository.Promote(&kwargs, args)
+		},
+	}
 
 	var createRepoCmd = &cobra.Command{
 		Use:   "createrepo",
 		Short: "Create repository metadata from a directory of artifacts",
-		Run: func(cmd *cobra.Command, args []string) {
-			SetLogLevel(&kwargs)
-			repository.CreateRepo(&kwargs)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return repository.CreateRepo(&kwargs)
+		},
+	}
+
+	var serveCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a repo directory over http",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serve.Serve(&kwargs)
+		},
+	}
+
+	var publishCmd = &cobra.Command{
+		Use:   "publish <artifact.tar.gz>",
+		Short: "Add a collection or role artifact to a repo",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return repository.Publish(&kwargs, args)
+		},
+	}
+
+	var roleInitCmd = &cobra.Command{
+		Use:   "init namespace.name",
+		Short: "Create a new role from a skeleton",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return roles.Init(&kwargs, args)
 		},
 	}
 
-	var initCmd = &cobra.Command{
-		Use:   "init",
-		Short: "Create a new role or collection",
-		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Println("Initialized")
+	var collectionInitCmd = &cobra.Command{
+		Use:   "init namespace.name",
+		Short: "Create a new collection from a skeleton",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return collections.Init(&kwargs, args)
 		},
 	}
 
 	var collectionInstallCmd = &cobra.Command{
 		Use:   "install",
 		Short: "Install",
-		Run: func(cmd *cobra.Command, args []string) {
-			SetLogLevel(&kwargs)
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if kwargs.DestDir == "" {
 				kwargs.DestDir = defaultDestDir
 			}
 			if kwargs.CacheDir == "" {
 				kwargs.CacheDir = defaultCacheDir
 			}
+			// galaxy-sync shares this field and registers a different default
+			kwargs.DownloadConcurrency, _ = cmd.Flags().GetInt("concurrency")
 			logrus.Debugf("INSTALL1: cachedir:%s dest:%s\n", kwargs.CacheDir, kwargs.DestDir)
-			collections.Install(&kwargs, args)
+			return collections.Install(&kwargs, args)
 		},
 	}
 
 	var roleInstallCmd = &cobra.Command{
 		Use:   "install",
 		Short: "Install",
-		Run: func(cmd *cobra.Command, args []string) {
-			SetLogLevel(&kwargs)
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if kwargs.DestDir == "" {
 				kwargs.DestDir = defaultDestDir
 			}
 			if kwargs.CacheDir == "" {
 				kwargs.CacheDir = defaultCacheDir
 			}
+			// galaxy-sync shares this field and registers a different default
+			kwargs.DownloadConcurrency, _ = cmd.Flags().GetInt("concurrency")
 			logrus.Debugf("INSTALL1: cachedir:%s dest:%s\n", kwargs.CacheDir, kwargs.DestDir)
-			roles.Install(&kwargs, args)
+			return roles.Install(&kwargs, args)
+		},
+	}
+
+	var roleBuildCmd = &cobra.Command{
+		Use:   "build [role dir]",
+		Short: "Build a versioned role artifact from a role directory",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return roles.Build(&kwargs, args)
+		},
+	}
+
+	var collectionBuildCmd = &cobra.Command{
+		Use:   "build [collection dir]",
+		Short: "Build a collection artifact from a source tree with a galaxy.yml",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return collections.Build(&kwargs, args)
+		},
+	}
+
+	var collectionInfoCmd = &cobra.Command{
+		Use:   "info [namespace.name[:version]]",
+		Short: "Show details about a collection in the repository",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if kwargs.DestDir == "" {
+				kwargs.DestDir = defaultDestDir
+			}
+			if kwargs.CacheDir == "" {
+				kwargs.CacheDir = defaultCacheDir
+			}
+			return collections.Info(&kwargs, args)
+		},
+	}
+
+	var roleInfoCmd = &cobra.Command{
+		Use:   "info [namespace.name[:version]]",
+		Short: "Show details about a role in the repository",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if kwargs.DestDir == "" {
+				kwargs.DestDir = defaultDestDir
+			}
+			if kwargs.CacheDir == "" {
+				kwargs.CacheDir = defaultCacheDir
+			}
+			return roles.Info(&kwargs, args)
+		},
+	}
+
+	var collectionDepsCmd = &cobra.Command{
+		Use:   "deps [namespace.name[:version]]",
+		Short: "Show the resolved dependencies of a collection",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return collections.Deps(&kwargs, args)
+		},
+	}
+
+	var collectionRDepsCmd = &cobra.Command{
+		Use:   "rdeps [namespace.name[:version]]",
+		Short: "Show which collections in the repository depend on a collection",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return collections.RDeps(&kwargs, args)
 		},
 	}
 
 	var syncCmd = &cobra.Command{
 		Use:   "galaxy-sync",
 		Short: "Sync content from galaxy into a lax repo directory",
-		Run: func(cmd *cobra.Command, args []string) {
-			SetLogLevel(&kwargs)
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if kwargs.Server == "" || kwargs.Server == "https://console.redhat.com" {
 				kwargs.Server = "https://galaxy.ansible.com"
 			}
 			kwargs.ApiPrefix = "/api"
 			kwargs.AuthUrl = ""
 
-			fmt.Println("###########################################")
-			fmt.Printf("%v\n", kwargs)
-			fmt.Println("###########################################")
-
-			err := galaxy_sync.GalaxySync(&kwargs)
-			if err != nil {
-				logrus.Errorf("ERROR: %s\n", err)
-			}
+			return galaxy_sync.GalaxySync(&kwargs)
 		},
 	}
 
 	var crcSyncCmd = &cobra.Command{
 		Use:   "crc-sync",
 		Short: "Sync content from console.redhat.com into a lax repo directory",
-		Run: func(cmd *cobra.Command, args []string) {
-			SetLogLevel(&kwargs)
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if kwargs.Server == "" || kwargs.Server == "https://galaxy.ansible.com" {
 				kwargs.Server = "https://console.redhat.com"
 			}
@@ -139,17 +341,29 @@ func Execute() {
 			if kwargs.AuthUrl == "" {
 				kwargs.AuthUrl = "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token"
 			}
-			err := galaxy_sync.GalaxySync(&kwargs)
-			if err != nil {
-				logrus.Errorf("ERROR: %s\n", err)
-			}
+			return galaxy_sync.GalaxySync(&kwargs)
 		},
 	}
 
 	createRepoCmd.Flags().StringVar(&kwargs.DestDir, "dest", "", "where the files are")
 	createRepoCmd.Flags().BoolVar(&kwargs.CollectionsOnly, "collections", false, "just process collections")
 	createRepoCmd.Flags().BoolVar(&kwargs.RolesOnly, "roles", false, "just process roles")
-	createRepoCmd.Flags().BoolVar(&kwargs.Verbose, "verbose", false, "use debug output")
+	createRepoCmd.Flags().StringVar(&kwargs.Layout, "layout", "", "move the artifacts to the flat or sharded layout (default: keep the repo's layout, flat for a new repo)")
+
+	serveCmd.Flags().StringVar(&kwargs.DestDir, "dir", ".", "the repo directory to serve")
+	serveCmd.Flags().StringVar(&kwargs.Listen, "listen", ":8080", "address to listen on")
+	serveCmd.Flags().BoolVar(&kwargs.Watch, "watch", false, "rerun createrepo when artifacts are added or removed")
+	serveCmd.Flags().BoolVar(&kwargs.GalaxyAPI, "galaxy-api", false, "also serve collections with a read-only galaxy v3 api")
+	serveCmd.Flags().DurationVar(&kwargs.WatchInterval, "watch-interval", 5*time.Second, "how often --watch looks for changes")
+	serveCmd.Flags().StringVar(&kwargs.UploadTokenFile, "upload-token-file", "", "accept lax publish uploads that present the token in this file (default $LAX_UPLOAD_TOKEN)")
+
+	publishCmd.Flags().StringVar(&kwargs.Server, "repo", "", "the repo directory, or the url of a lax serve instance")
+	publishCmd.Flags().BoolVarP(&kwargs.Force, "force", "f", false, "replace the version if it is already in the repo")
+	publishCmd.Flags().StringVar(&kwargs.Namespace, "namespace", "", "role namespace, if meta/main.yml doesn't say")
+	publishCmd.Flags().StringVar(&kwargs.Name, "name", "", "role name, if meta/main.yml doesn't say")
+	publishCmd.Flags().StringVar(&kwargs.Version, "version", "", "role version, if meta/main.yml doesn't say")
+	publishCmd.Flags().StringVar(&kwargs.OutputFormat, "output", "text", "output format (text or json)")
+	publishCmd.MarkFlagRequired("repo")
 
 	collectionInstallCmd.Flags().StringVar(&kwargs.Server, "server", "https://github.com", "server")
 	collectionInstallCmd.Flags().StringVar(&kwargs.Namespace, "namespace", "", "namespace")
@@ -158,7 +372,11 @@ func Execute() {
 	collectionInstallCmd.Flags().StringVar(&kwargs.DestDir, "dest", defaultDestDir, "where to install")
 	collectionInstallCmd.Flags().StringVar(&kwargs.CacheDir, "cachedir", defaultCacheDir, "where to store intermediate files")
 	collectionInstallCmd.Flags().StringVarP(&kwargs.RequirementsFile, "requirements-file", "r", "", "requirements file")
-	collectionInstallCmd.Flags().BoolVar(&kwargs.Verbose, "verbose", false, "use debug output")
+	collectionInstallCmd.Flags().BoolVarP(&kwargs.Force, "force", "f", false, "reinstall the named package even if it is already installed")
+	collectionInstallCmd.Flags().BoolVar(&kwargs.ForceWithDeps, "force-with-deps", false, "reinstall the named package and all of its dependencies")
+	collectionInstallCmd.Flags().IntVar(&kwargs.DownloadConcurrency, "concurrency", 4, "how many artifacts to download at once")
+	collectionInstallCmd.Flags().BoolVar(&kwargs.DryRun, "dry-run", false, "show what would be installed without changing anything")
+	collectionInstallCmd.Flags().StringVar(&kwargs.OutputFormat, "output", "text", "transaction output format (text or json)")
 
 	roleInstallCmd.Flags().StringVar(&kwargs.Server, "server", "https://github.com", "server")
 	roleInstallCmd.Flags().StringVar(&kwargs.Namespace, "namespace", "", "namespace")
@@ -166,7 +384,79 @@ func Execute() {
 	roleInstallCmd.Flags().StringVar(&kwargs.Version, "version", "", "version")
 	roleInstallCmd.Flags().StringVar(&kwargs.CacheDir, "cachedir", defaultCacheDir, "where to store intermediate files")
 	roleInstallCmd.Flags().StringVar(&kwargs.DestDir, "dest", defaultDestDir, "where to install")
-	roleInstallCmd.Flags().BoolVar(&kwargs.Verbose, "verbose", false, "use debug output")
+	roleInstallCmd.Flags().BoolVarP(&kwargs.Force, "force", "f", false, "reinstall the named package even if it is already installed")
+	roleInstallCmd.Flags().BoolVar(&kwargs.ForceWithDeps, "force-with-deps", false, "reinstall the named package and all of its dependencies")
+	roleInstallCmd.Flags().IntVar(&kwargs.DownloadConcurrency, "concurrency", 4, "how many artifacts to download at once")
+	roleInstallCmd.Flags().BoolVar(&kwargs.DryRun, "dry-run", false, "show what would be installed without changing anything")
+	roleInstallCmd.Flags().StringVar(&kwargs.OutputFormat, "output", "text", "transaction output format (text or json)")
+
+	repoLintCmd.Flags().StringVar(&kwargs.OutputFormat, "output", "text", "output format (text or json)")
+
+	repoClosureCmd.Flags().StringVar(&kwargs.CacheDir, "cachedir", defaultCacheDir, "where to store intermediate files")
+	repoClosureCmd.Flags().BoolVar(&kwargs.CollectionsOnly, "collections", false, "just check collections")
+	repoClosureCmd.Flags().BoolVar(&kwargs.RolesOnly, "roles", false, "just check roles")
+	repoClosureCmd.Flags().StringVar(&kwargs.OutputFormat, "output", "text", "output format (text or json)")
+
+	repoDiffCmd.Flags().StringVar(&kwargs.CacheDir, "cachedir", defaultCacheDir, "where to store intermediate files")
+	repoDiffCmd.Flags().BoolVar(&kwargs.CollectionsOnly, "collections", false, "just compare collections")
+	repoDiffCmd.Flags().BoolVar(&kwargs.RolesOnly, "roles", false, "just compare roles")
+	repoDiffCmd.Flags().StringVar(&kwargs.OutputFormat, "output", "text", "output format (text or json)")
+
+	repoSnapshotCmd.Flags().StringVar(&kwargs.Name, "name", "", "the snapshot's name, e.g. a date or a release")
+	repoSnapshotCmd.Flags().StringVar(&kwargs.OutputFormat, "output", "text", "output format (text or json)")
+
+	repoPruneCmd.Flags().IntVar(&kwargs.KeepLatest, "keep-latest", 0, "keep the latest N versions of every collection and role")
+	repoPruneCmd.Flags().StringVar(&kwargs.KeepSince, "keep-since", "", "keep versions synced or published after this date (2024-05-01)")
+	repoPruneCmd.Flags().StringArrayVar(&kwargs.KeepRequirements, "keep-requirements", nil, "keep what this requirements file or lockfile installs, can be repeated")
+	repoPruneCmd.Flags().BoolVar(&kwargs.DryRun, "dry-run", false, "show what would be removed without removing anything")
+	repoPruneCmd.Flags().StringVar(&kwargs.OutputFormat, "output", "text", "output format (text or json)")
+
+	repoPromoteCmd.Flags().StringVar(&kwargs.From, "from", "", "the snapshot directory to publish")
+	repoPromoteCmd.Flags().StringVar(&kwargs.DestDir, "to", "", "the repo directory to publish it to")
+	repoPromoteCmd.Flags().BoolVarP(&kwargs.Force, "force", "f", false, "replace artifacts the repo has with different content")
+	repoPromoteCmd.Flags().StringVar(&kwargs.OutputFormat, "output", "text", "output format (text or json)")
+
+	roleInitCmd.Flags().StringVar(&kwargs.DestDir, "init-path", ".", "where to create the role")
+	roleInitCmd.Flags().StringVar(&kwargs.Skeleton, "role-skeleton", "", "a skeleton directory to use instead of the built in one")
+	roleInitCmd.Flags().BoolVarP(&kwargs.Force, "force", "f", false, "write over a role directory that is already there")
+
+	collectionInitCmd.Flags().StringVar(&kwargs.DestDir, "init-path", ".", "where to create the collection")
+	collectionInitCmd.Flags().StringVar(&kwargs.Skeleton, "collection-skeleton", "", "a skeleton directory to use instead of the built in one")
+	collectionInitCmd.Flags().BoolVarP(&kwargs.Force, "force", "f", false, "write over a collection directory that is already there")
+
+	roleBuildCmd.Flags().StringVar(&kwargs.DestDir, "output-path", ".", "where to write the artifact")
+	roleBuildCmd.Flags().BoolVarP(&kwargs.Force, "force", "f", false, "replace an artifact that is already there")
+	roleBuildCmd.Flags().StringVar(&kwargs.Namespace, "namespace", "", "namespace, instead of galaxy_info.namespace")
+	roleBuildCmd.Flags().StringVar(&kwargs.Name, "name", "", "name, instead of galaxy_info.role_name")
+	roleBuildCmd.Flags().StringVar(&kwargs.Version, "version", "", "version, instead of galaxy_info.version")
+
+	collectionBuildCmd.Flags().StringVar(&kwargs.DestDir, "output-path", ".", "where to write the artifact")
+	collectionBuildCmd.Flags().BoolVarP(&kwargs.Force, "force", "f", false, "replace an artifact that is already there")
+
+	collectionInfoCmd.Flags().StringVar(&kwargs.Server, "server", "https://github.com", "server")
+	collectionInfoCmd.Flags().StringVar(&kwargs.Namespace, "namespace", "", "namespace")
+	collectionInfoCmd.Flags().StringVar(&kwargs.Name, "name", "", "name")
+	collectionInfoCmd.Flags().StringVar(&kwargs.Version, "version", "", "version")
+	collectionInfoCmd.Flags().StringVar(&kwargs.DestDir, "dest", defaultDestDir, "where collections are installed")
+	collectionInfoCmd.Flags().StringVar(&kwargs.CacheDir, "cachedir", defaultCacheDir, "where to store intermediate files")
+
+	for _, c := range []*cobra.Command{collectionDepsCmd, collectionRDepsCmd} {
+		c.Flags().StringVar(&kwargs.Server, "server", "https://github.com", "server")
+		c.Flags().StringVar(&kwargs.Namespace, "namespace", "", "namespace")
+		c.Flags().StringVar(&kwargs.Name, "name", "", "name")
+		c.Flags().StringVar(&kwargs.Version, "version", "", "version")
+		c.Flags().StringVar(&kwargs.DestDir, "dest", defaultDestDir, "where collections are installed")
+		c.Flags().StringVar(&kwargs.CacheDir, "cachedir", defaultCacheDir, "where to store intermediate files")
+		c.Flags().StringVar(&kwargs.OutputFormat, "output", "text", "output format (text, json or dot)")
+	}
+	collectionDepsCmd.Flags().BoolVar(&kwargs.ShowTree, "tree", false, "render the dependencies as a tree")
+
+	roleInfoCmd.Flags().StringVar(&kwargs.Server, "server", "https://github.com", "server")
+	roleInfoCmd.Flags().StringVar(&kwargs.Namespace, "namespace", "", "namespace")
+	roleInfoCmd.Flags().StringVar(&kwargs.Name, "name", "", "name")
+	roleInfoCmd.Flags().StringVar(&kwargs.Version, "version", "", "version")
+	roleInfoCmd.Flags().StringVar(&kwargs.DestDir, "dest", defaultDestDir, "where roles are installed")
+	roleInfoCmd.Flags().StringVar(&kwargs.CacheDir, "cachedir", defaultCacheDir, "where to store intermediate files")
 
 	syncCmd.Flags().StringVar(&kwargs.Server, "server", "https://galaxy.ansible.com", "remote server")
 	syncCmd.Flags().StringVar(&kwargs.DestDir, "dest", "", "where to store the data")
@@ -179,12 +469,12 @@ func Execute() {
 	syncCmd.Flags().IntVar(&kwargs.DownloadConcurrency, "concurrency", 1, "concurrency")
 	syncCmd.Flags().BoolVar(&kwargs.LatestOnly, "latest", false, "get only the latest version")
 	syncCmd.Flags().StringVarP(&kwargs.RequirementsFile, "requirements", "r", "", "requirements file")
-	syncCmd.Flags().BoolVar(&kwargs.Verbose, "verbose", false, "use debug output")
 	syncCmd.MarkFlagRequired("dest")
 
 	crcSyncCmd.Flags().StringVar(&kwargs.Server, "server", "", "remote server")
 	crcSyncCmd.Flags().StringVar(&kwargs.AuthUrl, "auth_url", "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token", "auth url")
-	crcSyncCmd.Flags().StringVar(&kwargs.Token, "token", "", "authentication token")
+	crcSyncCmd.Flags().StringVar(&kwargs.Token, "token", "", "refresh token (default $LAX_TOKEN)")
+	crcSyncCmd.Flags().StringVar(&kwargs.TokenFile, "token-file", "", "read the refresh token from this file")
 	crcSyncCmd.Flags().StringVar(&kwargs.DestDir, "dest", "", "where to store the data")
 	crcSyncCmd.Flags().BoolVar(&kwargs.CollectionsOnly, "collections", false, "just sync collections")
 	crcSyncCmd.Flags().BoolVar(&kwargs.RolesOnly, "roles", false, "just sync roles")
@@ -195,27 +485,67 @@ func Execute() {
 	crcSyncCmd.Flags().IntVar(&kwargs.DownloadConcurrency, "concurrency", 1, "concurrency")
 	crcSyncCmd.Flags().BoolVar(&kwargs.LatestOnly, "latest", false, "get only the latest version")
 	crcSyncCmd.Flags().StringVarP(&kwargs.RequirementsFile, "requirements", "r", "", "requirements file")
-	crcSyncCmd.Flags().BoolVar(&kwargs.Verbose, "verbose", false, "use debug output")
 	crcSyncCmd.MarkFlagRequired("dest")
 
-	roleCmd.AddCommand(initCmd)
+	roleCmd.AddCommand(roleInitCmd)
 	roleCmd.AddCommand(roleInstallCmd)
+	roleCmd.AddCommand(roleInfoCmd)
+	roleCmd.AddCommand(roleBuildCmd)
 
-	collectionCmd.AddCommand(initCmd)
+	collectionCmd.AddCommand(collectionInitCmd)
 	collectionCmd.AddCommand(collectionInstallCmd)
+	collectionCmd.AddCommand(collectionBuildCmd)
+	collectionCmd.AddCommand(collectionInfoCmd)
+	collectionCmd.AddCommand(collectionDepsCmd)
+	collectionCmd.AddCommand(collectionRDepsCmd)
 
-	//repoCmd.AddCommand(initCmd)
-	//repoCmd.AddCommand(installCmd)
+	repoCmd.AddCommand(repoLintCmd)
+	repoCmd.AddCommand(repoClosureCmd)
+	repoCmd.AddCommand(repoDiffCmd)
+	repoCmd.AddCommand(repoSnapshotCmd)
+	repoCmd.AddCommand(repoPromoteCmd)
+	repoCmd.AddCommand(repoPruneCmd)
 
 	rootCmd.AddCommand(createRepoCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(publishCmd)
 	rootCmd.AddCommand(syncCmd)
 	rootCmd.AddCommand(crcSyncCmd)
 	rootCmd.AddCommand(roleCmd)
 	rootCmd.AddCommand(collectionCmd)
-	//rootCmd.AddCommand(repoCmd)
+	rootCmd.AddCommand(repoCmd)
+
+	return rootCmd
+}
+
+func Execute() {
+	rootCmd := NewRootCmd()
+
+	// anything cobra rejects before a command starts running (unknown
+	// commands, bad args, missing required flags) is a usage error
+	started := false
+	markStarted(rootCmd, &started)
 
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		if !started && !errors.Is(err, laxerrors.ErrUsage) {
+			err = laxerrors.Wrap(laxerrors.ErrUsage, err, "%s", rootCmd.CommandPath())
+		}
+		logrus.Errorf("%s", err)
+		os.Exit(laxerrors.ExitCode(err))
+	}
+}
+
+// wrap every RunE in the tree so Execute can tell cobra's own validation
+// errors apart from errors returned by the commands themselves
+func markStarted(cmd *cobra.Command, started *bool) {
+	for _, c := range cmd.Commands() {
+		if c.RunE != nil {
+			run := c.RunE
+			c.RunE = func(cmd *cobra.Command, args []string) error {
+				*started = true
+				return run(cmd, args)
+			}
+		}
+		markStarted(c, started)
 	}
 }
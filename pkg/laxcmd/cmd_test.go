@@ -0,0 +1,84 @@
+package laxcmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jctanner/lax/internal/collections"
+	"github.com/jctanner/lax/internal/repository"
+	"github.com/jctanner/lax/internal/roles"
+	"github.com/jctanner/lax/internal/testutil"
+	"github.com/jctanner/lax/internal/types"
+)
+
+// runCmd runs the lax command line with args and returns what it printed
+func runCmd(t *testing.T, args ...string) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	rootCmd := NewRootCmd()
+	rootCmd.SetArgs(args)
+	runErr := rootCmd.Execute()
+	w.Close()
+	out, _ := io.ReadAll(r)
+	if runErr != nil {
+		t.Fatalf("lax %s: %v", strings.Join(args, " "), runErr)
+	}
+	return string(out)
+}
+
+func TestInfoDefaultDest(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	src := t.TempDir()
+	repo := t.TempDir()
+	testutil.WriteTree(t, filepath.Join(src, "tools"), map[string]string{
+		"galaxy.yml": "namespace: acme\nname: tools\nversion: 1.1.0\nreadme: README.md\nauthors: [me]\n",
+		"README.md":  "# tools",
+	})
+	if _, err := collections.BuildCollection(filepath.Join(src, "tools"), filepath.Join(repo, "collections"), false); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WriteTree(t, filepath.Join(src, "web"), map[string]string{
+		"meta/main.yml":  "galaxy_info:\n  author: me\n  namespace: acme\n  role_name: web\n  version: 1.2.0\n",
+		"tasks/main.yml": "- debug: msg=hi\n",
+	})
+	if _, err := roles.BuildRole(filepath.Join(src, "web"), filepath.Join(repo, "roles"), roles.BuildOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.CreateRepo(&types.CmdKwargs{DestDir: repo}); err != nil {
+		t.Fatal(err)
+	}
+
+	// installed into the default ~/.ansible
+	testutil.WriteTree(t, filepath.Join(home, ".ansible"), map[string]string{
+		"collections/ansible_collections/acme/tools/MANIFEST.json": `{"collection_info": {"namespace": "acme", "name": "tools", "version": "1.1.0"}}`,
+		"roles/acme.web/meta/.galaxy_install_info":                 "version: 1.2.0\n",
+	})
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"collection", "info", "acme.tools", "--server", repo}, "1.1.0 (" + filepath.Join(home, ".ansible", "collections")},
+		{[]string{"role", "info", "acme.web", "--server", repo}, "1.2.0 (" + filepath.Join(home, ".ansible", "roles")},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args[:2], " "), func(t *testing.T) {
+			out := runCmd(t, tt.args...)
+			if !strings.Contains(out, "Installed:      "+tt.expected) {
+				t.Errorf("expected the install in the default dest, got\n%s", out)
+			}
+		})
+	}
+}
@@ -71,21 +71,17 @@ func TestLaxInstallFromHttp(t *testing.T) {
 	os.Mkdir(repoDir, 0755)
 	//defer os.RemoveAll(tempDir)
 
-	// Make a collection (use ansible[-core] for now ...)
-	galaxy_cli := "ansible-galaxy"
-	if !commandExists(galaxy_cli) {
-		// fmt.Printf("%s is not available in PATH\n", galaxy_cli)
-		t.Fatalf("%s command is not available in PATH\n", galaxy_cli)
-	}
+	// Make a collection
 	t.Log("collection init")
-	initErr := runCommandInDir("ansible-galaxy", []string{"collection", "init", "testn.col"}, repoDir)
+	collectionDir := tempDir + "/src/testn/col"
+	initErr := runCommandInDir(laxCmd, []string{"collection", "init", "--init-path=" + tempDir + "/src", "testn.col"}, tempDir)
 	if initErr != nil {
 		t.Fatalf("init failed: %v", initErr)
 	}
 	t.Log("collection build")
-	buildErr := runCommandInDir("ansible-galaxy", []string{"collection", "build", "--output-path=" + repoDir + "/collections", "."}, repoDir+"/"+"testn"+"/"+"col")
+	buildErr := runCommandInDir(laxCmd, []string{"collection", "build", "--output-path=" + repoDir + "/collections", "."}, collectionDir)
 	if buildErr != nil {
-		t.Fatalf("init failed: %v", buildErr)
+		t.Fatalf("build failed: %v", buildErr)
 	}
 
 	// create the lax repo ... ?